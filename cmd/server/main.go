@@ -2,30 +2,146 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/optongroup/kaspersky-safeboard-go-container-security/internal/config"
+	"github.com/optongroup/kaspersky-safeboard-go-container-security/internal/cron"
 	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
 	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+	"github.com/optongroup/kaspersky-safeboard-go-container-security/internal/tracing"
 )
 
+// Task types served by this deployment besides q.SimulateTaskType.
+const (
+	imageScanTaskType    = "image_scan"
+	reportTaskType       = "report"
+	notificationTaskType = "notification"
+)
+
+// newLogger builds the structured logger selected by LOG_FORMAT and LOG_LEVEL.
+func newLogger(cfg config.Config) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: cfg.LogLevel}
+	if cfg.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
 func main() {
 	cfg := config.Load()
-	_ = cfg // will be used in next steps
+	logger := newLogger(cfg)
+	slog.SetDefault(logger)
+
+	// Spans go to TRACE_FILE; without it trace context is still propagated to handlers
+	var tracer *tracing.Tracer
+	if cfg.TraceFile != "" {
+		exporter, err := tracing.NewFileExporter(cfg.TraceFile)
+		if err != nil {
+			fatal("open trace file", err)
+		}
+		defer func() {
+			if err := exporter.Close(); err != nil {
+				logger.Error("trace file close error", "err", err)
+			}
+		}()
+		tracer = tracing.NewTracer(exporter)
+	}
 
 	// Initialize queue and store
-	store := q.NewStore()
-	queueCh := make(chan q.Task, cfg.QueueSize)
+	var store q.Store = q.NewStore()
+	var recovered []q.Task
+	if cfg.DataDir != "" {
+		fileStore, err := q.OpenFileStore(cfg.DataDir, q.FileStoreOptions{})
+		if err != nil {
+			fatal("open data dir", err)
+		}
+		defer func() {
+			if err := fileStore.Close(); err != nil {
+				logger.Error("store close error", "err", err)
+			}
+		}()
+		store = fileStore
+		recovered = fileStore.Recovered()
+		logger.Info("recovered pending tasks", "count", len(recovered), "data_dir", cfg.DataDir)
+	}
+	specs := make([]q.QueueSpec, 0, len(cfg.Queues))
+	for _, qc := range cfg.Queues {
+		specs = append(specs, q.QueueSpec{
+			Name:        qc.Name,
+			Capacity:    qc.Size,
+			Workers:     qc.Workers,
+			MaxRetries:  qc.MaxRetries,
+			BackoffBase: qc.BackoffBase,
+		})
+	}
+	queues := q.NewQueueSet(specs, cfg.PriorityAging)
 	var accepting atomic.Bool
 	accepting.Store(true)
 
-	handler := httpserver.NewHandlerWithDeps(store, queueCh, &accepting)
+	// Register task handlers; each type gets its own default retry/timeout policy. Until real
+	// handlers are plugged in, the production types run the simulation handler.
+	seed := time.Now().UnixNano()
+	simulate := q.NewSimulateHandler(seed)
+	registry := q.NewRegistry()
+	registry.RegisterType(q.SimulateTaskType, simulate, q.TypePolicy{MaxRetries: 2, Timeout: 2 * time.Second})
+	registry.RegisterType(imageScanTaskType, simulate, q.TypePolicy{MaxRetries: 3, Timeout: 2 * time.Minute})
+	registry.RegisterType(reportTaskType, simulate, q.TypePolicy{MaxRetries: 1, Timeout: 5 * time.Minute})
+	registry.RegisterType(notificationTaskType, simulate, q.TypePolicy{MaxRetries: 5, Timeout: 10 * time.Second})
+
+	scheduler := q.NewScheduler(store, queues)
+
+	// Tasks that exhaust their retries are kept for inspection and manual redrive
+	var deadLetterPath string
+	if cfg.DataDir != "" {
+		deadLetterPath = filepath.Join(cfg.DataDir, "deadletters.json")
+	}
+	deadLetters, err := q.NewDeadLetterQueue(deadLetterPath)
+	if err != nil {
+		fatal("load dead letters", err)
+	}
+
+	// Running tasks are tracked so that they can be canceled over HTTP
+	canceler := q.NewCanceler(deadLetters)
+
+	// Recurring jobs; their definitions persist next to the task store when DATA_DIR is set
+	cronOpts := cron.Options{Logger: logger, Registry: registry}
+	if cfg.DataDir != "" {
+		cronOpts.StatePath = filepath.Join(cfg.DataDir, "cron.json")
+	}
+	cronManager, err := cron.NewManager(store, queues, cronOpts)
+	if err != nil {
+		fatal("load cron jobs", err)
+	}
+
+	handler := httpserver.NewHandlerWithOptions(httpserver.Options{
+		Store:       store,
+		Queues:      queues,
+		Accepting:   &accepting,
+		Registry:    registry,
+		DefaultType: q.SimulateTaskType,
+		Scheduler:   scheduler,
+		Cron:        cronManager,
+		DeadLetters: deadLetters,
+		Canceler:    canceler,
+		MaxTimeout:  cfg.MaxTaskTimeout,
+
+		IdempotencyWindow: cfg.IdempotencyWindow,
+		Logger:            logger,
+		Tracer:            tracer,
+	})
 	srv := httpserver.NewWithHandler(":8080", handler)
 
 	var wg sync.WaitGroup
@@ -35,9 +151,52 @@ func main() {
 	// Start HTTP server
 	srv.Start()
 
-	// Start workers
-	seed := time.Now().UnixNano()
-	q.StartWorkers(ctx, &wg, store, queueCh, cfg.Workers, seed)
+	// Start one worker pool per queue
+	queues.Start(ctx, &wg, store, q.WorkerConfig{
+		Registry:       registry,
+		Seed:           seed,
+		DeadLetters:    deadLetters,
+		AttemptHistory: cfg.AttemptHistory,
+		MaxResultSize:  cfg.ResultMaxBytes,
+		Canceler:       canceler,
+		MaxTimeout:     cfg.MaxTaskTimeout,
+		Retries:        scheduler,
+		Logger:         logger,
+		Tracer:         tracer,
+		RetryPolicy: q.RetryPolicy{
+			Strategy: q.RetryStrategy(cfg.RetryStrategy),
+			Base:     cfg.RetryBase,
+			Jitter:   cfg.RetryJitter,
+			MaxDelay: cfg.RetryMaxDelay,
+		},
+	})
+
+	// Evict task results once their retention expires
+	q.StartResultJanitor(ctx, &wg, store, cfg.ResultTTL, time.Minute)
+
+	// Start scheduler for delayed tasks and retries; on shutdown pending ones stay in the store
+	// as scheduled or retrying
+	scheduler.Start(ctx, &wg)
+
+	// Start cron ticker
+	cronManager.Start(ctx, &wg)
+
+	// Put recovered tasks back; enqueueing may block until workers free up capacity
+	go func() {
+		for _, t := range recovered {
+			if _, ok := queues.Resolve(t.Queue); !ok {
+				// the queue was removed from configuration since the task was accepted
+				t.Queue = queues.DefaultName()
+			}
+			if t.Status == q.StatusScheduled || t.Status == q.StatusRetrying {
+				scheduler.Schedule(t)
+				continue
+			}
+			if !q.PushWithContext(ctx, queues, t, 10*time.Millisecond) {
+				return
+			}
+		}
+	}()
 
 	// Handle OS signals for graceful shutdown
 	sigCh := make(chan os.Signal, 1)
@@ -45,7 +204,7 @@ func main() {
 
 	<-sigCh
 	// Begin shutdown
-	log.Println("Shutting down...")
+	logger.Info("shutting down")
 	// stop accepting new tasks immediately
 	accepting.Store(false)
 
@@ -53,12 +212,12 @@ func main() {
 	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 5*time.Second)
 	defer shutdownCancel()
 	if err := srv.Shutdown(shutdownCtx); err != nil {
-		log.Printf("HTTP shutdown error: %v", err)
+		logger.Error("HTTP shutdown error", "err", err)
 	}
 
 	// cancel workers and retries
 	cancel()
 
 	wg.Wait()
-	log.Println("Stopped")
+	logger.Info("stopped")
 }
@@ -1,27 +1,107 @@
 package config
 
 import (
+	"log/slog"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Default configuration values
 const (
 	DefaultWorkers   = 4
 	DefaultQueueSize = 64
+	DefaultQueueName = "default"
+	// DefaultAttemptHistory matches queue.DefaultAttemptHistory.
+	DefaultAttemptHistory = 10
+	// DefaultResultMaxBytes matches queue.DefaultMaxResultSize.
+	DefaultResultMaxBytes = 1 << 20
+	DefaultResultTTL      = 24 * time.Hour
+	// DefaultMaxTaskTimeout leaves attempts of tasks without a timeout unbounded.
+	DefaultMaxTaskTimeout time.Duration = 0
+	// DefaultRetryStrategy, DefaultRetryBase and DefaultRetryJitter match queue.DefaultRetryPolicy.
+	DefaultRetryStrategy = "exponential"
+	DefaultRetryBase     = 200 * time.Millisecond
+	DefaultRetryJitter   = 100 * time.Millisecond
+	DefaultRetryMaxDelay = 5 * time.Minute
+	// DefaultIdempotencyWindow matches queue.DefaultIdempotencyWindow.
+	DefaultIdempotencyWindow = 24 * time.Hour
+	DefaultLogFormat         = "text"
+	DefaultLogLevel          = slog.LevelInfo
 )
 
+// retryStrategies are the accepted values of RETRY_STRATEGY (see queue.RetryStrategies).
+var retryStrategies = []string{"fixed", "linear", "exponential", "full_jitter", "decorrelated_jitter"}
+
+// logFormats are the accepted values of LOG_FORMAT.
+var logFormats = []string{"text", "json"}
+
+// QueueConfig declares one named queue.
+type QueueConfig struct {
+	Name    string
+	Size    int
+	Workers int
+	// MaxRetries caps task retries in this queue; -1 means no cap.
+	MaxRetries int
+	// BackoffBase overrides the base retry delay; zero keeps the default.
+	BackoffBase time.Duration
+}
+
 // Config holds application configuration loaded from environment variables.
 type Config struct {
 	Workers   int
 	QueueSize int
+	// PriorityAging raises the priority of a waiting task by one level per interval; zero disables aging.
+	PriorityAging time.Duration
+	// Queues declares the named queues. Without QUEUES it holds a single "default" queue
+	// sized by QueueSize and Workers.
+	Queues []QueueConfig
+	// DataDir enables the durable file-backed store when non-empty; otherwise tasks are kept in memory.
+	DataDir string
+	// AttemptHistory is the number of attempts kept in each task's history.
+	AttemptHistory int
+	// ResultMaxBytes bounds a stored task result; larger results fail the task.
+	ResultMaxBytes int
+	// ResultTTL is how long results are kept after a task is done; zero keeps them forever.
+	ResultTTL time.Duration
+	// MaxTaskTimeout caps every attempt, including tasks without a timeout; zero disables the cap.
+	MaxTaskTimeout time.Duration
+	// RetryStrategy, RetryBase and RetryJitter form the retry policy of tasks whose type or
+	// request sets none.
+	RetryStrategy string
+	RetryBase     time.Duration
+	RetryJitter   time.Duration
+	// RetryMaxDelay caps the delay before any retry, whatever its policy; zero disables the cap.
+	RetryMaxDelay time.Duration
+	// IdempotencyWindow is how long an idempotency key returns the task it created.
+	IdempotencyWindow time.Duration
+	// LogFormat is the output of the structured logger: "text" or "json".
+	LogFormat string
+	// LogLevel is the minimum level logged.
+	LogLevel slog.Level
+	// TraceFile receives finished spans as JSON lines; empty disables span export.
+	TraceFile string
 }
 
 // Load reads configuration from environment with defaults and minimal validation.
 func Load() Config {
 	cfg := Config{
-		Workers:   DefaultWorkers,
-		QueueSize: DefaultQueueSize,
+		Workers:        DefaultWorkers,
+		QueueSize:      DefaultQueueSize,
+		AttemptHistory: DefaultAttemptHistory,
+		ResultMaxBytes: DefaultResultMaxBytes,
+		ResultTTL:      DefaultResultTTL,
+		MaxTaskTimeout: DefaultMaxTaskTimeout,
+		RetryStrategy:  DefaultRetryStrategy,
+		RetryBase:      DefaultRetryBase,
+		RetryJitter:    DefaultRetryJitter,
+		RetryMaxDelay:  DefaultRetryMaxDelay,
+
+		IdempotencyWindow: DefaultIdempotencyWindow,
+		LogFormat:         DefaultLogFormat,
+		LogLevel:          DefaultLogLevel,
 	}
 
 	
@@ -35,6 +115,111 @@ func Load() Config {
 			cfg.QueueSize = n
 		}
 	}
+	if v := os.Getenv("PRIORITY_AGING"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.PriorityAging = d
+		}
+	}
+	if v := os.Getenv("DATA_DIR"); v != "" {
+		cfg.DataDir = v
+	}
+	if v := os.Getenv("ATTEMPT_HISTORY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.AttemptHistory = n
+		}
+	}
+	if v := os.Getenv("RESULT_MAX_BYTES"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.ResultMaxBytes = n
+		}
+	}
+	if v := os.Getenv("RESULT_TTL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.ResultTTL = d
+		}
+	}
+	if v := os.Getenv("MAX_TASK_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.MaxTaskTimeout = d
+		}
+	}
+	if v := os.Getenv("RETRY_STRATEGY"); v != "" && slices.Contains(retryStrategies, v) {
+		cfg.RetryStrategy = v
+	}
+	if v := os.Getenv("RETRY_BASE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.RetryBase = d
+		}
+	}
+	if v := os.Getenv("RETRY_JITTER"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.RetryJitter = d
+		}
+	}
+	if v := os.Getenv("RETRY_MAX_DELAY"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			cfg.RetryMaxDelay = d
+		}
+	}
+	if cfg.RetryMaxDelay > 0 && cfg.RetryMaxDelay < cfg.RetryBase {
+		cfg.RetryMaxDelay = cfg.RetryBase
+	}
+	if v := os.Getenv("IDEMPOTENCY_WINDOW"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			cfg.IdempotencyWindow = d
+		}
+	}
+	if v := os.Getenv("LOG_FORMAT"); v != "" && slices.Contains(logFormats, strings.ToLower(v)) {
+		cfg.LogFormat = strings.ToLower(v)
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(v)); err == nil {
+			cfg.LogLevel = level
+		}
+	}
+	cfg.TraceFile = os.Getenv("TRACE_FILE")
+	if v := os.Getenv("QUEUES"); v != "" {
+		cfg.Queues = parseQueues(v)
+	}
+	if len(cfg.Queues) == 0 {
+		cfg.Queues = []QueueConfig{{Name: DefaultQueueName, Size: cfg.QueueSize, Workers: cfg.Workers, MaxRetries: -1}}
+	}
 
 	return cfg
 }
+
+// parseQueues parses QUEUES: a comma-separated list of
+// name:size:workers[:max_retries[:backoff_base]], e.g. "critical:64:8:5:100ms,default:256:4,bulk:1024:2:0".
+// Malformed entries and repeated names are skipped.
+func parseQueues(v string) []QueueConfig {
+	var out []QueueConfig
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(v, ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 3 || len(parts) > 5 || parts[0] == "" || seen[parts[0]] {
+			continue
+		}
+		qc := QueueConfig{Name: parts[0], MaxRetries: -1}
+		var err error
+		if qc.Size, err = strconv.Atoi(parts[1]); err != nil || qc.Size <= 0 {
+			continue
+		}
+		if qc.Workers, err = strconv.Atoi(parts[2]); err != nil || qc.Workers <= 0 {
+			continue
+		}
+		if len(parts) > 3 {
+			if qc.MaxRetries, err = strconv.Atoi(parts[3]); err != nil || qc.MaxRetries < -1 {
+				continue
+			}
+		}
+		if len(parts) > 4 {
+			if qc.BackoffBase, err = time.ParseDuration(parts[4]); err != nil || qc.BackoffBase < 0 {
+				continue
+			}
+		}
+		seen[qc.Name] = true
+		out = append(out, qc)
+	}
+	return out
+}
@@ -0,0 +1,393 @@
+package cron
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+var (
+	// ErrNotFound is returned when a job id is unknown.
+	ErrNotFound = errors.New("cron job not found")
+	// ErrDuplicate is returned when adding a job with an id that already exists.
+	ErrDuplicate = errors.New("duplicate cron job id")
+	// ErrUnknownType is returned for a job whose task type has no handler.
+	ErrUnknownType = errors.New("unknown task type")
+	// ErrUnknownQueue is returned for a job naming an undeclared queue.
+	ErrUnknownQueue = errors.New("unknown queue")
+)
+
+// MissedPolicy decides what happens to fire times missed while the service was down.
+type MissedPolicy string
+
+const (
+	// MissedSkip drops missed fires; the job next runs at its next regular time.
+	MissedSkip MissedPolicy = "skip"
+	// MissedCatchUp runs the job once right after startup to cover all missed fires.
+	MissedCatchUp MissedPolicy = "catch_up"
+)
+
+// Job is a recurring task definition. Each fire materialises an ordinary queue.Task with the
+// policy of its type; MaxRetries, when set, overrides the type's retries.
+type Job struct {
+	ID           string          `json:"id"`
+	Schedule     string          `json:"schedule"`
+	Type         string          `json:"type"`
+	Queue        string          `json:"queue,omitempty"`
+	Payload      json.RawMessage `json:"payload"`
+	MaxRetries   *int            `json:"max_retries,omitempty"`
+	MissedPolicy MissedPolicy    `json:"missed_policy"`
+	// AllowOverlap fires even when the task of the previous run has not finished yet.
+	AllowOverlap bool       `json:"allow_overlap"`
+	NextRun      time.Time  `json:"next_run"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastTaskID   string     `json:"last_task_id,omitempty"`
+	SkippedRuns  uint64     `json:"skipped_runs"`
+	CreatedAt    time.Time  `json:"created_at"`
+
+	schedule Schedule
+}
+
+// Options configures a Manager.
+type Options struct {
+	// StatePath persists job definitions and run state as JSON; empty keeps them in memory only.
+	StatePath string
+	// Interval is the tick period of the fire loop (default 1s).
+	Interval time.Duration
+	// Now overrides the clock, mainly for tests.
+	Now func() time.Time
+	// Logger receives fired and skipped runs; nil uses slog.Default().
+	Logger *slog.Logger
+	// Registry, when set, restricts jobs to registered task types and supplies the policy
+	// (retries, timeout, retry policy, uniqueness) of the tasks they fire.
+	Registry *q.Registry
+}
+
+// queueResolver is implemented by pushers that route by queue name, such as q.QueueSet.
+type queueResolver interface {
+	Resolve(name string) (string, bool)
+}
+
+// Manager owns the recurring jobs and fires them into the task queue.
+type Manager struct {
+	store q.Store
+	queue q.Pusher
+	opts  Options
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewManager creates a manager and loads persisted jobs from opts.StatePath, if any.
+// Fire times missed while the service was down are resolved by each job's MissedPolicy.
+func NewManager(store q.Store, queue q.Pusher, opts Options) (*Manager, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	m := &Manager{store: store, queue: queue, opts: opts, jobs: make(map[string]*Job)}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Add validates and registers a new job; an empty ID is generated.
+func (m *Manager) Add(j Job) (Job, error) {
+	if j.ID == "" {
+		j.ID = generateID()
+	}
+	if err := m.prepare(&j); err != nil {
+		return Job{}, err
+	}
+	now := m.opts.Now().UTC()
+	j.CreatedAt = now
+	j.LastRun, j.LastTaskID, j.SkippedRuns = nil, "", 0
+	j.NextRun = j.schedule.Next(now)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.jobs[j.ID]; exists {
+		return Job{}, ErrDuplicate
+	}
+	m.jobs[j.ID] = &j
+	m.persist()
+	return j, nil
+}
+
+// Update replaces the definition of an existing job, keeping its run history.
+func (m *Manager) Update(id string, j Job) (Job, error) {
+	j.ID = id
+	if err := m.prepare(&j); err != nil {
+		return Job{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	j.CreatedAt, j.LastRun, j.LastTaskID, j.SkippedRuns = old.CreatedAt, old.LastRun, old.LastTaskID, old.SkippedRuns
+	j.NextRun = j.schedule.Next(m.opts.Now().UTC())
+	m.jobs[id] = &j
+	m.persist()
+	return j, nil
+}
+
+// Remove deletes a job and reports whether it existed.
+func (m *Manager) Remove(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[id]; !ok {
+		return false
+	}
+	delete(m.jobs, id)
+	m.persist()
+	return true
+}
+
+// Get returns a job by id.
+func (m *Manager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// List returns all jobs ordered by id.
+func (m *Manager) List() []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		out = append(out, *j)
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].ID < out[k].ID })
+	return out
+}
+
+// Start runs the fire loop until ctx is done.
+func (m *Manager) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(m.opts.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.Tick(m.opts.Now())
+			}
+		}
+	}()
+}
+
+// Tick fires every job due at now. It is driven by the loop started with Start and is
+// exported so callers can drive the manager with their own clock.
+func (m *Manager) Tick(now time.Time) {
+	now = now.UTC()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	changed := false
+	for _, j := range m.jobs {
+		if j.NextRun.IsZero() || j.NextRun.After(now) {
+			continue
+		}
+		m.fire(j, now)
+		j.NextRun = j.schedule.Next(now)
+		changed = true
+	}
+	if changed {
+		m.persist()
+	}
+}
+
+// fire materialises one run of j. Must be called with m.mu held.
+func (m *Manager) fire(j *Job, now time.Time) {
+	if !j.AllowOverlap && j.LastTaskID != "" {
+		if prev, ok := m.store.Get(j.LastTaskID); ok && !prev.Status.Finished() {
+			j.SkippedRuns++
+			m.opts.Logger.Info("cron run skipped", "cron_job", j.ID, "reason", "overlap", "task_id", prev.ID, "status", prev.Status)
+			return
+		}
+	}
+	task := m.newTask(j)
+	if task.UniqueKey != "" {
+		if existing, ok := m.store.FindActiveUnique(task.UniqueKey); ok {
+			j.SkippedRuns++
+			m.opts.Logger.Info("cron run skipped", "cron_job", j.ID, "reason", "duplicate", "task_id", existing.ID, "status", existing.Status)
+			return
+		}
+	}
+	// save first so a fast worker never updates a task the store does not know yet
+	m.store.Save(task)
+	if !m.queue.TryPush(task) {
+		m.store.Delete(task.ID)
+		j.SkippedRuns++
+		m.opts.Logger.Warn("cron run skipped", "cron_job", j.ID, "reason", "queue_full")
+		return
+	}
+	j.LastRun = &now
+	j.LastTaskID = task.ID
+	m.opts.Logger.Info("task enqueued", "cron_job", j.ID, "task_id", task.ID, "task_type", task.Type, "queue", task.Queue)
+}
+
+// newTask materialises one run of j with the policy of its task type, the way POST /enqueue
+// applies it: the job's MaxRetries overrides the type's default.
+func (m *Manager) newTask(j *Job) q.Task {
+	var policy q.TypePolicy
+	if m.opts.Registry != nil {
+		policy = m.opts.Registry.Policy(j.Type)
+	}
+	maxRetries := policy.MaxRetries
+	if j.MaxRetries != nil {
+		maxRetries = *j.MaxRetries
+	}
+	task := q.NewTask(j.Payload, maxRetries)
+	task.Type = j.Type
+	task.Queue = j.Queue
+	task.Timeout = policy.Timeout
+	task.Retry = policy.Retry
+	if policy.Unique {
+		task.UniqueKey = q.UniqueKey(task.Type, task.Payload)
+	}
+	return task
+}
+
+// prepare normalises and validates a job definition.
+func (m *Manager) prepare(j *Job) error {
+	sched, err := Parse(j.Schedule)
+	if err != nil {
+		return err
+	}
+	if sched.Next(m.opts.Now().UTC()).IsZero() {
+		return fmt.Errorf("%w: schedule never fires", ErrInvalidSpec)
+	}
+	j.schedule = sched
+	switch j.MissedPolicy {
+	case "":
+		j.MissedPolicy = MissedSkip
+	case MissedSkip, MissedCatchUp:
+	default:
+		return fmt.Errorf("unknown missed_policy %q", j.MissedPolicy)
+	}
+	if j.MaxRetries != nil && *j.MaxRetries < 0 {
+		zero := 0
+		j.MaxRetries = &zero
+	}
+	if strings.TrimSpace(j.ID) == "" {
+		return errors.New("id required")
+	}
+	if m.opts.Registry != nil {
+		if _, ok := m.opts.Registry.Lookup(j.Type); !ok {
+			return fmt.Errorf("%w %q", ErrUnknownType, j.Type)
+		}
+	}
+	if r, ok := m.queue.(queueResolver); ok {
+		name, ok := r.Resolve(j.Queue)
+		if !ok {
+			return fmt.Errorf("%w %q", ErrUnknownQueue, j.Queue)
+		}
+		j.Queue = name
+	}
+	return nil
+}
+
+// load restores persisted jobs and resolves fires missed during downtime.
+func (m *Manager) load() error {
+	if m.opts.StatePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(m.opts.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cron: read state: %w", err)
+	}
+	var jobs []Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return fmt.Errorf("cron: decode state: %w", err)
+	}
+	now := m.opts.Now().UTC()
+	for i := range jobs {
+		j := jobs[i]
+		sched, err := Parse(j.Schedule)
+		if err != nil {
+			return fmt.Errorf("cron: job %s: %w", j.ID, err)
+		}
+		j.schedule = sched
+		if r, ok := m.queue.(queueResolver); ok {
+			if _, ok := r.Resolve(j.Queue); !ok {
+				// the queue was removed from configuration since the job was created
+				def, _ := r.Resolve("")
+				m.opts.Logger.Warn("cron: job queue no longer declared", "cron_job", j.ID, "queue", j.Queue, "using", def)
+				j.Queue = def
+			}
+		}
+		if !j.NextRun.IsZero() && j.NextRun.Before(now) && j.MissedPolicy != MissedCatchUp {
+			// skip: forget the missed fires; catch_up keeps the past NextRun so the first tick runs once
+			j.NextRun = sched.Next(now)
+		}
+		m.jobs[j.ID] = &j
+	}
+	return nil
+}
+
+// persist writes all jobs to the state file. Must be called with m.mu held.
+// Failures are logged: the in-memory state stays authoritative for the running process.
+func (m *Manager) persist() {
+	if m.opts.StatePath == "" {
+		return
+	}
+	jobs := make([]Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		jobs = append(jobs, *j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].ID < jobs[k].ID })
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		m.opts.Logger.Error("cron: encode state", "err", err)
+		return
+	}
+	tmp := m.opts.StatePath + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(m.opts.StatePath), 0o755); err != nil {
+		m.opts.Logger.Error("cron: create state dir", "err", err)
+		return
+	}
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		m.opts.Logger.Error("cron: write state", "err", err)
+		return
+	}
+	if err := os.Rename(tmp, m.opts.StatePath); err != nil {
+		m.opts.Logger.Error("cron: install state", "err", err)
+	}
+}
+
+func generateID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,401 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+	"github.com/optongroup/kaspersky-safeboard-go-container-security/internal/tracing"
+)
+
+// Batch limits: a buffered body (a JSON array, or NDJSON in atomic mode) is read whole, so it
+// is bounded in size and items. Streamed NDJSON is read line by line and only bounds the line
+// size.
+const (
+	maxBatchItems   = 10000
+	maxBatchBody    = 32 << 20
+	maxNDJSONLine   = 1 << 20
+	ndjsonMediaType = "application/x-ndjson"
+)
+
+// batchMode selects how /enqueue/batch treats items that cannot be enqueued.
+type batchMode string
+
+const (
+	// batchBestEffort enqueues every valid item that fits and reports the others.
+	batchBestEffort batchMode = "best_effort"
+	// batchAtomic enqueues all items or none: one invalid item or a lack of capacity
+	// rejects the whole batch.
+	batchAtomic batchMode = "atomic"
+)
+
+// Batch item statuses besides the queued and scheduled task statuses.
+const (
+	itemDuplicate = "duplicate"
+	itemRejected  = "rejected"
+	// itemAborted marks a valid item of an atomic batch that failed as a whole.
+	itemAborted = "aborted"
+)
+
+type batchItemResult struct {
+	Index  int        `json:"index"`
+	ID     string     `json:"id,omitempty"`
+	Status string     `json:"status"`
+	RunAt  *time.Time `json:"run_at,omitempty"`
+	// Replayed is set when an idempotency key matched an existing task; Status is then the
+	// current status of that task.
+	Replayed   bool   `json:"replayed,omitempty"`
+	Error      string `json:"error,omitempty"`
+	Message    string `json:"message,omitempty"`
+	ExistingID string `json:"existing_id,omitempty"`
+}
+
+func (r batchItemResult) accepted() bool {
+	return r.Replayed || r.Status == string(q.StatusQueued) || r.Status == string(q.StatusScheduled)
+}
+
+type batchResponse struct {
+	Mode     batchMode         `json:"mode"`
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Items    []batchItemResult `json:"items"`
+}
+
+func newBatchResponse(mode batchMode, items []batchItemResult) batchResponse {
+	resp := batchResponse{Mode: mode, Items: items}
+	for _, it := range items {
+		if it.accepted() {
+			resp.Accepted++
+		} else {
+			resp.Rejected++
+		}
+	}
+	return resp
+}
+
+func acceptedItem(i int, task q.Task, replayed bool) batchItemResult {
+	return batchItemResult{Index: i, ID: task.ID, Status: string(task.Status), RunAt: task.RunAt, Replayed: replayed}
+}
+
+func rejectedItem(i int, id string, errResp errorResponse) batchItemResult {
+	status := itemRejected
+	if errResp.Error == "duplicate_id" || errResp.Error == "duplicate_task" {
+		status = itemDuplicate
+	}
+	return batchItemResult{Index: i, ID: id, Status: status, Error: errResp.Error, Message: errResp.Message, ExistingID: errResp.ExistingID}
+}
+
+// registerBatchRoute mounts POST /enqueue/batch. The body is a JSON array of /enqueue
+// requests, or NDJSON with one request per line when sent as application/x-ndjson; the
+// response uses the same format and lists the outcome of every item in order. The mode
+// query parameter picks best_effort (default) or atomic enqueueing.
+func registerBatchRoute(mux *http.ServeMux, e *enqueuer, accepting *atomic.Bool) {
+	mux.HandleFunc("/enqueue/batch", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !accepting.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mode := batchMode(r.URL.Query().Get("mode"))
+		switch mode {
+		case "":
+			mode = batchBestEffort
+		case batchBestEffort, batchAtomic:
+		default:
+			writeError(w, http.StatusBadRequest, errorResponse{
+				Error:   "invalid_mode",
+				Message: fmt.Sprintf("mode must be %q or %q", batchBestEffort, batchAtomic),
+			})
+			return
+		}
+		if _, ok := e.queue.(q.BatchPusher); mode == batchAtomic && !ok {
+			writeError(w, http.StatusBadRequest, errorResponse{Error: "atomic_unsupported", Message: "the queue cannot take a batch atomically"})
+			return
+		}
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		ndjson := mediaType == ndjsonMediaType
+		defer r.Body.Close()
+
+		if mode == batchBestEffort && ndjson {
+			e.streamBatch(w, r)
+			return
+		}
+		var (
+			items   []json.RawMessage
+			errResp *errorResponse
+		)
+		r.Body = http.MaxBytesReader(w, r.Body, maxBatchBody)
+		if ndjson {
+			items, errResp = readNDJSONBatch(r)
+		} else {
+			items, errResp = readJSONBatch(r)
+		}
+		switch {
+		case errResp != nil && errResp.Error == "body_too_large":
+			writeError(w, http.StatusRequestEntityTooLarge, *errResp)
+			return
+		case errResp != nil:
+			writeError(w, http.StatusBadRequest, *errResp)
+			return
+		}
+		trace := traceHeadersOf(r)
+		if mode == batchAtomic {
+			status, results := e.enqueueAtomic(items, time.Now(), trace)
+			writeBatch(w, status, ndjson, newBatchResponse(mode, results))
+			return
+		}
+		results := make([]batchItemResult, len(items))
+		now := time.Now()
+		for i, raw := range items {
+			results[i] = e.enqueueItem(i, raw, now, trace)
+		}
+		writeBatch(w, http.StatusOK, ndjson, newBatchResponse(mode, results))
+	})
+}
+
+// decodeItem parses one batch item; a malformed item only rejects itself.
+func decodeItem(raw json.RawMessage) (enqueueRequest, *errorResponse) {
+	var req enqueueRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		return req, &errorResponse{Error: "invalid_json", Message: "invalid JSON"}
+	}
+	return req, nil
+}
+
+// enqueueItem validates and submits one item of a best-effort batch sent with the trace
+// context headers trace.
+func (e *enqueuer) enqueueItem(i int, raw json.RawMessage, now time.Time, trace traceHeaders) batchItemResult {
+	req, errResp := decodeItem(raw)
+	if errResp != nil {
+		return rejectedItem(i, "", *errResp)
+	}
+	req.trace = trace
+	task, replayed, errResp := e.enqueue(req, now)
+	if errResp != nil {
+		return rejectedItem(i, req.ID, *errResp)
+	}
+	return acceptedItem(i, task, replayed)
+}
+
+// enqueueAtomic enqueues every item or none. It returns 400 when an item is invalid and
+// 503 when the queues cannot take all immediate tasks at once. Items repeating an
+// idempotency key are answered with their original task and enqueue nothing.
+func (e *enqueuer) enqueueAtomic(items []json.RawMessage, now time.Time, trace traceHeaders) (int, []batchItemResult) {
+	results := make([]batchItemResult, len(items))
+	tasks := make([]q.Task, len(items))
+	reqs := make([]enqueueRequest, len(items))
+	decoded := make([]*errorResponse, len(items))
+	// the enqueue spans of the built tasks end with the outcome of the whole batch
+	spans := make([]*tracing.Span, len(items))
+	var failure error
+	defer func() {
+		for _, span := range spans {
+			span.End(time.Now(), failure)
+		}
+	}()
+	locked := false
+	for i, raw := range items {
+		reqs[i], decoded[i] = decodeItem(raw)
+		reqs[i] = e.withDefaultType(reqs[i])
+		reqs[i].trace = trace
+		if decoded[i] == nil && !locked && e.dedupes(reqs[i]) {
+			e.dedupeMu.Lock()
+			defer e.dedupeMu.Unlock()
+			locked = true
+		}
+	}
+	// ids, idempotency keys and unique keys taken by earlier items of the batch
+	seen := make(map[string]bool, len(items))
+	valid := true
+	for i, req := range reqs {
+		errResp := decoded[i]
+		if errResp == nil {
+			if task, ok := e.replay(req, now); ok {
+				results[i] = acceptedItem(i, task, true)
+				continue
+			}
+			tasks[i], errResp = e.build(req, now)
+			if errResp == nil {
+				spans[i] = e.startTrace(req, &tasks[i], now)
+			}
+		}
+		if errResp == nil {
+			errResp = batchCollision(seen, tasks[i])
+		}
+		if errResp != nil {
+			results[i] = rejectedItem(i, req.ID, *errResp)
+			valid = false
+		}
+	}
+	abort := func(errResp errorResponse) {
+		failure = errors.New(errResp.Message)
+		for i, task := range tasks {
+			if results[i].Status == "" {
+				results[i] = batchItemResult{Index: i, ID: task.ID, Status: itemAborted, Error: errResp.Error, Message: errResp.Message}
+			}
+		}
+	}
+	if !valid {
+		abort(errorResponse{Error: "batch_rejected", Message: "another item of the atomic batch is invalid"})
+		return http.StatusBadRequest, results
+	}
+
+	// save first so a fast worker never updates a task the store does not know yet
+	var immediate []q.Task
+	for i, task := range tasks {
+		if results[i].Status == "" && task.Status != q.StatusScheduled {
+			e.store.Save(task)
+			immediate = append(immediate, task)
+		}
+	}
+	if len(immediate) > 0 && !e.queue.(q.BatchPusher).TryPushAll(immediate) {
+		for _, task := range immediate {
+			e.store.Delete(task.ID)
+		}
+		abort(errorResponse{Error: "queue_full", Message: "the queues cannot take the whole batch"})
+		return http.StatusServiceUnavailable, results
+	}
+	for i, task := range tasks {
+		if results[i].Replayed {
+			continue
+		}
+		if task.Status == q.StatusScheduled {
+			e.schedule(task)
+		} else {
+			e.logEnqueued(task)
+		}
+		results[i] = acceptedItem(i, task, false)
+	}
+	return http.StatusOK, results
+}
+
+// batchCollision reports a task that repeats the id, idempotency key or unique key of an
+// earlier task of the same atomic batch, and otherwise marks its keys as taken.
+func batchCollision(seen map[string]bool, task q.Task) *errorResponse {
+	type key struct{ name, code string }
+	keys := []key{{"id:" + task.ID, "duplicate_id"}}
+	if task.IdempotencyKey != "" {
+		keys = append(keys, key{"idempotency:" + task.IdempotencyKey, "duplicate_id"})
+	}
+	if task.UniqueKey != "" {
+		keys = append(keys, key{"unique:" + task.UniqueKey, "duplicate_task"})
+	}
+	for _, k := range keys {
+		if seen[k.name] {
+			return &errorResponse{Error: k.code, Message: "repeats an earlier task of the batch"}
+		}
+	}
+	for _, k := range keys {
+		seen[k.name] = true
+	}
+	return nil
+}
+
+// streamBatch enqueues NDJSON items as they arrive and writes each outcome as soon as it is
+// known, so a producer can stream an unbounded number of tasks over one request.
+func (e *enqueuer) streamBatch(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// HTTP/1.1 would otherwise stop reading the body once the response starts
+	_ = rc.EnableFullDuplex()
+	w.Header().Set("Content-Type", ndjsonMediaType)
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	trace := traceHeadersOf(r)
+	sc := bufio.NewScanner(r.Body)
+	sc.Buffer(nil, maxNDJSONLine)
+	i := 0
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		_ = enc.Encode(e.enqueueItem(i, line, time.Now(), trace))
+		_ = rc.Flush()
+		i++
+	}
+	if err := sc.Err(); err != nil {
+		_ = enc.Encode(rejectedItem(i, "", *ndjsonError(err)))
+	}
+}
+
+// errBodyTooLarge is reported for a buffered batch body over maxBatchBody.
+func errBodyTooLarge() *errorResponse {
+	return &errorResponse{Error: "body_too_large", Message: fmt.Sprintf("batch body is limited to %d bytes", maxBatchBody)}
+}
+
+func ndjsonError(err error) *errorResponse {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return errBodyTooLarge()
+	}
+	if errors.Is(err, bufio.ErrTooLong) {
+		return &errorResponse{Error: "line_too_long", Message: fmt.Sprintf("NDJSON lines are limited to %d bytes", maxNDJSONLine)}
+	}
+	return &errorResponse{Error: "invalid_body", Message: err.Error()}
+}
+
+func readJSONBatch(r *http.Request) ([]json.RawMessage, *errorResponse) {
+	var items []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, errBodyTooLarge()
+		}
+		return nil, &errorResponse{Error: "invalid_json", Message: "body must be a JSON array of tasks"}
+	}
+	return items, checkBatchSize(len(items))
+}
+
+func readNDJSONBatch(r *http.Request) ([]json.RawMessage, *errorResponse) {
+	var items []json.RawMessage
+	sc := bufio.NewScanner(r.Body)
+	sc.Buffer(nil, maxNDJSONLine)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if len(items) == maxBatchItems {
+			return nil, checkBatchSize(len(items) + 1)
+		}
+		items = append(items, json.RawMessage(bytes.Clone(line)))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, ndjsonError(err)
+	}
+	return items, checkBatchSize(len(items))
+}
+
+func checkBatchSize(n int) *errorResponse {
+	switch {
+	case n == 0:
+		return &errorResponse{Error: "empty_batch", Message: "batch has no tasks"}
+	case n > maxBatchItems:
+		return &errorResponse{Error: "batch_too_large", Message: fmt.Sprintf("batch is limited to %d tasks", maxBatchItems)}
+	}
+	return nil
+}
+
+// writeBatch writes resp as JSON, or as one NDJSON line per item for NDJSON requests.
+func writeBatch(w http.ResponseWriter, status int, ndjson bool, resp batchResponse) {
+	if !ndjson {
+		writeJSON(w, status, resp)
+		return
+	}
+	w.Header().Set("Content-Type", ndjsonMediaType)
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	for _, it := range resp.Items {
+		_ = enc.Encode(it)
+	}
+}
@@ -0,0 +1,140 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/optongroup/kaspersky-safeboard-go-container-security/internal/cron"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+// registerCronRoutes mounts CRUD endpoints for recurring jobs:
+//
+//	GET    /cron       list jobs
+//	POST   /cron       create a job
+//	GET    /cron/{id}  get a job
+//	PUT    /cron/{id}  replace a job definition
+//	DELETE /cron/{id}  delete a job
+func registerCronRoutes(mux *http.ServeMux, mgr *cron.Manager, registry *q.Registry, queues *q.QueueSet, defaultType string) {
+	type jobRequest struct {
+		ID           string            `json:"id"`
+		Schedule     string            `json:"schedule"`
+		Type         string            `json:"type"`
+		Queue        string            `json:"queue"`
+		Payload      json.RawMessage   `json:"payload"`
+		MaxRetries   *int              `json:"max_retries"`
+		MissedPolicy cron.MissedPolicy `json:"missed_policy"`
+		AllowOverlap bool              `json:"allow_overlap"`
+	}
+
+	// decodeJob reads and validates a job body; it writes the error response itself.
+	decodeJob := func(w http.ResponseWriter, r *http.Request) (cron.Job, bool) {
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+		defer r.Body.Close()
+		var req jobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, errorResponse{Error: "invalid_json", Message: "invalid JSON"})
+			return cron.Job{}, false
+		}
+		if strings.TrimSpace(req.Schedule) == "" {
+			writeError(w, http.StatusBadRequest, errorResponse{Error: "missing_field", Message: "schedule required"})
+			return cron.Job{}, false
+		}
+		if req.Type == "" {
+			req.Type = defaultType
+		}
+		if _, ok := resolveType(w, registry, req.Type); !ok {
+			return cron.Job{}, false
+		}
+		queueName, ok := resolveQueue(w, queues, req.Queue)
+		if !ok {
+			return cron.Job{}, false
+		}
+		// the type's policy is applied by the manager at each fire
+		return cron.Job{
+			ID:           req.ID,
+			Schedule:     req.Schedule,
+			Type:         req.Type,
+			Queue:        queueName,
+			Payload:      req.Payload,
+			MaxRetries:   req.MaxRetries,
+			MissedPolicy: req.MissedPolicy,
+			AllowOverlap: req.AllowOverlap,
+		}, true
+	}
+
+	writeJobError := func(w http.ResponseWriter, err error) {
+		switch {
+		case errors.Is(err, cron.ErrNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, cron.ErrDuplicate):
+			writeError(w, http.StatusBadRequest, errorResponse{Error: "duplicate_id", Message: "duplicate id"})
+		case errors.Is(err, cron.ErrUnknownType):
+			writeError(w, http.StatusBadRequest, errorResponse{Error: "unknown_type", Message: err.Error()})
+		case errors.Is(err, cron.ErrUnknownQueue):
+			writeError(w, http.StatusBadRequest, errorResponse{Error: "unknown_queue", Message: err.Error()})
+		case errors.Is(err, cron.ErrInvalidSpec):
+			writeError(w, http.StatusBadRequest, errorResponse{Error: "invalid_schedule", Message: err.Error()})
+		default:
+			writeError(w, http.StatusBadRequest, errorResponse{Error: "invalid_job", Message: err.Error()})
+		}
+	}
+
+	mux.HandleFunc("/cron", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, mgr.List())
+		case http.MethodPost:
+			job, ok := decodeJob(w, r)
+			if !ok {
+				return
+			}
+			created, err := mgr.Add(job)
+			if err != nil {
+				writeJobError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, created)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/cron/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/cron/")
+		if id == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			job, ok := mgr.Get(id)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, job)
+		case http.MethodPut:
+			job, ok := decodeJob(w, r)
+			if !ok {
+				return
+			}
+			updated, err := mgr.Update(id, job)
+			if err != nil {
+				writeJobError(w, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, updated)
+		case http.MethodDelete:
+			if !mgr.Remove(id) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
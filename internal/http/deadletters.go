@@ -0,0 +1,112 @@
+package httpserver
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+// registerDeadLetterRoutes mounts inspection and recovery endpoints for dead letters:
+//
+//	GET    /deadletters               list dead letters, oldest first
+//	DELETE /deadletters               purge all dead letters
+//	POST   /deadletters/redrive       redrive all dead letters while the queue has room
+//	GET    /deadletters/{id}          get a dead letter
+//	DELETE /deadletters/{id}          purge a dead letter
+//	POST   /deadletters/{id}/redrive  put the task back into its queue with reset attempts
+func registerDeadLetterRoutes(mux *http.ServeMux, dlq *q.DeadLetterQueue, store q.Store, queue q.Pusher, accepting *atomic.Bool, logger *slog.Logger) {
+	type redriveResponse struct {
+		ID     string       `json:"id"`
+		Status q.TaskStatus `json:"status"`
+	}
+	type redriveAllResponse struct {
+		Redriven  int `json:"redriven"`
+		Remaining int `json:"remaining"`
+	}
+	type purgeResponse struct {
+		Purged int `json:"purged"`
+	}
+
+	mux.HandleFunc("/deadletters", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, dlq.List())
+		case http.MethodDelete:
+			n := dlq.PurgeAll()
+			logger.Info("dead letters purged", "count", n)
+			writeJSON(w, http.StatusOK, purgeResponse{Purged: n})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/deadletters/", func(w http.ResponseWriter, r *http.Request) {
+		rest := strings.TrimPrefix(r.URL.Path, "/deadletters/")
+		if rest == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if rest == "redrive" {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			if !accepting.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			n, err := dlq.RedriveAll(store, queue)
+			logger.Info("dead letters redriven", "count", n)
+			if err != nil && n == 0 {
+				writeError(w, http.StatusServiceUnavailable, errorResponse{Error: "queue_full", Message: err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, redriveAllResponse{Redriven: n, Remaining: dlq.Len()})
+			return
+		}
+		if id, ok := strings.CutSuffix(rest, "/redrive"); ok {
+			if r.Method != http.MethodPost {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			if !accepting.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			t, err := dlq.Redrive(store, queue, id)
+			switch {
+			case errors.Is(err, q.ErrDeadLetterNotFound):
+				w.WriteHeader(http.StatusNotFound)
+			case errors.Is(err, q.ErrQueueFull):
+				writeError(w, http.StatusServiceUnavailable, errorResponse{Error: "queue_full", Message: err.Error()})
+			case err != nil:
+				writeError(w, http.StatusInternalServerError, errorResponse{Error: "redrive_failed", Message: err.Error()})
+			default:
+				logger.Info("dead letter redriven", "task_id", t.ID, "task_type", t.Type, "queue", t.Queue)
+				writeJSON(w, http.StatusAccepted, redriveResponse{ID: t.ID, Status: t.Status})
+			}
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			dl, ok := dlq.Get(rest)
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeJSON(w, http.StatusOK, dl)
+		case http.MethodDelete:
+			if !dlq.Purge(rest) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
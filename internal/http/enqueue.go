@@ -0,0 +1,296 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+	"github.com/optongroup/kaspersky-safeboard-go-container-security/internal/tracing"
+)
+
+// enqueueRequest is the body of POST /enqueue and one item of POST /enqueue/batch.
+type enqueueRequest struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Queue string `json:"queue"`
+	// Payload is any JSON value; see decodePayload for strings and binary data.
+	Payload json.RawMessage `json:"payload"`
+	// PayloadEncoding "string" keeps a string payload literal; "base64" carries binary data,
+	// described by ContentType.
+	PayloadEncoding string `json:"payload_encoding"`
+	ContentType     string `json:"content_type"`
+	MaxRetries      *int   `json:"max_retries"`
+	// Priority orders the task in the queue: higher runs first (default 0).
+	Priority int `json:"priority"`
+	// RunAt (RFC 3339) or Delay (Go duration, e.g. "10m") postpones the first attempt.
+	RunAt *time.Time `json:"run_at"`
+	Delay string     `json:"delay"`
+	// Timeout (Go duration, e.g. "30s") bounds each attempt; defaults to the type's timeout.
+	Timeout string `json:"timeout"`
+	// Retry overrides the retry policy of the task's type and queue.
+	Retry *retryRequest `json:"retry"`
+	// Labels are key/value pairs the task can be listed by.
+	Labels map[string]string `json:"labels"`
+	// IdempotencyKey makes retries of the request safe: within the idempotency window a
+	// repeated key returns the task created first. The Idempotency-Key header takes precedence.
+	IdempotencyKey string `json:"idempotency_key"`
+	// Unique rejects the task while another task with the same type and payload is unfinished.
+	Unique bool `json:"unique"`
+
+	// trace holds the trace context headers of the HTTP request.
+	trace traceHeaders
+}
+
+// traceHeaders are the W3C trace context headers of an enqueue request.
+type traceHeaders struct {
+	parent, state string
+}
+
+func traceHeadersOf(r *http.Request) traceHeaders {
+	return traceHeaders{parent: r.Header.Get("traceparent"), state: strings.Join(r.Header.Values("tracestate"), ",")}
+}
+
+type enqueueResponse struct {
+	ID     string       `json:"id"`
+	Status q.TaskStatus `json:"status"`
+	RunAt  *time.Time   `json:"run_at,omitempty"`
+	// Replayed is set when an idempotency key matched an existing task.
+	Replayed bool `json:"replayed,omitempty"`
+}
+
+// maxIdempotencyKey bounds the length of an idempotency key.
+const maxIdempotencyKey = 255
+
+// enqueuer validates enqueue requests and hands their tasks to the queue or the scheduler.
+type enqueuer struct {
+	store      q.Store
+	queue      q.Pusher
+	queues     *q.QueueSet
+	registry   *q.Registry
+	scheduler  *q.Scheduler
+	maxTimeout time.Duration
+	// defaultType is the type of requests that name none.
+	defaultType string
+	// idempotencyWindow is how long idempotency keys are honoured.
+	idempotencyWindow time.Duration
+	log               *slog.Logger
+	tracer            *tracing.Tracer
+
+	// dedupeMu serializes the requests that use an idempotency key or a unique key, so two
+	// of them cannot both miss the lookup and create a task each.
+	dedupeMu sync.Mutex
+}
+
+// build validates req, whose type is already defaulted, and returns its task: scheduled when
+// it has a future run_at, queued otherwise. Nothing is stored yet.
+func (e *enqueuer) build(req enqueueRequest, now time.Time) (q.Task, *errorResponse) {
+	if strings.TrimSpace(req.ID) == "" {
+		return q.Task{}, &errorResponse{Error: "missing_field", Message: "id and payload required"}
+	}
+	payload, binary, errResp := decodePayload(req.Payload, req.PayloadEncoding, req.ContentType)
+	if errResp != nil {
+		return q.Task{}, errResp
+	}
+	policy, errResp := checkType(e.registry, req.Type)
+	if errResp != nil {
+		return q.Task{}, errResp
+	}
+	runAt, errResp := resolveRunAt(req.RunAt, req.Delay, now)
+	if errResp != nil {
+		return q.Task{}, errResp
+	}
+	if runAt != nil && e.scheduler == nil {
+		return q.Task{}, &errorResponse{Error: "scheduling_disabled", Message: "run_at and delay are not supported"}
+	}
+	queueName, errResp := checkQueue(e.queues, req.Queue)
+	if errResp != nil {
+		return q.Task{}, errResp
+	}
+	if len(req.IdempotencyKey) > maxIdempotencyKey {
+		return q.Task{}, &errorResponse{
+			Error:   "invalid_idempotency_key",
+			Message: fmt.Sprintf("idempotency key is limited to %d bytes", maxIdempotencyKey),
+		}
+	}
+	if req.Priority < q.MinPriority || req.Priority > q.MaxPriority {
+		return q.Task{}, &errorResponse{
+			Error:   "invalid_priority",
+			Message: fmt.Sprintf("priority must be within [%d, %d]", q.MinPriority, q.MaxPriority),
+		}
+	}
+	timeout, errResp := resolveTimeout(req.Timeout, policy.Timeout, e.maxTimeout)
+	if errResp != nil {
+		return q.Task{}, errResp
+	}
+	retry, errResp := resolveRetry(req.Retry)
+	if errResp != nil {
+		return q.Task{}, errResp
+	}
+	if errResp := validateLabels(req.Labels); errResp != nil {
+		return q.Task{}, errResp
+	}
+	maxRetries := policy.MaxRetries
+	if req.MaxRetries != nil {
+		maxRetries = *req.MaxRetries
+	}
+	if _, exists := e.store.Get(req.ID); exists {
+		return q.Task{}, &errorResponse{Error: "duplicate_id", Message: "duplicate id"}
+	}
+	task := q.NewTaskWithID(req.ID, payload, maxRetries)
+	if binary {
+		task.SetBinaryPayload(payload, req.ContentType)
+	}
+	task.Type = req.Type
+	task.Timeout = timeout
+	task.Retry = retry
+	if len(req.Labels) > 0 {
+		task.Labels = req.Labels
+	}
+	task.Priority = req.Priority
+	task.Queue = queueName
+	task.IdempotencyKey = req.IdempotencyKey
+	if req.Unique || policy.Unique {
+		task.UniqueKey = q.UniqueKey(task.Type, task.Payload)
+		if existing, ok := e.store.FindActiveUnique(task.UniqueKey); ok {
+			return q.Task{}, &errorResponse{
+				Error:      "duplicate_task",
+				Message:    fmt.Sprintf("task %q with the same type and payload is %s", existing.ID, existing.Status),
+				ExistingID: existing.ID,
+			}
+		}
+	}
+	if runAt != nil {
+		// future task: held by the scheduler, does not take queue capacity until due
+		task.Status = q.StatusScheduled
+		task.RunAt = runAt
+	}
+	return task, nil
+}
+
+// submit stores a built task and queues or schedules it. It reports false when the task's
+// queue is full; the task is then not stored.
+func (e *enqueuer) submit(task q.Task) bool {
+	if task.Status == q.StatusScheduled {
+		e.schedule(task)
+		return true
+	}
+	// save first so a fast worker never updates a task the store does not know yet
+	e.store.Save(task)
+	if !e.queue.TryPush(task) {
+		e.store.Delete(task.ID)
+		return false
+	}
+	e.logEnqueued(task)
+	return true
+}
+
+func (e *enqueuer) logEnqueued(task q.Task) {
+	e.log.Info("task enqueued", "task_id", task.ID, "task_type", task.Type, "queue", task.Queue, "priority", task.Priority)
+}
+
+func (e *enqueuer) schedule(task q.Task) {
+	task = e.store.Save(task)
+	e.scheduler.Schedule(task)
+	e.log.Info("task scheduled", "task_id", task.ID, "task_type", task.Type, "queue", task.Queue, "run_at", task.RunAt)
+}
+
+// withDefaultType gives req the default type when it names none. It runs before dedupes,
+// which looks up the policy of the type.
+func (e *enqueuer) withDefaultType(req enqueueRequest) enqueueRequest {
+	if req.Type == "" {
+		req.Type = e.defaultType
+	}
+	return req
+}
+
+// dedupes reports whether req takes part in deduplication.
+func (e *enqueuer) dedupes(req enqueueRequest) bool {
+	return req.IdempotencyKey != "" || req.Unique || (e.registry != nil && e.registry.Policy(req.Type).Unique)
+}
+
+// replay returns the task created within the idempotency window with the key of req.
+func (e *enqueuer) replay(req enqueueRequest, now time.Time) (q.Task, bool) {
+	if req.IdempotencyKey == "" {
+		return q.Task{}, false
+	}
+	return e.store.FindByIdempotencyKey(req.IdempotencyKey, now.Add(-e.idempotencyWindow))
+}
+
+// startTrace starts the enqueue span of task as a child of the request's trace context and
+// records on the task the context its spans descend from: the enqueue span, or without a
+// tracer the request's own context. Malformed headers are ignored.
+func (e *enqueuer) startTrace(req enqueueRequest, task *q.Task, start time.Time) *tracing.Span {
+	parent, _ := tracing.Parse(req.trace.parent, req.trace.state)
+	span := e.tracer.Start(parent, "enqueue", start)
+	if span != nil {
+		span.SetAttributes("task.id", task.ID, "task.type", task.Type, "task.queue", task.Queue)
+		parent = span.Context()
+	}
+	task.SetSpanContext(parent)
+	return span
+}
+
+// errQueueFull is reported for a valid task whose queue has no room.
+func errQueueFull(task q.Task) *errorResponse {
+	return &errorResponse{Error: "queue_full", Message: fmt.Sprintf("queue %q is full", task.Queue)}
+}
+
+// enqueue builds and submits req. A repeated idempotency key returns the original task with
+// replayed set instead of creating one.
+func (e *enqueuer) enqueue(req enqueueRequest, now time.Time) (task q.Task, replayed bool, errResp *errorResponse) {
+	req = e.withDefaultType(req)
+	if e.dedupes(req) {
+		e.dedupeMu.Lock()
+		defer e.dedupeMu.Unlock()
+		if task, ok := e.replay(req, now); ok {
+			return task, true, nil
+		}
+	}
+	task, errResp = e.build(req, now)
+	if errResp != nil {
+		return q.Task{}, false, errResp
+	}
+	span := e.startTrace(req, &task, now)
+	if !e.submit(task) {
+		errResp = errQueueFull(task)
+		span.End(time.Now(), errors.New(errResp.Message))
+		return task, false, errResp
+	}
+	span.End(time.Now(), nil)
+	return task, false, nil
+}
+
+// handleEnqueue serves POST /enqueue.
+func (e *enqueuer) handleEnqueue(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+	defer r.Body.Close()
+	var req enqueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errorResponse{Error: "invalid_json", Message: "invalid JSON"})
+		return
+	}
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		req.IdempotencyKey = key
+	}
+	req.trace = traceHeadersOf(r)
+	task, replayed, errResp := e.enqueue(req, time.Now())
+	switch {
+	case errResp == nil && replayed:
+		w.Header().Set("Idempotent-Replayed", "true")
+		writeJSON(w, http.StatusOK, enqueueResponse{ID: task.ID, Status: task.Status, RunAt: task.RunAt, Replayed: true})
+	case errResp == nil:
+		writeJSON(w, http.StatusAccepted, enqueueResponse{ID: task.ID, Status: task.Status, RunAt: task.RunAt})
+	case errResp.Error == "queue_full":
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case errResp.Error == "duplicate_task":
+		writeError(w, http.StatusConflict, *errResp)
+	default:
+		writeError(w, http.StatusBadRequest, *errResp)
+	}
+}
@@ -0,0 +1,28 @@
+package httpserver
+
+import q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+
+// latencyResponse is the latency section of the JSON metrics: percentiles per queue and per
+// task type over all queues. Untyped tasks are reported under the empty type.
+type latencyResponse struct {
+	Queues map[string]q.LatencyReport
+	Types  map[string]q.LatencyReport
+}
+
+func newLatencyResponse(queues map[string]q.QueueMetrics) *latencyResponse {
+	byType := make(map[string]q.Latency)
+	resp := &latencyResponse{Queues: make(map[string]q.LatencyReport, len(queues))}
+	for name, qm := range queues {
+		resp.Queues[name] = qm.Latency.Report()
+		for typ, l := range qm.Types {
+			merged := byType[typ]
+			merged.Merge(l)
+			byType[typ] = merged
+		}
+	}
+	resp.Types = make(map[string]q.LatencyReport, len(byType))
+	for typ, l := range byType {
+		resp.Types[typ] = l.Report()
+	}
+	return resp
+}
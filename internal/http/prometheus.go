@@ -0,0 +1,188 @@
+package httpserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+// prometheusContentType is the content type of the Prometheus text exposition format 0.0.4.
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// labelEscaper escapes a label value: the format knows only \\, \" and \n.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// wantsPrometheus picks the format of GET /metrics: the format query parameter ("prometheus"
+// or "json") wins, else the Accept header. JSON stays the default, so only clients that
+// prefer text/plain or OpenMetrics over JSON, as Prometheus does, get the text format.
+func wantsPrometheus(r *http.Request) bool {
+	switch r.URL.Query().Get("format") {
+	case "prometheus":
+		return true
+	case "json":
+		return false
+	}
+	var textQ, jsonQ float64
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		weight := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		switch mediaType {
+		case "text/plain", "application/openmetrics-text":
+			textQ = max(textQ, weight)
+		case "application/json":
+			jsonQ = max(jsonQ, weight)
+		}
+	}
+	return textQ > jsonQ
+}
+
+// promWriter writes metric families in the Prometheus text format.
+type promWriter struct {
+	w *bufio.Writer
+}
+
+// family starts a metric family with its help text and type.
+func (p promWriter) family(name, typ, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes one sample; labels are name/value pairs.
+func (p promWriter) sample(name string, value float64, labels ...string) {
+	p.w.WriteString(name)
+	if len(labels) > 0 {
+		p.w.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				p.w.WriteByte(',')
+			}
+			fmt.Fprintf(p.w, `%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1]))
+		}
+		p.w.WriteByte('}')
+	}
+	p.w.WriteByte(' ')
+	p.w.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	p.w.WriteByte('\n')
+}
+
+// histogram writes the cumulative buckets, sum and count of h in seconds.
+func (p promWriter) histogram(name string, h q.HistogramSnapshot, labels ...string) {
+	var cumulative uint64
+	for i, n := range h.Counts {
+		cumulative += n
+		le := "+Inf"
+		if i < len(q.DurationBuckets) {
+			le = strconv.FormatFloat(q.DurationBuckets[i].Seconds(), 'g', -1, 64)
+		}
+		p.sample(name+"_bucket", float64(cumulative), append(labels[:len(labels):len(labels)], "le", le)...)
+	}
+	p.sample(name+"_sum", h.Sum.Seconds(), labels...)
+	p.sample(name+"_count", float64(h.Count), labels...)
+}
+
+// writePrometheus renders the store's per-status gauges and, with a queue set, the per-queue
+// counters and gauges and the latency histograms per queue and task type.
+func writePrometheus(out io.Writer, m q.Metrics, queues map[string]q.QueueMetrics) error {
+	p := promWriter{w: bufio.NewWriter(out)}
+
+	p.family("taskqueue_tasks", "gauge", "Tasks in the store by status.")
+	for _, s := range []struct {
+		status q.TaskStatus
+		n      uint64
+	}{
+		{q.StatusScheduled, m.Scheduled}, {q.StatusQueued, m.Queued}, {q.StatusRunning, m.Running},
+		{q.StatusRetrying, m.Retrying}, {q.StatusDone, m.Done}, {q.StatusFailed, m.Failed},
+		{q.StatusCanceled, m.Canceled}, {q.StatusSkipped, m.Skipped},
+	} {
+		p.sample("taskqueue_tasks", float64(s.n), "status", string(s.status))
+	}
+
+	names := sortedKeys(queues)
+	perQueue := func(name, typ, help string, value func(q.QueueMetrics) float64) {
+		if len(names) == 0 {
+			return
+		}
+		p.family(name, typ, help)
+		for _, qn := range names {
+			p.sample(name, value(queues[qn]), "queue", qn)
+		}
+	}
+	perQueue("taskqueue_enqueued_total", "counter", "Tasks accepted into the queue; retries are not counted.",
+		func(m q.QueueMetrics) float64 { return float64(m.Enqueued) })
+	perQueue("taskqueue_completed_total", "counter", "Tasks finished successfully.",
+		func(m q.QueueMetrics) float64 { return float64(m.Done) })
+	perQueue("taskqueue_failed_total", "counter", "Tasks failed for good.",
+		func(m q.QueueMetrics) float64 { return float64(m.Failed) })
+	perQueue("taskqueue_attempts_total", "counter", "Attempts run, retries included.",
+		func(m q.QueueMetrics) float64 { return float64(m.Attempts) })
+	perQueue("taskqueue_retried_total", "counter", "Failed attempts followed by a retry.",
+		func(m q.QueueMetrics) float64 { return float64(m.Retried) })
+	perQueue("taskqueue_retries_exhausted_total", "counter", "Tasks failed because no retries were left.",
+		func(m q.QueueMetrics) float64 { return float64(m.Exhausted) })
+	perQueue("taskqueue_canceled_total", "counter", "Tasks canceled.",
+		func(m q.QueueMetrics) float64 { return float64(m.Canceled) })
+	perQueue("taskqueue_skipped_total", "counter", "Tasks skipped by their handler.",
+		func(m q.QueueMetrics) float64 { return float64(m.Skipped) })
+	perQueue("taskqueue_attempt_timeouts_total", "counter", "Attempts that exceeded their deadline.",
+		func(m q.QueueMetrics) float64 { return float64(m.Timeouts) })
+	perQueue("taskqueue_attempt_panics_total", "counter", "Attempts whose handler panicked.",
+		func(m q.QueueMetrics) float64 { return float64(m.Panics) })
+	perQueue("taskqueue_queue_depth", "gauge", "Tasks waiting in the queue.",
+		func(m q.QueueMetrics) float64 { return float64(m.Depth) })
+	perQueue("taskqueue_queue_capacity", "gauge", "Maximum number of tasks waiting in the queue.",
+		func(m q.QueueMetrics) float64 { return float64(m.Capacity) })
+	perQueue("taskqueue_running", "gauge", "Attempts in progress.",
+		func(m q.QueueMetrics) float64 { return float64(m.Running) })
+	perQueue("taskqueue_abandoned_handlers", "gauge", "Handlers still running after their attempt timed out.",
+		func(m q.QueueMetrics) float64 { return float64(m.Abandoned) })
+	perQueue("taskqueue_workers", "gauge", "Workers of the queue.",
+		func(m q.QueueMetrics) float64 { return float64(m.Workers) })
+
+	for _, h := range []struct {
+		name, help string
+		pick       func(q.Latency) q.HistogramSnapshot
+	}{
+		{"taskqueue_queue_wait_seconds", "Time from enqueue to the start of an attempt.",
+			func(l q.Latency) q.HistogramSnapshot { return l.Wait }},
+		{"taskqueue_attempt_duration_seconds", "Execution time of an attempt.",
+			func(l q.Latency) q.HistogramSnapshot { return l.Run }},
+		{"taskqueue_task_latency_seconds", "Time from a task being due to its final outcome.",
+			func(l q.Latency) q.HistogramSnapshot { return l.Total }},
+	} {
+		if len(names) == 0 {
+			break
+		}
+		p.family(h.name, "histogram", h.help)
+		for _, qn := range names {
+			types := queues[qn].Types
+			for _, typ := range sortedKeys(types) {
+				p.histogram(h.name, h.pick(types[typ]), "queue", qn, "type", typ)
+			}
+		}
+	}
+	return p.w.Flush()
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -2,14 +2,19 @@ package httpserver
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/optongroup/kaspersky-safeboard-go-container-security/internal/cron"
 	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+	"github.com/optongroup/kaspersky-safeboard-go-container-security/internal/tracing"
 )
 
 // Server wraps the HTTP server and provides start/stop helpers.
@@ -21,14 +26,152 @@ type Server struct {
 func NewHandler() http.Handler {
 	// default dependencies for backward compatibility
 	store := q.NewStore()
-	ch := make(chan q.Task, 1)
 	var accepting atomic.Bool
 	accepting.Store(true)
-	return NewHandlerWithDeps(store, ch, &accepting)
+	return NewHandlerWithOptions(Options{Store: store, Queue: q.NewPriorityQueue(1, 0), Accepting: &accepting})
+}
+
+// Options holds the dependencies of the HTTP handler.
+type Options struct {
+	Store     q.Store
+	Queue     q.Pusher
+	Accepting *atomic.Bool
+	// Queues, when set, replaces Queue: tasks are routed by their queue field and
+	// /metrics reports per-queue counters.
+	Queues *q.QueueSet
+	// Registry, when set, restricts enqueue to registered task types and supplies their
+	// default retry/timeout policy. Without it any type is accepted as-is.
+	Registry *q.Registry
+	// DefaultType is given to enqueue requests and cron jobs without a type, so that clients
+	// predating task types keep working. Empty leaves them untyped.
+	DefaultType string
+	// Scheduler, when set, accepts tasks with run_at/delay; without it such requests are rejected.
+	Scheduler *q.Scheduler
+	// Cron, when set, exposes recurring job management under /cron.
+	Cron *cron.Manager
+	// DeadLetters, when set, exposes failed tasks for inspection, redrive and purge under /deadletters.
+	DeadLetters *q.DeadLetterQueue
+	// Canceler, when set, enables POST /tasks/{id}/cancel.
+	Canceler *q.Canceler
+	// MaxTimeout, when positive, rejects enqueue requests asking for a longer attempt timeout.
+	MaxTimeout time.Duration
+	// IdempotencyWindow is how long idempotency keys are honoured; zero means
+	// queue.DefaultIdempotencyWindow.
+	IdempotencyWindow time.Duration
+	// Logger receives enqueue, cancel and redrive events; nil uses slog.Default().
+	Logger *slog.Logger
+	// Tracer, when set, records an enqueue span for every accepted task. Without it the
+	// traceparent header is only stored on the task for the worker to continue.
+	Tracer *tracing.Tracer
 }
 
 // NewHandlerWithDeps builds handler with injected store, queue channel and accepting flag
-func NewHandlerWithDeps(store *q.Store, ch chan<- q.Task, accepting *atomic.Bool) http.Handler {
+func NewHandlerWithDeps(store q.Store, ch chan<- q.Task, accepting *atomic.Bool) http.Handler {
+	return NewHandlerWithOptions(Options{Store: store, Queue: sendChan(ch), Accepting: accepting})
+}
+
+// sendChan adapts a send-only channel to q.Pusher.
+type sendChan chan<- q.Task
+
+func (c sendChan) TryPush(t q.Task) bool {
+	select {
+	case c <- t:
+		return true
+	default:
+		return false
+	}
+}
+
+// errorResponse is the JSON body returned for rejected requests.
+type errorResponse struct {
+	Error       string   `json:"error"`
+	Message     string   `json:"message"`
+	KnownTypes  []string `json:"known_types,omitempty"`
+	KnownQueues []string `json:"known_queues,omitempty"`
+	// ExistingID names the unfinished task a duplicate_task error collides with.
+	ExistingID string `json:"existing_id,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, resp errorResponse) {
+	writeJSON(w, status, resp)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// checkType checks taskType against the registry and returns its default policy.
+// Without a registry every type is accepted with a zero policy.
+func checkType(registry *q.Registry, taskType string) (q.TypePolicy, *errorResponse) {
+	if registry == nil {
+		return q.TypePolicy{}, nil
+	}
+	if _, ok := registry.Lookup(taskType); !ok {
+		return q.TypePolicy{}, &errorResponse{
+			Error:      "unknown_type",
+			Message:    fmt.Sprintf("unknown task type %q", taskType),
+			KnownTypes: registry.Types(),
+		}
+	}
+	return registry.Policy(taskType), nil
+}
+
+// resolveType is checkType that writes a structured 400 and returns false on failure.
+func resolveType(w http.ResponseWriter, registry *q.Registry, taskType string) (q.TypePolicy, bool) {
+	policy, errResp := checkType(registry, taskType)
+	if errResp != nil {
+		writeError(w, http.StatusBadRequest, *errResp)
+		return q.TypePolicy{}, false
+	}
+	return policy, true
+}
+
+// checkQueue maps the requested queue name to a declared queue. Without a queue set only
+// the default queue exists.
+func checkQueue(queues *q.QueueSet, name string) (string, *errorResponse) {
+	if queues == nil {
+		if name == "" || name == q.DefaultQueueName {
+			return name, nil
+		}
+		return "", &errorResponse{
+			Error:       "unknown_queue",
+			Message:     fmt.Sprintf("unknown queue %q", name),
+			KnownQueues: []string{q.DefaultQueueName},
+		}
+	}
+	resolved, ok := queues.Resolve(name)
+	if !ok {
+		return "", &errorResponse{
+			Error:       "unknown_queue",
+			Message:     fmt.Sprintf("unknown queue %q", name),
+			KnownQueues: queues.Names(),
+		}
+	}
+	return resolved, nil
+}
+
+// resolveQueue is checkQueue that writes a structured 400 and returns false on failure.
+func resolveQueue(w http.ResponseWriter, queues *q.QueueSet, name string) (string, bool) {
+	resolved, errResp := checkQueue(queues, name)
+	if errResp != nil {
+		writeError(w, http.StatusBadRequest, *errResp)
+		return "", false
+	}
+	return resolved, true
+}
+
+// NewHandlerWithOptions builds the handler from explicit options.
+func NewHandlerWithOptions(opts Options) http.Handler {
+	store, queue, accepting, registry, scheduler := opts.Store, opts.Queue, opts.Accepting, opts.Registry, opts.Scheduler
+	if opts.Queues != nil {
+		queue = opts.Queues
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
@@ -38,16 +181,22 @@ func NewHandlerWithDeps(store *q.Store, ch chan<- q.Task, accepting *atomic.Bool
 		w.WriteHeader(http.StatusOK)
 	})
 
-	type enqueueRequest struct {
-		ID         string `json:"id"`
-		Payload    string `json:"payload"`
-		MaxRetries int    `json:"max_retries"`
+	enq := &enqueuer{
+		store:       store,
+		queue:       queue,
+		queues:      opts.Queues,
+		registry:    registry,
+		scheduler:   scheduler,
+		maxTimeout:  opts.MaxTimeout,
+		defaultType: opts.DefaultType,
+
+		idempotencyWindow: opts.IdempotencyWindow,
+		log:               logger,
+		tracer:            opts.Tracer,
 	}
-	type enqueueResponse struct {
-		ID     string       `json:"id"`
-		Status q.TaskStatus `json:"status"`
+	if enq.idempotencyWindow <= 0 {
+		enq.idempotencyWindow = q.DefaultIdempotencyWindow
 	}
-
 	mux.HandleFunc("/enqueue", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			w.WriteHeader(http.StatusMethodNotAllowed)
@@ -57,37 +206,20 @@ func NewHandlerWithDeps(store *q.Store, ch chan<- q.Task, accepting *atomic.Bool
 			w.WriteHeader(http.StatusServiceUnavailable)
 			return
 		}
-		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
-		defer r.Body.Close()
-		var req enqueueRequest
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "invalid JSON", http.StatusBadRequest)
-			return
-		}
-		if strings.TrimSpace(req.ID) == "" || strings.TrimSpace(req.Payload) == "" {
-			http.Error(w, "id and payload required", http.StatusBadRequest)
-			return
-		}
-		if req.MaxRetries < 0 {
-			req.MaxRetries = 0
-		}
-		// check duplicate id
-		if _, exists := store.Get(req.ID); exists {
-			http.Error(w, "duplicate id", http.StatusBadRequest)
-			return
-		}
-		task := q.NewTaskWithID(req.ID, []byte(req.Payload), req.MaxRetries)
-		select {
-		case ch <- task:
-			store.Save(task)
-			log.Printf("enqueued task id=%s", task.ID)
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusAccepted)
-			_ = json.NewEncoder(w).Encode(enqueueResponse{ID: task.ID, Status: task.Status})
-		default:
-			w.WriteHeader(http.StatusServiceUnavailable)
-		}
+		enq.handleEnqueue(w, r)
 	})
+	registerBatchRoute(mux, enq, accepting)
+
+	if opts.Cron != nil {
+		registerCronRoutes(mux, opts.Cron, registry, opts.Queues, opts.DefaultType)
+	}
+	registerTaskListRoute(mux, store)
+	if opts.Canceler != nil {
+		registerTaskRoutes(mux, store, opts.Canceler, logger)
+	}
+	if opts.DeadLetters != nil {
+		registerDeadLetterRoutes(mux, opts.DeadLetters, store, queue, accepting, logger)
+	}
 
 	// GET /status/{id}
 	mux.HandleFunc("/status/", func(w http.ResponseWriter, r *http.Request) {
@@ -108,13 +240,72 @@ func NewHandlerWithDeps(store *q.Store, ch chan<- q.Task, accepting *atomic.Bool
 		w.WriteHeader(http.StatusNotFound)
 	})
 
-	// GET /metrics (simple JSON counters)
+	// GET /result/{id}: the raw output of a done task, served with its content type
+	mux.HandleFunc("/result/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		id := strings.TrimPrefix(r.URL.Path, "/result/")
+		t, ok := store.Get(id)
+		if id == "" || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch {
+		case t.Status != q.StatusDone:
+			writeError(w, http.StatusConflict, errorResponse{Error: "not_ready", Message: fmt.Sprintf("task is %s", t.Status)})
+		case t.Result == nil:
+			// done without output
+			w.WriteHeader(http.StatusNoContent)
+		case t.Result.Evicted:
+			writeError(w, http.StatusGone, errorResponse{Error: "result_evicted", Message: "result retention expired"})
+		default:
+			w.Header().Set("Content-Type", t.Result.ContentType)
+			w.Header().Set("Content-Length", strconv.Itoa(len(t.Result.Data)))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(t.Result.Data)
+		}
+	})
+
+	// metricsResponse extends the per-status counters with per-queue ones, the
+	// attempt counters summed over all queues and the latency percentiles.
+	type metricsResponse struct {
+		q.Metrics
+		Attempts  uint64                    `json:",omitempty"`
+		Retried   uint64                    `json:",omitempty"`
+		Exhausted uint64                    `json:",omitempty"`
+		Timeouts  uint64                    `json:",omitempty"`
+		Panics    uint64                    `json:",omitempty"`
+		Abandoned uint64                    `json:",omitempty"`
+		Queues    map[string]q.QueueMetrics `json:",omitempty"`
+		Latency   *latencyResponse          `json:",omitempty"`
+	}
+
+	// GET /metrics: JSON counters, or the Prometheus text format when negotiated
 	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			return
 		}
-		m := store.GetMetrics()
+		m := metricsResponse{Metrics: store.GetMetrics()}
+		if opts.Queues != nil {
+			m.Queues = opts.Queues.Metrics()
+			for _, qm := range m.Queues {
+				m.Attempts += qm.Attempts
+				m.Retried += qm.Retried
+				m.Exhausted += qm.Exhausted
+				m.Timeouts += qm.Timeouts
+				m.Panics += qm.Panics
+				m.Abandoned += qm.Abandoned
+			}
+			m.Latency = newLatencyResponse(m.Queues)
+		}
+		if wantsPrometheus(r) {
+			w.Header().Set("Content-Type", prometheusContentType)
+			_ = writePrometheus(w, m.Metrics, m.Queues)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(m)
 	})
@@ -122,6 +313,146 @@ func NewHandlerWithDeps(store *q.Store, ch chan<- q.Task, accepting *atomic.Bool
 	return mux
 }
 
+// resolveTimeout parses the requested attempt timeout; empty selects def. The timeout must be
+// positive and not above limit when limit is set.
+func resolveTimeout(raw string, def, limit time.Duration) (time.Duration, *errorResponse) {
+	if raw == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, &errorResponse{Error: "invalid_timeout", Message: fmt.Sprintf("invalid timeout %q", raw)}
+	}
+	if limit > 0 && d > limit {
+		return 0, &errorResponse{Error: "invalid_timeout", Message: fmt.Sprintf("timeout %s exceeds the maximum of %s", d, limit)}
+	}
+	return d, nil
+}
+
+// Label limits of an enqueue request.
+const (
+	maxLabels      = 16
+	maxLabelLength = 128
+)
+
+// decodePayload returns the task payload of an enqueue request and whether it is binary.
+// Any JSON value is taken as is. Without an encoding, a string holding JSON text is unwrapped,
+// so clients that used to send stringified JSON keep working; other strings stay strings.
+// With "string" a string is kept as is even when it holds JSON text. With "base64" the payload
+// is a base64 string of binary data.
+func decodePayload(raw json.RawMessage, encoding, contentType string) ([]byte, bool, *errorResponse) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, false, &errorResponse{Error: "missing_field", Message: "id and payload required"}
+	}
+	invalid := func(msg string) ([]byte, bool, *errorResponse) {
+		return nil, false, &errorResponse{Error: "invalid_payload", Message: msg}
+	}
+	if contentType != "" && encoding != q.PayloadBase64 {
+		return invalid("content_type requires payload_encoding \"base64\"")
+	}
+	var s string
+	isString := json.Unmarshal(raw, &s) == nil
+	switch encoding {
+	case "":
+		if !isString {
+			return raw, false, nil
+		}
+		if strings.TrimSpace(s) == "" {
+			return nil, false, &errorResponse{Error: "missing_field", Message: "id and payload required"}
+		}
+		if json.Valid([]byte(s)) {
+			return []byte(s), false, nil
+		}
+		return raw, false, nil
+	case "string":
+		if !isString {
+			return invalid("string payload must be a string")
+		}
+		return raw, false, nil
+	case q.PayloadBase64:
+		if !isString {
+			return invalid("base64 payload must be a string")
+		}
+		data, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return invalid("payload is not valid base64")
+		}
+		return data, true, nil
+	}
+	return invalid(fmt.Sprintf("unknown payload_encoding %q", encoding))
+}
+
+// validateLabels checks the labels of an enqueue request.
+func validateLabels(labels map[string]string) *errorResponse {
+	if len(labels) > maxLabels {
+		return &errorResponse{Error: "invalid_labels", Message: fmt.Sprintf("at most %d labels", maxLabels)}
+	}
+	for k, v := range labels {
+		if k == "" || strings.Contains(k, "=") || len(k) > maxLabelLength || len(v) > maxLabelLength {
+			return &errorResponse{Error: "invalid_labels", Message: fmt.Sprintf("invalid label %q: keys must be non-empty without '=', keys and values at most %d bytes", k, maxLabelLength)}
+		}
+	}
+	return nil
+}
+
+// retryRequest is the retry policy of an enqueue request; durations use Go syntax ("500ms").
+type retryRequest struct {
+	Strategy string `json:"strategy"`
+	Base     string `json:"base"`
+	MaxDelay string `json:"max_delay"`
+	Jitter   string `json:"jitter"`
+}
+
+// resolveRetry parses the requested retry policy; nil keeps the policy of the type or queue.
+func resolveRetry(req *retryRequest) (*q.RetryPolicy, *errorResponse) {
+	if req == nil {
+		return nil, nil
+	}
+	p := q.RetryPolicy{Strategy: q.RetryStrategy(req.Strategy)}
+	for _, f := range []struct {
+		name, raw string
+		dst       *time.Duration
+	}{{"base", req.Base, &p.Base}, {"max_delay", req.MaxDelay, &p.MaxDelay}, {"jitter", req.Jitter, &p.Jitter}} {
+		if f.raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(f.raw)
+		if err != nil {
+			return nil, &errorResponse{Error: "invalid_retry_policy", Message: fmt.Sprintf("invalid %s %q", f.name, f.raw)}
+		}
+		*f.dst = d
+	}
+	if err := p.Validate(); err != nil {
+		return nil, &errorResponse{Error: "invalid_retry_policy", Message: err.Error()}
+	}
+	return &p, nil
+}
+
+// resolveRunAt validates the scheduling fields of an enqueue request. It returns nil when
+// the task should be queued immediately (no fields set, or a due time not in the future).
+func resolveRunAt(runAt *time.Time, delay string, now time.Time) (*time.Time, *errorResponse) {
+	if runAt != nil && delay != "" {
+		return nil, &errorResponse{Error: "invalid_schedule", Message: "run_at and delay are mutually exclusive"}
+	}
+	var at time.Time
+	switch {
+	case runAt != nil:
+		at = runAt.UTC()
+	case delay != "":
+		d, err := time.ParseDuration(delay)
+		if err != nil || d < 0 {
+			return nil, &errorResponse{Error: "invalid_schedule", Message: fmt.Sprintf("invalid delay %q", delay)}
+		}
+		at = now.Add(d).UTC()
+	default:
+		return nil, nil
+	}
+	if !at.After(now) {
+		return nil, nil
+	}
+	return &at, nil
+}
+
 // New creates a new HTTP server bound to addr with handlers set up.
 func New(addr string) *Server {
 	return &Server{
@@ -148,7 +479,7 @@ func NewWithHandler(addr string, handler http.Handler) *Server {
 func (s *Server) Start() {
 	go func() {
 		if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("http server error: %v", err)
+			slog.Error("http server error", "err", err)
 		}
 	}()
 }
@@ -0,0 +1,141 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+// registerTaskRoutes mounts per-task operations:
+//
+//	POST /tasks/{id}/cancel  cancel a queued, scheduled or running task
+func registerTaskRoutes(mux *http.ServeMux, store q.Store, canceler *q.Canceler, logger *slog.Logger) {
+	type cancelResponse struct {
+		ID     string       `json:"id"`
+		Status q.TaskStatus `json:"status"`
+		// CancelRequested is set for a running task: it stops once its handler observes
+		// the canceled context.
+		CancelRequested bool `json:"cancel_requested,omitempty"`
+	}
+
+	mux.HandleFunc("/tasks/", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/cancel")
+		if !ok || id == "" || strings.Contains(id, "/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		t, err := canceler.Cancel(store, id)
+		switch {
+		case errors.Is(err, q.ErrTaskNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, q.ErrTaskFinished):
+			writeError(w, http.StatusConflict, errorResponse{Error: "task_finished", Message: "task is already " + string(t.Status)})
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, errorResponse{Error: "cancel_failed", Message: err.Error()})
+		case t.Status == q.StatusRunning:
+			logger.Info("task cancel requested", "task_id", t.ID, "task_type", t.Type)
+			writeJSON(w, http.StatusAccepted, cancelResponse{ID: t.ID, Status: t.Status, CancelRequested: true})
+		default:
+			logger.Info("task canceled", "task_id", t.ID, "task_type", t.Type, "while", "waiting")
+			writeJSON(w, http.StatusOK, cancelResponse{ID: t.ID, Status: t.Status})
+		}
+	})
+}
+
+// registerTaskListRoute mounts the task listing:
+//
+//	GET /tasks?status=failed,retrying&type=&queue=&label=env=prod&created_after=&limit=&cursor=
+func registerTaskListRoute(mux *http.ServeMux, store q.Store) {
+	type listResponse struct {
+		Tasks      []q.Task `json:"tasks"`
+		NextCursor string   `json:"next_cursor,omitempty"`
+	}
+
+	mux.HandleFunc("/tasks", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		filter, errResp := parseTaskFilter(r.URL.Query())
+		if errResp != nil {
+			writeError(w, http.StatusBadRequest, *errResp)
+			return
+		}
+		page, err := store.List(filter)
+		if errors.Is(err, q.ErrInvalidCursor) {
+			writeError(w, http.StatusBadRequest, errorResponse{Error: "invalid_cursor", Message: err.Error()})
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, errorResponse{Error: "list_failed", Message: err.Error()})
+			return
+		}
+		if page.Tasks == nil {
+			page.Tasks = []q.Task{}
+		}
+		writeJSON(w, http.StatusOK, listResponse{Tasks: page.Tasks, NextCursor: page.NextCursor})
+	})
+}
+
+// parseTaskFilter reads the listing filters from query parameters. Statuses may be repeated
+// or comma-separated, labels are repeated as label=key=value and times use RFC 3339.
+func parseTaskFilter(v url.Values) (q.TaskFilter, *errorResponse) {
+	invalid := func(format string, args ...any) (q.TaskFilter, *errorResponse) {
+		return q.TaskFilter{}, &errorResponse{Error: "invalid_filter", Message: fmt.Sprintf(format, args...)}
+	}
+	f := q.TaskFilter{Type: v.Get("type"), Queue: v.Get("queue"), Cursor: v.Get("cursor")}
+	for _, raw := range v["status"] {
+		for _, s := range strings.Split(raw, ",") {
+			status := q.TaskStatus(strings.TrimSpace(s))
+			if !slices.Contains(q.Statuses, status) {
+				return invalid("unknown status %q", s)
+			}
+			f.Statuses = append(f.Statuses, status)
+		}
+	}
+	for _, raw := range v["label"] {
+		key, value, ok := strings.Cut(raw, "=")
+		if !ok || key == "" {
+			return invalid("label must be key=value, got %q", raw)
+		}
+		if f.Labels == nil {
+			f.Labels = make(map[string]string)
+		}
+		f.Labels[key] = value
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{
+		{"created_after", &f.CreatedAfter}, {"created_before", &f.CreatedBefore},
+		{"updated_after", &f.UpdatedAfter}, {"updated_before", &f.UpdatedBefore},
+	} {
+		if raw := v.Get(p.name); raw != "" {
+			at, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return invalid("%s must be an RFC 3339 time, got %q", p.name, raw)
+			}
+			*p.dst = at
+		}
+	}
+	if raw := v.Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 || n > q.MaxListLimit {
+			return invalid("limit must be within [1, %d]", q.MaxListLimit)
+		}
+		f.Limit = n
+	}
+	return f, nil
+}
@@ -0,0 +1,103 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrCanceled is the cause set on the context of a running task canceled by Canceler.Cancel.
+	ErrCanceled = errors.New("task canceled")
+	// ErrTaskNotFound is returned for an unknown task id.
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrTaskFinished is returned when canceling a task that is already done, failed or canceled.
+	ErrTaskFinished = errors.New("task already finished")
+)
+
+// Canceler tracks the contexts of running tasks so they can be canceled by id. It is shared
+// by the worker pools and the HTTP layer. A nil *Canceler still lets workers run tasks but
+// never cancels them.
+type Canceler struct {
+	deadLetters *DeadLetterQueue
+
+	mu      sync.Mutex
+	running map[string]context.CancelCauseFunc
+}
+
+// NewCanceler creates a Canceler. Canceling a retrying task drops the attempt errors it has
+// collected in deadLetters, which may be nil.
+func NewCanceler(deadLetters *DeadLetterQueue) *Canceler {
+	return &Canceler{deadLetters: deadLetters, running: make(map[string]context.CancelCauseFunc)}
+}
+
+// Cancel cancels a task. A queued, scheduled or retrying task is marked canceled at once and
+// skipped by the worker or scheduler that picks it up. A running task has its context canceled with
+// ErrCanceled and is returned still running: its worker records the final state once the
+// handler returns.
+func (c *Canceler) Cancel(store Store, id string) (Task, error) {
+	if c.cancelRunning(id) {
+		t, _ := store.Get(id)
+		return t, nil
+	}
+	t, ok := store.Cancel(id)
+	switch {
+	case ok:
+		// queued, scheduled or retrying: the scheduler or worker holding it drops it
+		c.deadLetters.forget(id)
+		return t, nil
+	case t.ID == "":
+		return Task{}, ErrTaskNotFound
+	case t.Status.Finished():
+		return t, ErrTaskFinished
+	}
+	// running: a worker registers a task before marking it running, so it is found now
+	if c.cancelRunning(id) {
+		return t, nil
+	}
+	// left running by a stopped worker
+	if updated, ok := store.UpdateStatus(id, StatusCanceled, t.Attempt); ok {
+		c.deadLetters.forget(id)
+		t = updated
+	}
+	return t, nil
+}
+
+// cancelRunning cancels the context of a running task and reports whether there was one.
+func (c *Canceler) cancelRunning(id string) bool {
+	c.mu.Lock()
+	cancel, ok := c.running[id]
+	c.mu.Unlock()
+	if ok {
+		cancel(ErrCanceled)
+	}
+	return ok
+}
+
+// begin marks t running and returns the context its attempt runs under. It reports false
+// when t was canceled while queued.
+func (c *Canceler) begin(ctx context.Context, store Store, t Task) (context.Context, context.CancelCauseFunc, bool) {
+	taskCtx, cancel := context.WithCancelCause(ctx)
+	if c != nil {
+		// registered before the task turns running, so Cancel never sees it running untracked
+		c.mu.Lock()
+		c.running[t.ID] = cancel
+		c.mu.Unlock()
+	}
+	if cur, ok := store.UpdateStatus(t.ID, StatusRunning, t.Attempt); !ok && cur.Status == StatusCanceled {
+		c.end(t.ID)
+		cancel(nil)
+		return nil, nil, false
+	}
+	return taskCtx, cancel, true
+}
+
+// end stops tracking a task begun with begin.
+func (c *Canceler) end(id string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.running, id)
+}
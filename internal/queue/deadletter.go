@@ -0,0 +1,261 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrDeadLetterNotFound is returned for operations on an unknown dead letter.
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	// ErrQueueFull is returned when a redriven task does not fit into its queue.
+	ErrQueueFull = errors.New("queue full")
+)
+
+// AttemptError describes one failed attempt of a task.
+type AttemptError struct {
+	Attempt    int       `json:"attempt"`
+	Error      string    `json:"error"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// DeadLetter is a task that exhausted its retries, together with the error of every attempt.
+type DeadLetter struct {
+	Task   Task           `json:"task"`
+	Errors []AttemptError `json:"errors"`
+	DeadAt time.Time      `json:"dead_at"`
+}
+
+// DeadLetterQueue keeps tasks that failed for good until they are redriven or purged.
+// While a task is being retried its attempt errors are collected here as well, so the
+// dead letter carries the full failure history.
+type DeadLetterQueue struct {
+	path string
+
+	mu      sync.Mutex
+	letters map[string]DeadLetter
+	pending map[string][]AttemptError
+}
+
+// NewDeadLetterQueue creates a dead-letter queue persisted as JSON at path; an empty path
+// keeps dead letters in memory only. Existing dead letters are loaded from path.
+func NewDeadLetterQueue(path string) (*DeadLetterQueue, error) {
+	d := &DeadLetterQueue{
+		path:    path,
+		letters: make(map[string]DeadLetter),
+		pending: make(map[string][]AttemptError),
+	}
+	if path == "" {
+		return d, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return d, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("deadletter: read state: %w", err)
+	}
+	var letters []DeadLetter
+	if err := json.Unmarshal(data, &letters); err != nil {
+		return nil, fmt.Errorf("deadletter: decode state: %w", err)
+	}
+	for _, dl := range letters {
+		d.letters[dl.Task.ID] = dl
+	}
+	return d, nil
+}
+
+// recordAttempt remembers a failed attempt of a task that is going to be retried.
+func (d *DeadLetterQueue) recordAttempt(id string, ae AttemptError) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pending[id] = append(d.pending[id], ae)
+}
+
+// forget drops the attempt errors of a task that will not be buried: it finished without
+// failing for good, was canceled or was interrupted by shutdown.
+func (d *DeadLetterQueue) forget(id string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pending, id)
+}
+
+// bury moves a task that failed its last attempt into the dead-letter queue.
+func (d *DeadLetterQueue) bury(t Task, last AttemptError) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	errs := append(d.pending[t.ID], last)
+	delete(d.pending, t.ID)
+	t.Status = StatusFailed
+	d.letters[t.ID] = DeadLetter{Task: t, Errors: errs, DeadAt: last.FinishedAt}
+	d.persist()
+}
+
+// List returns dead letters ordered by the time they failed.
+func (d *DeadLetterQueue) List() []DeadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]DeadLetter, 0, len(d.letters))
+	for _, dl := range d.letters {
+		out = append(out, dl)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].DeadAt.Equal(out[j].DeadAt) {
+			return out[i].DeadAt.Before(out[j].DeadAt)
+		}
+		return out[i].Task.ID < out[j].Task.ID
+	})
+	return out
+}
+
+// Get returns a dead letter by task ID.
+func (d *DeadLetterQueue) Get(id string) (DeadLetter, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl, ok := d.letters[id]
+	return dl, ok
+}
+
+// Len returns the number of dead letters.
+func (d *DeadLetterQueue) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.letters)
+}
+
+// Redrive puts a dead task back into its queue with a reset attempt counter and removes it
+// from the dead-letter queue. It returns ErrQueueFull, leaving the dead letter in place,
+// when the queue has no room.
+func (d *DeadLetterQueue) Redrive(store Store, queue Pusher, id string) (Task, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl, ok := d.letters[id]
+	if !ok {
+		return Task{}, ErrDeadLetterNotFound
+	}
+	t, err := d.redrive(store, queue, dl)
+	if err != nil {
+		return Task{}, err
+	}
+	d.persist()
+	return t, nil
+}
+
+// RedriveAll redrives dead letters oldest first until the queue is full. It returns the
+// number of redriven tasks.
+func (d *DeadLetterQueue) RedriveAll(store Store, queue Pusher) (int, error) {
+	letters := d.List()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	var err error
+	for _, dl := range letters {
+		if _, ok := d.letters[dl.Task.ID]; !ok {
+			continue // purged or redriven concurrently
+		}
+		if _, err = d.redrive(store, queue, dl); err != nil {
+			break
+		}
+		n++
+	}
+	if n > 0 {
+		d.persist()
+	}
+	return n, err
+}
+
+// redrive must be called with d.mu held. It starts from the stored task, so the history and
+// result recorded since the task died are kept; the copy in dl is only a fallback for a task
+// the store no longer has.
+func (d *DeadLetterQueue) redrive(store Store, queue Pusher, dl DeadLetter) (Task, error) {
+	prev, existed := store.Get(dl.Task.ID)
+	if !existed {
+		prev = dl.Task
+	}
+	t := prev
+	t.Status = StatusQueued
+	t.Attempt = 0
+	t.RetryDelay = 0
+	t.NextAttemptAt = nil
+	// save first so a fast worker never updates a task the store does not know yet
+	t = store.Save(t)
+	if !queue.TryPush(t) {
+		if existed {
+			store.Save(prev)
+		} else {
+			store.Delete(t.ID)
+		}
+		return Task{}, ErrQueueFull
+	}
+	delete(d.letters, t.ID)
+	return t, nil
+}
+
+// Purge deletes a dead letter; the task stays failed in the store.
+func (d *DeadLetterQueue) Purge(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.letters[id]; !ok {
+		return false
+	}
+	delete(d.letters, id)
+	d.persist()
+	return true
+}
+
+// PurgeAll deletes every dead letter and returns how many were removed.
+func (d *DeadLetterQueue) PurgeAll() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := len(d.letters)
+	d.letters = make(map[string]DeadLetter)
+	d.persist()
+	return n
+}
+
+// persist writes all dead letters to the state file. Must be called with d.mu held.
+// Failures are logged: the in-memory state stays authoritative for the running process.
+func (d *DeadLetterQueue) persist() {
+	if d.path == "" {
+		return
+	}
+	letters := make([]DeadLetter, 0, len(d.letters))
+	for _, dl := range d.letters {
+		letters = append(letters, dl)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].Task.ID < letters[j].Task.ID })
+	data, err := json.MarshalIndent(letters, "", "  ")
+	if err != nil {
+		slog.Error("deadletter: encode state", "err", err)
+		return
+	}
+	tmp := d.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(d.path), 0o755); err != nil {
+		slog.Error("deadletter: create state dir", "err", err)
+		return
+	}
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		slog.Error("deadletter: write state", "err", err)
+		return
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		slog.Error("deadletter: install state", "err", err)
+	}
+}
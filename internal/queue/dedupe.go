@@ -0,0 +1,45 @@
+package queue
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// DefaultIdempotencyWindow is how long an idempotency key is remembered when no window is
+// configured.
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// UniqueKey identifies the work of a unique task: a hash of its type and payload.
+func UniqueKey(taskType string, payload []byte) string {
+	h := sha256.New()
+	h.Write([]byte(taskType))
+	h.Write([]byte{0})
+	h.Write(payload)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// FindByIdempotencyKey returns the newest task enqueued with key if it was created at or
+// after since.
+func (s *MemoryStore) FindByIdempotencyKey(key string, since time.Time) (Task, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	k, ok := s.index.byIdempotencyKey[key]
+	if !ok || k.created.Before(since) {
+		return Task{}, false
+	}
+	t, ok := s.tasks[k.id]
+	return t, ok
+}
+
+// FindActiveUnique returns the unfinished task holding the unique key.
+func (s *MemoryStore) FindActiveUnique(key string) (Task, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.index.activeUnique[key]
+	if !ok {
+		return Task{}, false
+	}
+	t, ok := s.tasks[id]
+	return t, ok
+}
@@ -25,3 +25,22 @@ func TryEnqueueWithContext(ctx context.Context, ch chan<- Task, t Task, retrySle
 		}
 	}
 }
+
+// PushWithContext is TryEnqueueWithContext for a Pusher: it retries TryPush while the queue
+// is full until ctx is done.
+func PushWithContext(ctx context.Context, q Pusher, t Task, retrySleep time.Duration) bool {
+	if retrySleep <= 0 {
+		retrySleep = 10 * time.Millisecond
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		default:
+		}
+		if q.TryPush(t) {
+			return true
+		}
+		time.Sleep(retrySleep)
+	}
+}
@@ -0,0 +1,446 @@
+package queue
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.gob"
+
+	// DefaultSnapshotEvery is the number of logged mutations after which the log is compacted.
+	DefaultSnapshotEvery = 1000
+)
+
+// FileStoreOptions tunes durability and compaction of a FileStore.
+type FileStoreOptions struct {
+	// SnapshotEvery compacts the log into a snapshot after this many records (default 1000).
+	SnapshotEvery int
+	// NoSync skips fsync after each record. Faster, but a power loss may drop the latest writes.
+	NoSync bool
+}
+
+// FileStore is a durable Store: every mutation is applied in memory and appended to a
+// checksummed write-ahead log in dir. The log is periodically compacted into a snapshot,
+// and on open the snapshot plus log are replayed to rebuild state.
+type FileStore struct {
+	*MemoryStore
+
+	mu sync.Mutex // serializes mutations with their log appends
+	// compactMu serializes compactions. It is taken before mu, never while holding it.
+	compactMu sync.Mutex
+	dir       string
+	opts      FileStoreOptions
+	wal       *os.File
+	records   int
+	// gen is the generation of the next snapshot; see compact.
+	gen       uint64
+	recovered []Task
+}
+
+// snapshotFile is the content of the snapshot file. Gen orders it against rotated logs:
+// the log rotated by the compaction that writes snapshot Gen is named with Gen-1.
+type snapshotFile struct {
+	Gen   uint64
+	Tasks []Task
+}
+
+var _ Store = (*FileStore)(nil)
+
+// OpenFileStore opens (or creates) a file-backed store in dir and replays its state.
+// Tasks that were queued or running when the process stopped are reset to queued and
+// returned by Recovered together with scheduled and retrying tasks, so the caller can put
+// them back into the work queue or the scheduler.
+func OpenFileStore(dir string, opts FileStoreOptions) (*FileStore, error) {
+	if opts.SnapshotEvery <= 0 {
+		opts.SnapshotEvery = DefaultSnapshotEvery
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("filestore: create dir: %w", err)
+	}
+	fs := &FileStore{MemoryStore: NewStore(), dir: dir, opts: opts}
+	if err := fs.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := fs.replayRotated(); err != nil {
+		return nil, err
+	}
+	if err := fs.replayWAL(); err != nil {
+		return nil, err
+	}
+	fs.recoverPending()
+	// Start from a compact state: fold the replayed log (and recovery updates) into a snapshot.
+	if err := fs.compact(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// Recovered returns tasks that must be re-enqueued (status queued) or re-scheduled
+// (status scheduled or retrying) after a restart, oldest first.
+func (fs *FileStore) Recovered() []Task {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]Task(nil), fs.recovered...)
+}
+
+// Save creates or updates a task and logs it.
+func (fs *FileStore) Save(t Task) Task {
+	defer fs.compactIfDue()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	saved := fs.MemoryStore.Save(t)
+	fs.append(walRecord{Op: walPut, ID: saved.ID, Task: saved})
+	return saved
+}
+
+// Delete removes a task if it exists and logs the removal.
+func (fs *FileStore) Delete(id string) bool {
+	defer fs.compactIfDue()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	ok := fs.MemoryStore.remove(id)
+	if ok {
+		fs.append(walRecord{Op: walDelete, ID: id})
+	}
+	return ok
+}
+
+// UpdateStatus sets status and attempt for a task if it exists and logs the result.
+func (fs *FileStore) UpdateStatus(id string, status TaskStatus, attempt int) (Task, bool) {
+	defer fs.compactIfDue()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	t, ok := fs.MemoryStore.UpdateStatus(id, status, attempt)
+	if ok {
+		fs.append(walRecord{Op: walPut, ID: id, Task: t})
+	}
+	return t, ok
+}
+
+// Cancel marks a waiting task canceled and logs the result.
+func (fs *FileStore) Cancel(id string) (Task, bool) {
+	defer fs.compactIfDue()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	t, ok := fs.MemoryStore.Cancel(id)
+	if ok {
+		fs.append(walRecord{Op: walPut, ID: id, Task: t})
+	}
+	return t, ok
+}
+
+// ScheduleRetry marks a task retrying if it exists and logs the result.
+func (fs *FileStore) ScheduleRetry(id string, attempt int, delay time.Duration) (Task, bool) {
+	defer fs.compactIfDue()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	t, ok := fs.MemoryStore.ScheduleRetry(id, attempt, delay)
+	if ok {
+		fs.append(walRecord{Op: walPut, ID: id, Task: t})
+	}
+	return t, ok
+}
+
+// RecordAttempt appends to the attempt history of a task if it exists and logs the result.
+func (fs *FileStore) RecordAttempt(id string, rec AttemptRecord, limit int) (Task, bool) {
+	defer fs.compactIfDue()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	t, ok := fs.MemoryStore.RecordAttempt(id, rec, limit)
+	if ok {
+		fs.append(walRecord{Op: walPut, ID: id, Task: t})
+	}
+	return t, ok
+}
+
+// SetResult stores the output of a task if it exists and logs the result.
+func (fs *FileStore) SetResult(id string, r TaskResult) (Task, bool) {
+	defer fs.compactIfDue()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	t, ok := fs.MemoryStore.SetResult(id, r)
+	if ok {
+		fs.append(walRecord{Op: walPut, ID: id, Task: t})
+	}
+	return t, ok
+}
+
+// EvictResults drops expired result data and logs every evicted task.
+func (fs *FileStore) EvictResults(cutoff time.Time) int {
+	defer fs.compactIfDue()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	evicted := fs.MemoryStore.evictResults(cutoff)
+	for _, t := range evicted {
+		fs.append(walRecord{Op: walPut, ID: t.ID, Task: t})
+	}
+	return len(evicted)
+}
+
+// Close writes a final snapshot and closes the log.
+func (fs *FileStore) Close() error {
+	fs.compactMu.Lock()
+	defer fs.compactMu.Unlock()
+	err := fs.compact()
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.wal == nil {
+		return nil
+	}
+	if cerr := fs.wal.Close(); err == nil {
+		err = cerr
+	}
+	fs.wal = nil
+	return err
+}
+
+// append writes rec to the log. Must be called with fs.mu held. Write failures are
+// logged: the in-memory state stays authoritative for the running process.
+func (fs *FileStore) append(rec walRecord) {
+	if fs.wal == nil {
+		return
+	}
+	buf, err := encodeWALRecord(rec)
+	if err == nil {
+		_, err = fs.wal.Write(buf)
+	}
+	if err == nil && !fs.opts.NoSync {
+		err = fs.wal.Sync()
+	}
+	if err != nil {
+		slog.Error("filestore: append to wal", "err", err)
+		return
+	}
+	fs.records++
+}
+
+// compactIfDue compacts once the log reaches the snapshot threshold. Mutations defer it so
+// that it runs after fs.mu is released; while another compaction runs it does nothing.
+func (fs *FileStore) compactIfDue() {
+	fs.mu.Lock()
+	due := fs.wal != nil && fs.records >= fs.opts.SnapshotEvery
+	fs.mu.Unlock()
+	if !due || !fs.compactMu.TryLock() {
+		return
+	}
+	defer fs.compactMu.Unlock()
+	if err := fs.compact(); err != nil {
+		slog.Error("filestore: snapshot", "err", err)
+	}
+}
+
+func (fs *FileStore) loadSnapshot() error {
+	f, err := os.Open(filepath.Join(fs.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("filestore: open snapshot: %w", err)
+	}
+	defer f.Close()
+	var snap snapshotFile
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return fmt.Errorf("filestore: decode snapshot: %w", err)
+	}
+	for _, t := range snap.Tasks {
+		fs.MemoryStore.restore(t)
+	}
+	fs.gen = snap.Gen
+	return nil
+}
+
+// applyWAL applies one replayed log record.
+func (fs *FileStore) applyWAL(rec walRecord) {
+	switch rec.Op {
+	case walPut:
+		fs.MemoryStore.restore(rec.Task)
+	case walDelete:
+		fs.MemoryStore.remove(rec.ID)
+	}
+}
+
+// rotatedWALPath names the log rotated away by the compaction writing snapshot gen+1.
+func (fs *FileStore) rotatedWALPath(gen uint64) string {
+	return filepath.Join(fs.dir, "wal."+strconv.FormatUint(gen, 10)+".log")
+}
+
+// rotatedWALs returns the generations of the rotated logs in dir, oldest first.
+func (fs *FileStore) rotatedWALs() ([]uint64, error) {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return nil, fmt.Errorf("filestore: read dir: %w", err)
+	}
+	var gens []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, "wal.") || !strings.HasSuffix(name, ".log") || name == walFileName {
+			continue
+		}
+		if gen, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, "wal."), ".log"), 10, 64); err == nil {
+			gens = append(gens, gen)
+		}
+	}
+	sort.Slice(gens, func(i, j int) bool { return gens[i] < gens[j] })
+	return gens, nil
+}
+
+// replayRotated applies the rotated logs the snapshot does not cover yet: a crash hit their
+// compaction before it installed its snapshot. Logs the snapshot covers are deleted. The
+// next generation moves past the replayed logs so that no rotation overwrites them.
+func (fs *FileStore) replayRotated() error {
+	gens, err := fs.rotatedWALs()
+	if err != nil {
+		return err
+	}
+	for _, gen := range gens {
+		path := fs.rotatedWALPath(gen)
+		if gen < fs.gen {
+			_ = os.Remove(path)
+			continue
+		}
+		fs.gen = gen + 1
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("filestore: open rotated wal: %w", err)
+		}
+		_, err = readWAL(f, fs.applyWAL)
+		f.Close()
+		if err != nil {
+			slog.Error("filestore: damaged rotated wal", "err", err, "path", path)
+		}
+	}
+	return nil
+}
+
+// replayWAL applies the log on top of the snapshot and leaves it open for appending.
+// A damaged tail (e.g. a write torn by a crash) is truncated.
+func (fs *FileStore) replayWAL() error {
+	f, err := os.OpenFile(filepath.Join(fs.dir, walFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("filestore: open wal: %w", err)
+	}
+	valid, err := readWAL(f, fs.applyWAL)
+	if err != nil {
+		slog.Error("filestore: truncating wal", "err", err)
+		if terr := f.Truncate(valid); terr != nil {
+			f.Close()
+			return fmt.Errorf("filestore: truncate wal: %w", terr)
+		}
+	}
+	if _, err := f.Seek(valid, 0); err != nil {
+		f.Close()
+		return fmt.Errorf("filestore: seek wal: %w", err)
+	}
+	fs.wal = f
+	return nil
+}
+
+// recoverPending resets running tasks to queued and collects all queued, scheduled and
+// retrying tasks.
+func (fs *FileStore) recoverPending() {
+	for _, t := range fs.MemoryStore.snapshot() {
+		switch t.Status {
+		case StatusRunning:
+			t, _ = fs.MemoryStore.UpdateStatus(t.ID, StatusQueued, t.Attempt)
+		case StatusQueued, StatusScheduled, StatusRetrying:
+		default:
+			continue
+		}
+		fs.recovered = append(fs.recovered, t)
+	}
+	sort.Slice(fs.recovered, func(i, j int) bool {
+		return fs.recovered[i].CreatedAt.Before(fs.recovered[j].CreatedAt)
+	})
+}
+
+// compact writes the full state to a new snapshot and drops the logs it covers. The state
+// is copied and the log rotated under fs.mu; the snapshot is written after releasing it, so
+// mutations are not blocked meanwhile. Compactions must not overlap: callers hold
+// fs.compactMu, or own the store exclusively while opening it.
+//
+// The snapshot is written to a temporary file and renamed into place. Its generation tells
+// recovery whether the rotated log is folded in, so a crash at any point replays correctly.
+func (fs *FileStore) compact() error {
+	fs.mu.Lock()
+	if fs.wal == nil {
+		fs.mu.Unlock()
+		return nil
+	}
+	snap, err := fs.rotate()
+	fs.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(fs.dir, snapshotFileName+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("filestore: create snapshot: %w", err)
+	}
+	if err := gob.NewEncoder(f).Encode(snap); err != nil {
+		f.Close()
+		return fmt.Errorf("filestore: encode snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("filestore: sync snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("filestore: close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(fs.dir, snapshotFileName)); err != nil {
+		return fmt.Errorf("filestore: install snapshot: %w", err)
+	}
+	syncDir(fs.dir)
+	// the snapshot covers every log rotated so far, including those of failed compactions
+	gens, err := fs.rotatedWALs()
+	if err != nil {
+		return err
+	}
+	for _, gen := range gens {
+		if gen < snap.Gen {
+			_ = os.Remove(fs.rotatedWALPath(gen))
+		}
+	}
+	return nil
+}
+
+// rotate moves the log aside, starts an empty one and returns a copy of the state it
+// leaves behind. Must be called with fs.mu held.
+func (fs *FileStore) rotate() (snapshotFile, error) {
+	walPath := filepath.Join(fs.dir, walFileName)
+	rotated := fs.rotatedWALPath(fs.gen)
+	if err := os.Rename(walPath, rotated); err != nil {
+		return snapshotFile{}, fmt.Errorf("filestore: rotate wal: %w", err)
+	}
+	f, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		_ = os.Rename(rotated, walPath)
+		return snapshotFile{}, fmt.Errorf("filestore: open wal: %w", err)
+	}
+	syncDir(fs.dir)
+	fs.wal.Close()
+	fs.wal = f
+	fs.records = 0
+	fs.gen++
+	return snapshotFile{Gen: fs.gen, Tasks: fs.MemoryStore.snapshot()}, nil
+}
+
+// syncDir flushes directory metadata so a rename survives a crash; errors are ignored
+// because not every platform supports fsync on directories.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,188 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SimulateTaskType is the task type served by the built-in simulation handler.
+const SimulateTaskType = "simulate"
+
+// ErrNoHandler is returned when no handler is registered for a task type.
+var ErrNoHandler = errors.New("no handler registered for task type")
+
+// ErrTimeout is returned for an attempt that exceeded its deadline. It is retried like any
+// other failure.
+var ErrTimeout = errors.New("attempt timed out")
+
+// PanicError is the error of an attempt whose handler panicked.
+type PanicError struct {
+	Value any
+	// Stack is the goroutine stack at the panic, truncated to maxPanicStack bytes.
+	Stack string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// maxPanicStack bounds the stack trace kept per panicked attempt.
+const maxPanicStack = 8 << 10
+
+// ErrSimulatedFailure is returned by the simulation handler for a randomly failed attempt.
+var ErrSimulatedFailure = errors.New("simulated failure")
+
+// Result is the output produced by a handler for a successfully processed task.
+type Result struct {
+	ContentType string
+	Data        []byte
+}
+
+// Handler processes a single attempt of a task. A non-nil error marks the attempt as failed,
+// after which the worker retries the task while attempts are left.
+type Handler interface {
+	Handle(ctx context.Context, t Task) (Result, error)
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(ctx context.Context, t Task) (Result, error)
+
+// Handle calls f(ctx, t).
+func (f HandlerFunc) Handle(ctx context.Context, t Task) (Result, error) {
+	return f(ctx, t)
+}
+
+// TypePolicy holds the defaults applied to tasks of one type when the client does not set them.
+type TypePolicy struct {
+	// MaxRetries is the default number of retries after the first attempt.
+	MaxRetries int
+	// Timeout bounds a single attempt; zero means no deadline.
+	Timeout time.Duration
+	// Retry, when set, replaces the retry policy of the queue for tasks of this type.
+	Retry *RetryPolicy
+	// Unique makes every task of this type unique; see Task.UniqueKey.
+	Unique bool
+}
+
+// Registry maps task types to handlers and their policies. It is safe for concurrent use.
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	policies map[string]TypePolicy
+	fallback Handler
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]Handler), policies: make(map[string]TypePolicy)}
+}
+
+// Register binds h to taskType with a zero policy, replacing any previous registration.
+func (r *Registry) Register(taskType string, h Handler) {
+	r.RegisterType(taskType, h, TypePolicy{})
+}
+
+// RegisterType binds h and its default policy to taskType, replacing any previous registration.
+func (r *Registry) RegisterType(taskType string, h Handler, p TypePolicy) {
+	if p.MaxRetries < 0 {
+		p.MaxRetries = 0
+	}
+	if p.Timeout < 0 {
+		p.Timeout = 0
+	}
+	if p.Retry != nil && p.Retry.Validate() != nil {
+		p.Retry = nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[taskType] = h
+	r.policies[taskType] = p
+}
+
+// Policy returns the default policy registered for taskType.
+func (r *Registry) Policy(taskType string) TypePolicy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.policies[taskType]
+}
+
+// Types returns the sorted list of task types with a dedicated handler.
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// SetDefault sets the handler used for task types without a dedicated registration.
+func (r *Registry) SetDefault(h Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = h
+}
+
+// Lookup returns the handler registered for taskType, falling back to the default handler.
+func (r *Registry) Lookup(taskType string) (Handler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if h, ok := r.handlers[taskType]; ok {
+		return h, true
+	}
+	if r.fallback != nil {
+		return r.fallback, true
+	}
+	return nil, false
+}
+
+// Dispatch runs t through its registered handler. A panic in the handler is recovered and
+// returned as a *PanicError, so it fails the attempt instead of the process.
+func (r *Registry) Dispatch(ctx context.Context, t Task) (res Result, err error) {
+	h, ok := r.Lookup(t.Type)
+	if !ok {
+		return Result{}, fmt.Errorf("%w: %q", ErrNoHandler, t.Type)
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			stack := debug.Stack()
+			if len(stack) > maxPanicStack {
+				stack = stack[:maxPanicStack]
+			}
+			res, err = Result{}, &PanicError{Value: v, Stack: string(stack)}
+		}
+	}()
+	return h.Handle(ctx, t)
+}
+
+// NewSimulateHandler returns a handler that sleeps for 100-500ms and fails with
+// approximately 20% probability. It is deterministic for a given seed when used sequentially.
+func NewSimulateHandler(seed int64) Handler {
+	var mu sync.Mutex
+	rng := rand.New(rand.NewSource(seed))
+	return HandlerFunc(func(ctx context.Context, t Task) (Result, error) {
+		mu.Lock()
+		sleepMs := 100 + rng.Intn(401) // [100,500]
+		fail := rng.Intn(100) < 20
+		mu.Unlock()
+
+		timer := time.NewTimer(time.Duration(sleepMs) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return Result{}, ctx.Err()
+		case <-timer.C:
+		}
+		if fail {
+			return Result{}, ErrSimulatedFailure
+		}
+		return Result{}, nil
+	})
+}
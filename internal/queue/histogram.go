@@ -0,0 +1,156 @@
+package queue
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DurationBuckets are the upper bounds of the buckets of every Histogram; a last, unbounded
+// bucket holds longer observations.
+var DurationBuckets = [...]time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+	30 * time.Second, time.Minute, 5 * time.Minute,
+}
+
+// Histogram counts durations into DurationBuckets. The zero value is ready to use, Observe is
+// lock-free, and a nil *Histogram ignores observations.
+type Histogram struct {
+	counts [len(DurationBuckets) + 1]atomic.Uint64
+	sum    atomic.Int64
+}
+
+// Observe records one duration; negative durations count as zero.
+func (h *Histogram) Observe(d time.Duration) {
+	if h == nil {
+		return
+	}
+	if d < 0 {
+		d = 0
+	}
+	i := sort.Search(len(DurationBuckets), func(i int) bool { return d <= DurationBuckets[i] })
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// HistogramSnapshot is a point-in-time copy of a Histogram.
+type HistogramSnapshot struct {
+	// Counts holds the observations per bucket (not cumulative); the last one is unbounded.
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Snapshot copies the histogram. Concurrent observations may be partly included.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	s := HistogramSnapshot{Counts: make([]uint64, len(h.counts))}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+		s.Count += s.Counts[i]
+	}
+	s.Sum = time.Duration(h.sum.Load())
+	return s
+}
+
+// merge adds the observations of o to s.
+func (s *HistogramSnapshot) merge(o HistogramSnapshot) {
+	if s.Counts == nil {
+		s.Counts = make([]uint64, len(DurationBuckets)+1)
+	}
+	for i, n := range o.Counts {
+		s.Counts[i] += n
+	}
+	s.Count += o.Count
+	s.Sum += o.Sum
+}
+
+// Quantile estimates the p-quantile (0 ≤ p ≤ 1) by linear interpolation inside the bucket
+// that holds it, as Prometheus' histogram_quantile does. Observations in the unbounded
+// bucket are reported as the last bound; an empty snapshot yields zero.
+func (s HistogramSnapshot) Quantile(p float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	p = min(max(p, 0), 1)
+	rank := p * float64(s.Count)
+	last := DurationBuckets[len(DurationBuckets)-1]
+	var cumulative uint64
+	for i, n := range s.Counts {
+		if n == 0 || float64(cumulative+n) < rank {
+			cumulative += n
+			continue
+		}
+		if i == len(DurationBuckets) {
+			return last
+		}
+		var lower time.Duration
+		if i > 0 {
+			lower = DurationBuckets[i-1]
+		}
+		upper := DurationBuckets[i]
+		return lower + time.Duration((rank-float64(cumulative))/float64(n)*float64(upper-lower))
+	}
+	return last
+}
+
+// Percentiles summarizes a histogram; durations are in seconds.
+type Percentiles struct {
+	Count uint64
+	Mean  float64
+	P50   float64
+	P90   float64
+	P99   float64
+}
+
+// Percentiles returns the count, mean and estimated p50/p90/p99 of s.
+func (s HistogramSnapshot) Percentiles() Percentiles {
+	out := Percentiles{
+		Count: s.Count,
+		P50:   s.Quantile(0.5).Seconds(),
+		P90:   s.Quantile(0.9).Seconds(),
+		P99:   s.Quantile(0.99).Seconds(),
+	}
+	if s.Count > 0 {
+		out.Mean = s.Sum.Seconds() / float64(s.Count)
+	}
+	return out
+}
+
+// Latency holds the latency histograms of a queue or a task type: Wait from enqueue to the
+// start of an attempt, Run the attempts themselves, Total from the time a task was due
+// (created, or its run_at) to its final outcome.
+type Latency struct {
+	Wait  HistogramSnapshot
+	Run   HistogramSnapshot
+	Total HistogramSnapshot
+}
+
+// Merge adds the observations of o to l.
+func (l *Latency) Merge(o Latency) {
+	l.Wait.merge(o.Wait)
+	l.Run.merge(o.Run)
+	l.Total.merge(o.Total)
+}
+
+// LatencyReport is the percentile summary of a Latency.
+type LatencyReport struct {
+	Wait  Percentiles
+	Run   Percentiles
+	Total Percentiles
+}
+
+// Report summarizes l.
+func (l Latency) Report() LatencyReport {
+	return LatencyReport{Wait: l.Wait.Percentiles(), Run: l.Run.Percentiles(), Total: l.Total.Percentiles()}
+}
+
+// latencyHistograms are the live histograms behind a Latency.
+type latencyHistograms struct {
+	wait, run, total Histogram
+}
+
+func (h *latencyHistograms) snapshot() Latency {
+	return Latency{Wait: h.wait.Snapshot(), Run: h.run.Snapshot(), Total: h.total.Snapshot()}
+}
@@ -0,0 +1,320 @@
+package queue
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// List limits.
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// ErrInvalidCursor is returned by List for a cursor it did not issue.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// TaskFilter selects tasks for Store.List. Zero fields match every task. Time ranges are
+// half-open: [After, Before).
+type TaskFilter struct {
+	// Statuses matches any of the given statuses.
+	Statuses      []TaskStatus
+	Type          string
+	Queue         string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	// Labels matches tasks carrying all of the given labels.
+	Labels map[string]string
+	// Limit bounds the page size: DefaultListLimit when zero, at most MaxListLimit.
+	Limit int
+	// Cursor continues a previous listing from its TaskPage.NextCursor.
+	Cursor string
+}
+
+// TaskPage is one page of a listing, ordered by creation time and then id.
+type TaskPage struct {
+	Tasks []Task
+	// NextCursor fetches the next page; empty on the last page.
+	NextCursor string
+}
+
+// match reports whether t passes every filter except the cursor and limit.
+func (f TaskFilter) match(t Task) bool {
+	if len(f.Statuses) > 0 && !containsStatus(f.Statuses, t.Status) {
+		return false
+	}
+	if (f.Type != "" && t.Type != f.Type) || (f.Queue != "" && t.Queue != f.Queue) {
+		return false
+	}
+	if !inRange(t.CreatedAt, f.CreatedAfter, f.CreatedBefore) || !inRange(t.UpdatedAt, f.UpdatedAfter, f.UpdatedBefore) {
+		return false
+	}
+	for k, v := range f.Labels {
+		if got, ok := t.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+func containsStatus(statuses []TaskStatus, s TaskStatus) bool {
+	for _, st := range statuses {
+		if st == s {
+			return true
+		}
+	}
+	return false
+}
+
+func inRange(at, after, before time.Time) bool {
+	return (after.IsZero() || !at.Before(after)) && (before.IsZero() || at.Before(before))
+}
+
+// orderKey is the listing order of a task.
+type orderKey struct {
+	created time.Time
+	id      string
+}
+
+func (k orderKey) less(o orderKey) bool {
+	if !k.created.Equal(o.created) {
+		return k.created.Before(o.created)
+	}
+	return k.id < o.id
+}
+
+func keyOf(t Task) orderKey { return orderKey{created: t.CreatedAt, id: t.ID} }
+
+// encodeCursor makes an opaque cursor pointing after k.
+func encodeCursor(k orderKey) string {
+	raw := k.created.Format(time.RFC3339Nano) + "|" + k.id
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(c string) (orderKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil {
+		return orderKey{}, ErrInvalidCursor
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	created, err := time.Parse(time.RFC3339Nano, ts)
+	if !ok || err != nil || id == "" {
+		return orderKey{}, ErrInvalidCursor
+	}
+	return orderKey{created: created, id: id}, nil
+}
+
+type idSet map[string]struct{}
+
+// taskIndex holds the secondary indexes of a MemoryStore: every task in listing order and
+// the ids per status, type, queue and label. It is guarded by the store's mutex.
+type taskIndex struct {
+	order    []orderKey
+	byStatus map[TaskStatus]idSet
+	byType   map[string]idSet
+	byQueue  map[string]idSet
+	// byLabel is keyed by "key=value".
+	byLabel map[string]idSet
+	// byIdempotencyKey holds the newest task of each idempotency key.
+	byIdempotencyKey map[string]orderKey
+	// activeUnique holds the unfinished task of each unique key.
+	activeUnique map[string]string
+}
+
+func newTaskIndex() taskIndex {
+	return taskIndex{
+		byStatus: make(map[TaskStatus]idSet),
+		byType:   make(map[string]idSet),
+		byQueue:  make(map[string]idSet),
+		byLabel:  make(map[string]idSet),
+
+		byIdempotencyKey: make(map[string]orderKey),
+		activeUnique:     make(map[string]string),
+	}
+}
+
+// update moves a task from its old indexed state to t. Either may be nil for an inserted or
+// removed task.
+func (ix *taskIndex) update(old, t *Task) {
+	if old != nil {
+		if t == nil || !old.CreatedAt.Equal(t.CreatedAt) {
+			ix.removeKey(keyOf(*old))
+		}
+		removeID(ix.byStatus, old.Status, old.ID)
+		removeID(ix.byType, old.Type, old.ID)
+		removeID(ix.byQueue, old.Queue, old.ID)
+		for k, v := range old.Labels {
+			removeID(ix.byLabel, labelKey(k, v), old.ID)
+		}
+		if k, ok := ix.byIdempotencyKey[old.IdempotencyKey]; ok && k.id == old.ID {
+			delete(ix.byIdempotencyKey, old.IdempotencyKey)
+		}
+		ix.setUniqueActive(*old, false)
+	}
+	if t != nil {
+		if old == nil || !old.CreatedAt.Equal(t.CreatedAt) {
+			ix.insertKey(keyOf(*t))
+		}
+		addID(ix.byStatus, t.Status, t.ID)
+		addID(ix.byType, t.Type, t.ID)
+		addID(ix.byQueue, t.Queue, t.ID)
+		for k, v := range t.Labels {
+			addID(ix.byLabel, labelKey(k, v), t.ID)
+		}
+		if t.IdempotencyKey != "" {
+			if k, ok := ix.byIdempotencyKey[t.IdempotencyKey]; !ok || k.less(keyOf(*t)) {
+				ix.byIdempotencyKey[t.IdempotencyKey] = keyOf(*t)
+			}
+		}
+		ix.setUniqueActive(*t, !t.Status.Finished())
+	}
+}
+
+// setStatus moves t to status in the status and unique indexes.
+func (ix *taskIndex) setStatus(t Task, status TaskStatus) {
+	removeID(ix.byStatus, t.Status, t.ID)
+	addID(ix.byStatus, status, t.ID)
+	ix.setUniqueActive(t, !status.Finished())
+}
+
+// setUniqueActive claims or releases the unique key of t.
+func (ix *taskIndex) setUniqueActive(t Task, active bool) {
+	if t.UniqueKey == "" {
+		return
+	}
+	if active {
+		ix.activeUnique[t.UniqueKey] = t.ID
+	} else if ix.activeUnique[t.UniqueKey] == t.ID {
+		delete(ix.activeUnique, t.UniqueKey)
+	}
+}
+
+func (ix *taskIndex) insertKey(k orderKey) {
+	// new tasks are usually the newest, so this is an append in the common case
+	i := sort.Search(len(ix.order), func(i int) bool { return k.less(ix.order[i]) })
+	ix.order = append(ix.order, orderKey{})
+	copy(ix.order[i+1:], ix.order[i:])
+	ix.order[i] = k
+}
+
+func (ix *taskIndex) removeKey(k orderKey) {
+	i := sort.Search(len(ix.order), func(i int) bool { return !ix.order[i].less(k) })
+	if i < len(ix.order) && !k.less(ix.order[i]) {
+		ix.order = append(ix.order[:i], ix.order[i+1:]...)
+	}
+}
+
+// candidates returns the ids of the smallest index matching f, or nil when f uses no index.
+// The returned set may be shared with the index and must not be modified.
+func (ix *taskIndex) candidates(f TaskFilter) idSet {
+	var best idSet
+	consider := func(s idSet) {
+		if best == nil || len(s) < len(best) {
+			best = s
+		}
+	}
+	if len(f.Statuses) > 0 {
+		union := make(idSet)
+		for _, st := range f.Statuses {
+			for id := range ix.byStatus[st] {
+				union[id] = struct{}{}
+			}
+		}
+		consider(union)
+	}
+	if f.Type != "" {
+		consider(nonNil(ix.byType[f.Type]))
+	}
+	if f.Queue != "" {
+		consider(nonNil(ix.byQueue[f.Queue]))
+	}
+	for k, v := range f.Labels {
+		consider(nonNil(ix.byLabel[labelKey(k, v)]))
+	}
+	return best
+}
+
+func nonNil(s idSet) idSet {
+	if s == nil {
+		return idSet{}
+	}
+	return s
+}
+
+func labelKey(k, v string) string { return k + "=" + v }
+
+func addID[K comparable](m map[K]idSet, key K, id string) {
+	s, ok := m[key]
+	if !ok {
+		s = make(idSet)
+		m[key] = s
+	}
+	s[id] = struct{}{}
+}
+
+func removeID[K comparable](m map[K]idSet, key K, id string) {
+	if s, ok := m[key]; ok {
+		delete(s, id)
+		if len(s) == 0 {
+			delete(m, key)
+		}
+	}
+}
+
+// List returns one page of the tasks matching f. It holds only the read lock: indexed
+// filters narrow the candidates and the creation order index serves the rest.
+func (s *MemoryStore) List(f TaskFilter) (TaskPage, error) {
+	limit := f.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	limit = min(limit, MaxListLimit)
+	var after *orderKey
+	if f.Cursor != "" {
+		k, err := decodeCursor(f.Cursor)
+		if err != nil {
+			return TaskPage{}, fmt.Errorf("%w %q", err, f.Cursor)
+		}
+		after = &k
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := s.index.order
+	if ids := s.index.candidates(f); ids != nil {
+		keys = make([]orderKey, 0, len(ids))
+		for id := range ids {
+			keys = append(keys, keyOf(s.tasks[id]))
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	}
+	start := 0
+	if after != nil {
+		start = sort.Search(len(keys), func(i int) bool { return after.less(keys[i]) })
+	}
+	if !f.CreatedAfter.IsZero() {
+		start = max(start, sort.Search(len(keys), func(i int) bool { return !keys[i].created.Before(f.CreatedAfter) }))
+	}
+	var page TaskPage
+	for _, k := range keys[start:] {
+		if !f.CreatedBefore.IsZero() && !k.created.Before(f.CreatedBefore) {
+			break
+		}
+		t := s.tasks[k.id]
+		if !f.match(t) {
+			continue
+		}
+		if len(page.Tasks) == limit {
+			page.NextCursor = encodeCursor(keyOf(page.Tasks[limit-1]))
+			break
+		}
+		page.Tasks = append(page.Tasks, t)
+	}
+	return page, nil
+}
@@ -0,0 +1,43 @@
+package queue
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// PayloadBase64 marks a binary payload: Task.Payload holds its bytes as a base64 JSON string.
+const PayloadBase64 = "base64"
+
+// ErrInvalidPayload is returned for a payload that cannot be decoded.
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// SetBinaryPayload stores data as the task's payload. The payload stays valid JSON, so it
+// survives persistence and shows up in the task's JSON as base64.
+func (t *Task) SetBinaryPayload(data []byte, contentType string) {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	encoded, _ := json.Marshal(base64.StdEncoding.EncodeToString(data))
+	t.Payload = encoded
+	t.PayloadEncoding = PayloadBase64
+	t.ContentType = contentType
+}
+
+// PayloadBytes returns the payload as the handler should see it: the decoded bytes of a
+// binary payload, the raw JSON otherwise.
+func (t Task) PayloadBytes() ([]byte, error) {
+	if t.PayloadEncoding != PayloadBase64 {
+		return t.Payload, nil
+	}
+	var s string
+	if err := json.Unmarshal(t.Payload, &s); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+	return data, nil
+}
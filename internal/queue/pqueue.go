@@ -0,0 +1,223 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// Pusher accepts tasks from producers (HTTP, scheduler, cron) without blocking.
+type Pusher interface {
+	// TryPush adds t without blocking and reports false when the queue is full or closed.
+	TryPush(t Task) bool
+}
+
+// BatchPusher is a Pusher that can add several tasks at once.
+type BatchPusher interface {
+	Pusher
+	// TryPushAll adds every task of ts, or none of them when any does not fit.
+	TryPushAll(ts []Task) bool
+}
+
+// Queue is the bounded buffer between producers and workers.
+type Queue interface {
+	Pusher
+	// Pop blocks until a task is available. It reports false when ctx is done, or when the
+	// queue is closed and drained.
+	Pop(ctx context.Context) (Task, bool)
+	// Len returns the number of buffered tasks.
+	Len() int
+	// Cap returns the maximum number of buffered tasks.
+	Cap() int
+}
+
+// ChanQueue adapts a buffered channel to Queue. It is strictly FIFO and ignores priorities.
+type ChanQueue chan Task
+
+func (c ChanQueue) TryPush(t Task) bool {
+	select {
+	case c <- t:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c ChanQueue) Pop(ctx context.Context) (Task, bool) {
+	select {
+	case <-ctx.Done():
+		return Task{}, false
+	case t, ok := <-c:
+		return t, ok
+	}
+}
+
+func (c ChanQueue) Len() int { return len(c) }
+func (c ChanQueue) Cap() int { return cap(c) }
+
+// PriorityQueue is a bounded heap-based Queue. Tasks with a higher Priority are popped
+// first and tasks of equal priority in FIFO order.
+//
+// With a positive aging interval a waiting task gains one priority level per interval, so
+// low-priority work is not starved by a steady stream of urgent tasks. Aging is linear and
+// applies to all tasks alike, which keeps heap keys static: ordering by
+// priority + wait/aging is the same as ordering by enqueuedAt - priority*aging.
+type PriorityQueue struct {
+	capacity int
+	aging    time.Duration
+
+	mu     sync.Mutex
+	items  priorityHeap
+	seq    uint64
+	closed bool
+	ready  chan struct{} // signalled when items become available
+	done   chan struct{} // closed by Close
+}
+
+var (
+	_ Queue       = (*PriorityQueue)(nil)
+	_ BatchPusher = (*PriorityQueue)(nil)
+)
+
+// NewPriorityQueue creates a queue holding at most capacity tasks. aging <= 0 disables aging.
+func NewPriorityQueue(capacity int, aging time.Duration) *PriorityQueue {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	if aging < 0 {
+		aging = 0
+	}
+	return &PriorityQueue{
+		capacity: capacity,
+		aging:    aging,
+		ready:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+func (pq *PriorityQueue) TryPush(t Task) bool {
+	pq.mu.Lock()
+	if pq.closed || len(pq.items) >= pq.capacity {
+		pq.mu.Unlock()
+		return false
+	}
+	pq.pushLocked(t)
+	pq.mu.Unlock()
+	pq.signal()
+	return true
+}
+
+func (pq *PriorityQueue) TryPushAll(ts []Task) bool {
+	pq.mu.Lock()
+	if !pq.fitsLocked(len(ts)) {
+		pq.mu.Unlock()
+		return false
+	}
+	for _, t := range ts {
+		pq.pushLocked(t)
+	}
+	pq.mu.Unlock()
+	pq.signal()
+	return true
+}
+
+// fitsLocked reports whether n more tasks fit; pq.mu must be held.
+func (pq *PriorityQueue) fitsLocked(n int) bool {
+	return !pq.closed && len(pq.items)+n <= pq.capacity
+}
+
+// pushLocked adds t to the heap; pq.mu must be held.
+func (pq *PriorityQueue) pushLocked(t Task) {
+	t.enqueuedAt = time.Now()
+	pq.seq++
+	it := priorityItem{task: t, priority: t.Priority, seq: pq.seq}
+	if pq.aging > 0 {
+		it.key = time.Now().UnixNano() - int64(t.Priority)*int64(pq.aging)
+	}
+	heap.Push(&pq.items, it)
+}
+
+func (pq *PriorityQueue) Pop(ctx context.Context) (Task, bool) {
+	for {
+		pq.mu.Lock()
+		if len(pq.items) > 0 {
+			it := heap.Pop(&pq.items).(priorityItem)
+			more := len(pq.items) > 0
+			pq.mu.Unlock()
+			if more {
+				// pass the wake-up on to another waiting worker
+				pq.signal()
+			}
+			return it.task, true
+		}
+		closed := pq.closed
+		pq.mu.Unlock()
+		if closed {
+			return Task{}, false
+		}
+		select {
+		case <-ctx.Done():
+			return Task{}, false
+		case <-pq.ready:
+		case <-pq.done:
+		}
+	}
+}
+
+func (pq *PriorityQueue) Len() int {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	return len(pq.items)
+}
+
+func (pq *PriorityQueue) Cap() int { return pq.capacity }
+
+// Close stops accepting tasks; workers drain what is buffered and then stop.
+func (pq *PriorityQueue) Close() {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+	if !pq.closed {
+		pq.closed = true
+		close(pq.done)
+	}
+}
+
+func (pq *PriorityQueue) signal() {
+	select {
+	case pq.ready <- struct{}{}:
+	default:
+	}
+}
+
+type priorityItem struct {
+	task     Task
+	priority int
+	key      int64 // aging order key; zero when aging is disabled
+	seq      uint64
+}
+
+// priorityHeap orders by aging key when aging is enabled (keys are then non-zero),
+// otherwise by priority descending; ties are broken by insertion order.
+type priorityHeap []priorityItem
+
+func (h priorityHeap) Len() int { return len(h) }
+func (h priorityHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if a.key != b.key {
+		return a.key < b.key
+	}
+	if a.priority != b.priority {
+		return a.priority > b.priority
+	}
+	return a.seq < b.seq
+}
+func (h priorityHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *priorityHeap) Push(x any)   { *h = append(*h, x.(priorityItem)) }
+func (h *priorityHeap) Pop() any {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	*h = old[:n-1]
+	return it
+}
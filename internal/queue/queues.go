@@ -0,0 +1,375 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultQueueName is the queue used for tasks that do not name one.
+const DefaultQueueName = "default"
+
+// QueueSpec declares a named queue with its own capacity, worker pool and retry policy.
+type QueueSpec struct {
+	Name     string
+	Capacity int
+	Workers  int
+	// MaxRetries caps the retries of tasks in this queue; negative means no cap.
+	MaxRetries int
+	// BackoffBase overrides the base retry delay of this queue; zero uses BackoffBase.
+	BackoffBase time.Duration
+}
+
+// QueueStats holds the counters of one named queue. A nil *QueueStats ignores updates.
+type QueueStats struct {
+	enqueued atomic.Uint64
+	running  atomic.Int64
+	done     atomic.Uint64
+	failed   atomic.Uint64
+	canceled atomic.Uint64
+	skipped  atomic.Uint64
+	retried  atomic.Uint64
+	timeouts atomic.Uint64
+	panics   atomic.Uint64
+	// abandoned counts handlers still running after their attempt timed out.
+	abandoned atomic.Int64
+
+	// attempts counts every attempt run, exhausted the tasks failed for lack of retries.
+	attempts  atomic.Uint64
+	exhausted atomic.Uint64
+
+	// byType maps a task type to its *latencyHistograms.
+	byType sync.Map
+}
+
+// addEnqueued counts t as accepted unless it is a retry: those are pushed again with a later
+// attempt and show up in retried instead.
+func (s *QueueStats) addEnqueued(t Task) {
+	if s != nil && t.Attempt == 0 {
+		s.enqueued.Add(1)
+	}
+}
+
+func (s *QueueStats) addRunning(delta int64) {
+	if s != nil {
+		s.running.Add(delta)
+	}
+}
+
+func (s *QueueStats) addDone() {
+	if s != nil {
+		s.done.Add(1)
+	}
+}
+
+func (s *QueueStats) addFailed() {
+	if s != nil {
+		s.failed.Add(1)
+	}
+}
+
+func (s *QueueStats) addCanceled() {
+	if s != nil {
+		s.canceled.Add(1)
+	}
+}
+
+func (s *QueueStats) addSkipped() {
+	if s != nil {
+		s.skipped.Add(1)
+	}
+}
+
+func (s *QueueStats) addRetried() {
+	if s != nil {
+		s.retried.Add(1)
+	}
+}
+
+func (s *QueueStats) addAttempt() {
+	if s != nil {
+		s.attempts.Add(1)
+	}
+}
+
+func (s *QueueStats) addExhausted() {
+	if s != nil {
+		s.exhausted.Add(1)
+	}
+}
+
+// latency returns the histograms of taskType, creating them on first use.
+func (s *QueueStats) latency(taskType string) *latencyHistograms {
+	if h, ok := s.byType.Load(taskType); ok {
+		return h.(*latencyHistograms)
+	}
+	h, _ := s.byType.LoadOrStore(taskType, new(latencyHistograms))
+	return h.(*latencyHistograms)
+}
+
+// observeWait records how long a task of taskType waited in the queue.
+func (s *QueueStats) observeWait(taskType string, d time.Duration) {
+	if s != nil {
+		s.latency(taskType).wait.Observe(d)
+	}
+}
+
+// observeRun records how long an attempt of a task of taskType ran.
+func (s *QueueStats) observeRun(taskType string, d time.Duration) {
+	if s != nil {
+		s.latency(taskType).run.Observe(d)
+	}
+}
+
+// observeTotal records the end-to-end latency of a task of taskType that reached its outcome.
+func (s *QueueStats) observeTotal(taskType string, d time.Duration) {
+	if s != nil {
+		s.latency(taskType).total.Observe(d)
+	}
+}
+
+// latencySnapshot returns the latency of every task type and their sum.
+func (s *QueueStats) latencySnapshot() (Latency, map[string]Latency) {
+	var total Latency
+	types := make(map[string]Latency)
+	s.byType.Range(func(k, v any) bool {
+		l := v.(*latencyHistograms).snapshot()
+		types[k.(string)] = l
+		total.Merge(l)
+		return true
+	})
+	return total, types
+}
+
+func (s *QueueStats) addTimeout() {
+	if s != nil {
+		s.timeouts.Add(1)
+	}
+}
+
+func (s *QueueStats) addAbandoned(delta int64) {
+	if s != nil {
+		s.abandoned.Add(delta)
+	}
+}
+
+func (s *QueueStats) addPanic() {
+	if s != nil {
+		s.panics.Add(1)
+	}
+}
+
+// QueueMetrics is a snapshot of one named queue.
+type QueueMetrics struct {
+	Depth    int
+	Capacity int
+	Workers  int
+	Enqueued uint64
+	Running  uint64
+	Done     uint64
+	Failed   uint64
+	Canceled uint64
+	Skipped  uint64
+	// Attempts counts attempts run; Retried the failed ones followed by a retry; Exhausted
+	// the tasks failed because no retries were left, as opposed to permanent errors.
+	Attempts  uint64
+	Retried   uint64
+	Exhausted uint64
+	// Timeouts counts attempts that exceeded their deadline.
+	Timeouts uint64
+	// Panics counts attempts whose handler panicked.
+	Panics uint64
+	// Abandoned counts handlers that ignored the deadline of their attempt and still run.
+	Abandoned uint64
+	// Latency sums the histograms of Types, which are keyed by task type. The JSON form of
+	// /metrics reports their percentiles separately.
+	Latency Latency            `json:"-"`
+	Types   map[string]Latency `json:"-"`
+}
+
+type namedQueue struct {
+	spec  QueueSpec
+	queue *PriorityQueue
+	stats QueueStats
+}
+
+// QueueSet is a fixed set of named priority queues. As a Pusher it routes each task to the
+// queue named by Task.Queue, or to the default queue when the name is empty.
+type QueueSet struct {
+	queues map[string]*namedQueue
+	names  []string
+	def    string
+}
+
+var _ BatchPusher = (*QueueSet)(nil)
+
+// NewQueueSet creates one PriorityQueue per spec. The queue named DefaultQueueName is the
+// default one; without it the first spec is. Specs with an empty or repeated name are ignored.
+func NewQueueSet(specs []QueueSpec, aging time.Duration) *QueueSet {
+	s := &QueueSet{queues: make(map[string]*namedQueue)}
+	for _, spec := range specs {
+		if spec.Name == "" {
+			continue
+		}
+		if _, dup := s.queues[spec.Name]; dup {
+			continue
+		}
+		s.queues[spec.Name] = &namedQueue{spec: spec, queue: NewPriorityQueue(spec.Capacity, aging)}
+		s.names = append(s.names, spec.Name)
+	}
+	if len(s.names) == 0 {
+		s.queues[DefaultQueueName] = &namedQueue{
+			spec:  QueueSpec{Name: DefaultQueueName, Capacity: 1, Workers: 1, MaxRetries: -1},
+			queue: NewPriorityQueue(1, aging),
+		}
+		s.names = append(s.names, DefaultQueueName)
+	}
+	s.def = s.names[0]
+	if _, ok := s.queues[DefaultQueueName]; ok {
+		s.def = DefaultQueueName
+	}
+	return s
+}
+
+// Names returns queue names in declaration order.
+func (s *QueueSet) Names() []string {
+	return append([]string(nil), s.names...)
+}
+
+// DefaultName returns the name of the queue used for tasks without one.
+func (s *QueueSet) DefaultName() string {
+	return s.def
+}
+
+// Resolve maps a requested queue name to a declared one; empty selects the default queue.
+func (s *QueueSet) Resolve(name string) (string, bool) {
+	if name == "" {
+		return s.def, true
+	}
+	_, ok := s.queues[name]
+	return name, ok
+}
+
+// Queue returns the underlying queue by name.
+func (s *QueueSet) Queue(name string) (Queue, bool) {
+	nq, ok := s.queues[name]
+	if !ok {
+		return nil, false
+	}
+	return nq.queue, true
+}
+
+// TryPush routes t to its queue; it reports false for unknown queues and full queues.
+func (s *QueueSet) TryPush(t Task) bool {
+	name, ok := s.Resolve(t.Queue)
+	if !ok {
+		return false
+	}
+	nq := s.queues[name]
+	if !nq.queue.TryPush(t) {
+		return false
+	}
+	nq.stats.addEnqueued(t)
+	return true
+}
+
+// TryPushAll routes every task of ts to its queue, or none of them when a queue is unknown or
+// lacks room for its share. The queues involved are locked together in declaration order.
+func (s *QueueSet) TryPushAll(ts []Task) bool {
+	byQueue := make(map[string][]Task)
+	for _, t := range ts {
+		name, ok := s.Resolve(t.Queue)
+		if !ok {
+			return false
+		}
+		byQueue[name] = append(byQueue[name], t)
+	}
+	var locked []*namedQueue
+	unlock := func() {
+		for _, nq := range locked {
+			nq.queue.mu.Unlock()
+		}
+	}
+	for _, name := range s.names {
+		batch, ok := byQueue[name]
+		if !ok {
+			continue
+		}
+		nq := s.queues[name]
+		nq.queue.mu.Lock()
+		locked = append(locked, nq)
+		if !nq.queue.fitsLocked(len(batch)) {
+			unlock()
+			return false
+		}
+	}
+	for _, nq := range locked {
+		for _, t := range byQueue[nq.spec.Name] {
+			nq.queue.pushLocked(t)
+			nq.stats.addEnqueued(t)
+		}
+	}
+	unlock()
+	for _, nq := range locked {
+		nq.queue.signal()
+	}
+	return true
+}
+
+// Start launches the worker pool of every queue. Pools share base (registry, seed, dead
+// letters) and get the queue's own size and retry policy.
+func (s *QueueSet) Start(ctx context.Context, wg *sync.WaitGroup, store Store, base WorkerConfig) {
+	for i, name := range s.names {
+		nq := s.queues[name]
+		cfg := base
+		cfg.Name = name
+		cfg.Workers = nq.spec.Workers
+		cfg.Seed = base.Seed + int64(i)*1000
+		cfg.BackoffBase = nq.spec.BackoffBase
+		cfg.Stats = &nq.stats
+		cfg.MaxRetries = nil
+		if nq.spec.MaxRetries >= 0 {
+			maxRetries := nq.spec.MaxRetries
+			cfg.MaxRetries = &maxRetries
+		}
+		StartWorkerPool(ctx, wg, store, nq.queue, cfg)
+	}
+}
+
+// Close closes every queue so workers drain them and stop.
+func (s *QueueSet) Close() {
+	for _, nq := range s.queues {
+		nq.queue.Close()
+	}
+}
+
+// Metrics returns per-queue counters keyed by queue name.
+func (s *QueueSet) Metrics() map[string]QueueMetrics {
+	out := make(map[string]QueueMetrics, len(s.queues))
+	for name, nq := range s.queues {
+		running := max(nq.stats.running.Load(), 0)
+		abandoned := max(nq.stats.abandoned.Load(), 0)
+		latency, types := nq.stats.latencySnapshot()
+		out[name] = QueueMetrics{
+			Depth:     nq.queue.Len(),
+			Capacity:  nq.queue.Cap(),
+			Workers:   nq.spec.Workers,
+			Enqueued:  nq.stats.enqueued.Load(),
+			Running:   uint64(running),
+			Done:      nq.stats.done.Load(),
+			Failed:    nq.stats.failed.Load(),
+			Canceled:  nq.stats.canceled.Load(),
+			Skipped:   nq.stats.skipped.Load(),
+			Attempts:  nq.stats.attempts.Load(),
+			Retried:   nq.stats.retried.Load(),
+			Exhausted: nq.stats.exhausted.Load(),
+			Timeouts:  nq.stats.timeouts.Load(),
+			Panics:    nq.stats.panics.Load(),
+			Abandoned: uint64(abandoned),
+			Latency:   latency,
+			Types:     types,
+		}
+	}
+	return out
+}
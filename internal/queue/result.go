@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultMaxResultSize is the largest result kept when no limit is configured.
+const DefaultMaxResultSize = 1 << 20
+
+// ErrResultTooLarge is returned when a handler produces a result over the size limit.
+// Such a task fails without retries: the same input would produce the same output.
+var ErrResultTooLarge = errors.New("result too large")
+
+// TaskResult is the stored output of a done task. Data is served by GET /result/{id}
+// and left out of the task's JSON.
+type TaskResult struct {
+	ContentType string    `json:"contentType"`
+	Size        int       `json:"size"`
+	Data        []byte    `json:"-"`
+	StoredAt    time.Time `json:"storedAt"`
+	// Evicted is set once the retention TTL has dropped Data.
+	Evicted bool `json:"evicted,omitempty"`
+}
+
+// newTaskResult converts handler output for storage. Without an explicit content type,
+// valid JSON is served as application/json and anything else as an octet stream.
+func newTaskResult(r Result, now time.Time) TaskResult {
+	ct := r.ContentType
+	if ct == "" {
+		ct = "application/octet-stream"
+		if json.Valid(r.Data) {
+			ct = "application/json"
+		}
+	}
+	return TaskResult{ContentType: ct, Size: len(r.Data), Data: r.Data, StoredAt: now}
+}
+
+// StartResultJanitor evicts results stored more than ttl ago, checking every interval,
+// until ctx is done. ttl <= 0 keeps results forever and starts nothing.
+func StartResultJanitor(ctx context.Context, wg *sync.WaitGroup, store Store, ttl, interval time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				store.EvictResults(now.Add(-ttl))
+			}
+		}
+	}()
+}
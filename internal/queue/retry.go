@@ -0,0 +1,136 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryStrategy selects how the delay before a retry grows with the attempt number.
+type RetryStrategy string
+
+const (
+	// RetryFixed waits Base before every retry.
+	RetryFixed RetryStrategy = "fixed"
+	// RetryLinear waits Base * attempt.
+	RetryLinear RetryStrategy = "linear"
+	// RetryExponential waits Base * 2^attempt.
+	RetryExponential RetryStrategy = "exponential"
+	// RetryFullJitter waits a random delay in [0, Base * 2^attempt].
+	RetryFullJitter RetryStrategy = "full_jitter"
+	// RetryDecorrelatedJitter waits a random delay in [Base, 3 * previous delay].
+	RetryDecorrelatedJitter RetryStrategy = "decorrelated_jitter"
+)
+
+// RetryStrategies lists the supported strategies.
+var RetryStrategies = []RetryStrategy{RetryFixed, RetryLinear, RetryExponential, RetryFullJitter, RetryDecorrelatedJitter}
+
+// ErrInvalidRetryPolicy is returned by RetryPolicy.Validate.
+var ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+
+// RetryPolicy computes the delay before each retry of a task.
+type RetryPolicy struct {
+	Strategy RetryStrategy `json:"strategy"`
+	// Base is the initial delay.
+	Base time.Duration `json:"base,omitempty"`
+	// MaxDelay caps every delay; zero means no cap.
+	MaxDelay time.Duration `json:"maxDelay,omitempty"`
+	// Jitter adds a random delay in [0, Jitter] to fixed, linear and exponential delays.
+	Jitter time.Duration `json:"jitter,omitempty"`
+}
+
+// DefaultRetryPolicy is exponential from BackoffBase with up to JitterMax of jitter, as BackoffDelay.
+var DefaultRetryPolicy = RetryPolicy{Strategy: RetryExponential, Base: BackoffBase, Jitter: JitterMax}
+
+// Validate reports whether p can be used to compute delays.
+func (p RetryPolicy) Validate() error {
+	known := false
+	for _, s := range RetryStrategies {
+		known = known || p.Strategy == s
+	}
+	switch {
+	case !known:
+		return fmt.Errorf("%w: unknown strategy %q", ErrInvalidRetryPolicy, p.Strategy)
+	case p.Base <= 0:
+		return fmt.Errorf("%w: base must be positive", ErrInvalidRetryPolicy)
+	case p.MaxDelay < 0 || p.Jitter < 0:
+		return fmt.Errorf("%w: max delay and jitter must not be negative", ErrInvalidRetryPolicy)
+	case p.MaxDelay > 0 && p.MaxDelay < p.Base:
+		return fmt.Errorf("%w: max delay %s is below base %s", ErrInvalidRetryPolicy, p.MaxDelay, p.Base)
+	}
+	return nil
+}
+
+// Delay returns the delay before retry number attempt (1 for the first retry). prev is the
+// delay before the previous retry and is only used by decorrelated jitter. With a nil rng every
+// random choice takes its upper bound.
+func (p RetryPolicy) Delay(attempt int, prev time.Duration, rng *rand.Rand) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	base := p.Base
+	if base <= 0 {
+		base = BackoffBase
+	}
+	var d time.Duration
+	switch p.Strategy {
+	case RetryFixed:
+		d = addDuration(base, random(rng, p.Jitter))
+	case RetryLinear:
+		d = addDuration(mulDuration(base, int64(attempt)), random(rng, p.Jitter))
+	case RetryFullJitter:
+		d = random(rng, p.limit(exponential(base, attempt)))
+	case RetryDecorrelatedJitter:
+		upper := p.limit(mulDuration(max(prev, base), 3))
+		d = base + random(rng, max(upper-base, 0))
+	default:
+		d = addDuration(exponential(base, attempt), random(rng, p.Jitter))
+	}
+	return p.limit(d)
+}
+
+// limit caps d at MaxDelay when set.
+func (p RetryPolicy) limit(d time.Duration) time.Duration {
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return d
+}
+
+// exponential returns base * 2^attempt, saturating instead of overflowing.
+func exponential(base time.Duration, attempt int) time.Duration {
+	if attempt > 62 {
+		return math.MaxInt64
+	}
+	return mulDuration(base, 1<<attempt)
+}
+
+func mulDuration(d time.Duration, n int64) time.Duration {
+	if d > 0 && n > 0 && int64(d) > math.MaxInt64/n {
+		return math.MaxInt64
+	}
+	return d * time.Duration(n)
+}
+
+func addDuration(a, b time.Duration) time.Duration {
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// random returns a uniform duration in [0, upper], or upper without an rng.
+func random(rng *rand.Rand, upper time.Duration) time.Duration {
+	if upper <= 0 {
+		return 0
+	}
+	if rng == nil {
+		return upper
+	}
+	if upper == math.MaxInt64 {
+		return time.Duration(rng.Int63())
+	}
+	return time.Duration(rng.Int63n(int64(upper) + 1))
+}
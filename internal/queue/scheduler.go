@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// Backoff of a due task whose queue is full: it doubles from releaseRetryBase on every
+// failed push, up to releaseRetryMax.
+const (
+	releaseRetryBase = 10 * time.Millisecond
+	releaseRetryMax  = time.Second
+)
+
+// Scheduler holds tasks that must not run before a given time (delayed tasks and retries
+// waiting out their backoff) and releases them into the worker queue when they become due.
+// Pending tasks are kept in a min-heap ordered by due time (FIFO for equal times); a single
+// goroutine sleeps until the earliest one is due. A due task that finds its queue full is put
+// back and tried again after a short backoff, so one full queue never stalls the others.
+//
+// The scheduler itself is not durable: tasks it holds stay in the Store with status
+// scheduled or retrying (queued once due), so after a restart they are recovered from the
+// Store and scheduled again.
+type Scheduler struct {
+	store Store
+	queue Pusher
+
+	mu    sync.Mutex
+	items timerHeap
+	seq   uint64
+	wake  chan struct{}
+}
+
+func NewScheduler(store Store, queue Pusher) *Scheduler {
+	return &Scheduler{store: store, queue: queue, wake: make(chan struct{}, 1)}
+}
+
+// Schedule registers t to be released at t.NextAttemptAt for a retry, else at t.RunAt
+// (immediately when unset or past).
+func (s *Scheduler) Schedule(t Task) {
+	at := time.Now()
+	switch {
+	case t.NextAttemptAt != nil:
+		at = *t.NextAttemptAt
+	case t.RunAt != nil:
+		at = *t.RunAt
+	}
+	s.push(timerItem{at: at, task: t})
+	// nudge the loop so it re-evaluates the earliest due time
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// push adds it to the heap behind the items due at the same time.
+func (s *Scheduler) push(it timerItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	it.seq = s.seq
+	heap.Push(&s.items, it)
+}
+
+// Len returns the number of tasks waiting to be released.
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+// Start runs the release loop until ctx is done.
+func (s *Scheduler) Start(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		timer := time.NewTimer(time.Hour)
+		defer timer.Stop()
+		for {
+			for _, it := range s.popDue(time.Now()) {
+				s.release(it)
+			}
+			next := s.untilNext(time.Now())
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			if next > 0 {
+				timer.Reset(next)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// popDue removes all items due at now.
+func (s *Scheduler) popDue(now time.Time) []timerItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []timerItem
+	for len(s.items) > 0 && !s.items[0].at.After(now) {
+		due = append(due, heap.Pop(&s.items).(timerItem))
+	}
+	return due
+}
+
+// untilNext returns the delay until the earliest pending item, or zero when none is left.
+func (s *Scheduler) untilNext(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.items) == 0 {
+		return 0
+	}
+	return max(s.items[0].at.Sub(now), time.Nanosecond)
+}
+
+// release marks the task of it queued and hands it to the workers without waiting: when its
+// queue is full it is put back with a backoff and stays queued in the Store, so that recovery
+// picks it up after a restart. A task canceled while scheduled, retrying or waiting for
+// capacity is dropped.
+func (s *Scheduler) release(it timerItem) {
+	t := it.task
+	if it.fullPushes == 0 {
+		t.Status, t.NextAttemptAt = StatusQueued, nil
+		if cur, ok := s.store.UpdateStatus(t.ID, StatusQueued, t.Attempt); !ok && cur.Status == StatusCanceled {
+			return
+		}
+	} else if cur, ok := s.store.Get(t.ID); !ok || cur.Status == StatusCanceled {
+		return
+	}
+	if s.queue.TryPush(t) {
+		return
+	}
+	delay := min(BackoffDelay(releaseRetryBase, it.fullPushes, 0, nil), releaseRetryMax)
+	s.push(timerItem{at: time.Now().Add(delay), task: t, fullPushes: it.fullPushes + 1})
+}
+
+type timerItem struct {
+	at   time.Time
+	seq  uint64
+	task Task
+	// fullPushes counts the attempts to release task that found its queue full.
+	fullPushes int
+}
+
+// timerHeap implements heap.Interface ordered by due time, then insertion order.
+type timerHeap []timerItem
+
+func (h timerHeap) Len() int { return len(h) }
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h timerHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *timerHeap) Push(x any)   { *h = append(*h, x.(timerItem)) }
+func (h *timerHeap) Pop() any {
+	old := *h
+	n := len(old)
+	it := old[n-1]
+	*h = old[:n-1]
+	return it
+}
@@ -5,24 +5,69 @@ import (
 	"time"
 )
 
-// Store is an in-memory storage for tasks guarded by RWMutex.
-type Store struct {
+// Store persists tasks and tracks per-status metrics.
+type Store interface {
+	// Save creates or updates a task and refreshes UpdatedAt.
+	Save(t Task) Task
+	// Get returns a task by id.
+	Get(id string) (Task, bool)
+	// Delete removes a task and reports whether it existed. It rolls back a task saved just
+	// before its queue turned out to be full.
+	Delete(id string) bool
+	// UpdateStatus sets status and attempt for a task if it exists. A canceled task is never
+	// updated: it is returned as-is with false.
+	UpdateStatus(id string, status TaskStatus, attempt int) (Task, bool)
+	// Cancel marks a queued, scheduled or retrying task canceled. A task in any other status is
+	// returned as-is with false, so a task that has just started running is never canceled here.
+	Cancel(id string) (Task, bool)
+	// ScheduleRetry marks a task retrying with the number of its next attempt, due after delay.
+	// Like UpdateStatus it never updates a canceled task.
+	ScheduleRetry(id string, attempt int, delay time.Duration) (Task, bool)
+	// RecordAttempt appends rec to the task's attempt history, keeping at most limit
+	// records (DefaultAttemptHistory when limit <= 0), and remembers its error as LastError.
+	RecordAttempt(id string, rec AttemptRecord, limit int) (Task, bool)
+	// SetResult stores the output of a task.
+	SetResult(id string, r TaskResult) (Task, bool)
+	// EvictResults drops the data of results stored before cutoff and returns how many were evicted.
+	EvictResults(cutoff time.Time) int
+	// GetMetrics returns a snapshot of the per-status counters.
+	GetMetrics() Metrics
+	// List returns one page of the tasks matching f, ordered by creation time and id.
+	List(f TaskFilter) (TaskPage, error)
+	// FindByIdempotencyKey returns the newest task enqueued with key if it was created at or
+	// after since.
+	FindByIdempotencyKey(key string, since time.Time) (Task, bool)
+	// FindActiveUnique returns the unfinished task holding the unique key.
+	FindActiveUnique(key string) (Task, bool)
+}
+
+// MemoryStore is an in-memory storage for tasks guarded by RWMutex.
+type MemoryStore struct {
 	mu      sync.RWMutex
 	tasks   map[string]Task
+	index   taskIndex
 	metrics Metrics
 }
 
-func NewStore() *Store {
-	return &Store{tasks: make(map[string]Task)}
+func NewStore() *MemoryStore {
+	return &MemoryStore{tasks: make(map[string]Task), index: newTaskIndex()}
 }
 
 // Save creates or updates a task in storage and refreshes UpdatedAt.
-func (s *Store) Save(t Task) Task {
+func (s *MemoryStore) Save(t Task) Task {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, exists := s.tasks[t.ID]; !exists {
-		// new task entering as queued
-		s.incrementMetric(StatusQueued, 1)
+	old, exists := s.tasks[t.ID]
+	if !exists {
+		// new task entering with its initial status (queued or scheduled)
+		s.incrementMetric(t.Status, 1)
+		s.index.update(nil, &t)
+	} else {
+		if old.Status != t.Status {
+			s.incrementMetric(old.Status, -1)
+			s.incrementMetric(t.Status, 1)
+		}
+		s.index.update(&old, &t)
 	}
 	t.UpdatedAt = time.Now().UTC()
 	s.tasks[t.ID] = t
@@ -30,56 +75,238 @@ func (s *Store) Save(t Task) Task {
 }
 
 // Get returns a task by id.
-func (s *Store) Get(id string) (Task, bool) {
+func (s *MemoryStore) Get(id string) (Task, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	t, ok := s.tasks[id]
 	return t, ok
 }
 
-// UpdateStatus sets status and attempt for a task if exists.
-func (s *Store) UpdateStatus(id string, status TaskStatus, attempt int) (Task, bool) {
+// UpdateStatus sets status and attempt for a task if exists and is not canceled.
+func (s *MemoryStore) UpdateStatus(id string, status TaskStatus, attempt int) (Task, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	t, ok := s.tasks[id]
 	if !ok {
 		return Task{}, false
 	}
+	if t.Status == StatusCanceled {
+		return t, false
+	}
 	if t.Status != status {
 		s.incrementMetric(t.Status, -1)
 		s.incrementMetric(status, 1)
+		s.reindexStatus(t, status)
 	}
 	t.Status = status
 	t.Attempt = attempt
+	t.NextAttemptAt = nil
 	t.UpdatedAt = time.Now().UTC()
 	s.tasks[id] = t
 	return t, true
 }
 
+// Cancel marks a task canceled if it exists and is still waiting to run.
+func (s *MemoryStore) Cancel(id string) (Task, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	switch t.Status {
+	case StatusQueued, StatusScheduled, StatusRetrying:
+	default:
+		return t, false
+	}
+	s.incrementMetric(t.Status, -1)
+	s.incrementMetric(StatusCanceled, 1)
+	s.reindexStatus(t, StatusCanceled)
+	t.Status = StatusCanceled
+	t.NextAttemptAt = nil
+	t.UpdatedAt = time.Now().UTC()
+	s.tasks[id] = t
+	return t, true
+}
+
+// ScheduleRetry marks a task retrying for delay if it exists and is not canceled.
+func (s *MemoryStore) ScheduleRetry(id string, attempt int, delay time.Duration) (Task, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	if t.Status == StatusCanceled {
+		return t, false
+	}
+	if t.Status != StatusRetrying {
+		s.incrementMetric(t.Status, -1)
+		s.incrementMetric(StatusRetrying, 1)
+		s.reindexStatus(t, StatusRetrying)
+	}
+	now := time.Now().UTC()
+	at := now.Add(delay)
+	t.Status = StatusRetrying
+	t.Attempt = attempt
+	t.NextAttemptAt = &at
+	t.RetryDelay = delay
+	t.UpdatedAt = now
+	s.tasks[id] = t
+	return t, true
+}
+
+// RecordAttempt appends rec to the attempt history of a task if it exists.
+func (s *MemoryStore) RecordAttempt(id string, rec AttemptRecord, limit int) (Task, bool) {
+	if limit <= 0 {
+		limit = DefaultAttemptHistory
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	// copy so that tasks handed out earlier never see the history change under them
+	attempts := make([]AttemptRecord, 0, min(len(t.Attempts)+1, limit))
+	if drop := len(t.Attempts) + 1 - limit; drop > 0 {
+		attempts = append(attempts, t.Attempts[drop:]...)
+	} else {
+		attempts = append(attempts, t.Attempts...)
+	}
+	t.Attempts = append(attempts, rec)
+	if rec.Error != "" {
+		t.LastError, t.LastErrorKind = rec.Error, rec.ErrorKind
+	}
+	t.UpdatedAt = time.Now().UTC()
+	s.tasks[id] = t
+	return t, true
+}
+
+// SetResult stores the output of a task if it exists.
+func (s *MemoryStore) SetResult(id string, r TaskResult) (Task, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	t.Result = &r
+	t.UpdatedAt = time.Now().UTC()
+	s.tasks[id] = t
+	return t, true
+}
+
+// EvictResults drops the data of results stored before cutoff; their metadata stays.
+func (s *MemoryStore) EvictResults(cutoff time.Time) int {
+	return len(s.evictResults(cutoff))
+}
+
+// evictResults evicts like EvictResults and returns the updated tasks.
+func (s *MemoryStore) evictResults(cutoff time.Time) []Task {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var evicted []Task
+	now := time.Now().UTC()
+	for id, t := range s.tasks {
+		if t.Result == nil || t.Result.Evicted || !t.Result.StoredAt.Before(cutoff) {
+			continue
+		}
+		r := *t.Result
+		r.Data, r.Evicted = nil, true
+		t.Result = &r
+		t.UpdatedAt = now
+		s.tasks[id] = t
+		evicted = append(evicted, t)
+	}
+	return evicted
+}
+
+// restore puts t as-is, keeping its timestamps, and moves the metrics from the
+// previous status (if any) to the restored one. Used when replaying persisted state.
+func (s *MemoryStore) restore(t Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, exists := s.tasks[t.ID]; exists {
+		s.incrementMetric(old.Status, -1)
+		s.index.update(&old, &t)
+	} else {
+		s.index.update(nil, &t)
+	}
+	s.incrementMetric(t.Status, 1)
+	s.tasks[t.ID] = t
+}
+
+// Delete removes a task if it exists.
+func (s *MemoryStore) Delete(id string) bool {
+	return s.remove(id)
+}
+
+// remove deletes a task and releases its status from the metrics.
+func (s *MemoryStore) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, exists := s.tasks[id]
+	if exists {
+		s.incrementMetric(old.Status, -1)
+		s.index.update(&old, nil)
+		delete(s.tasks, id)
+	}
+	return exists
+}
+
+// snapshot returns a copy of all stored tasks.
+func (s *MemoryStore) snapshot() []Task {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Task, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		out = append(out, t)
+	}
+	return out
+}
+
 // Metrics holds counters per status.
 type Metrics struct {
-	Queued  uint64
-	Running uint64
-	Done    uint64
-	Failed  uint64
+	Scheduled uint64
+	Queued    uint64
+	Running   uint64
+	Retrying  uint64
+	Done      uint64
+	Failed    uint64
+	Canceled  uint64
+	Skipped   uint64
 }
 
 // GetMetrics returns a copy of current metrics snapshot.
-func (s *Store) GetMetrics() Metrics {
+func (s *MemoryStore) GetMetrics() Metrics {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return s.metrics
 }
 
-func (s *Store) incrementMetric(status TaskStatus, delta int) {
+// reindexStatus moves t to status in the indexes. Must be called with s.mu held.
+func (s *MemoryStore) reindexStatus(t Task, status TaskStatus) {
+	s.index.setStatus(t, status)
+}
+
+func (s *MemoryStore) incrementMetric(status TaskStatus, delta int) {
 	switch status {
+	case StatusScheduled:
+		s.metrics.Scheduled = uint64(int64(s.metrics.Scheduled) + int64(delta))
 	case StatusQueued:
 		s.metrics.Queued = uint64(int64(s.metrics.Queued) + int64(delta))
 	case StatusRunning:
 		s.metrics.Running = uint64(int64(s.metrics.Running) + int64(delta))
+	case StatusRetrying:
+		s.metrics.Retrying = uint64(int64(s.metrics.Retrying) + int64(delta))
 	case StatusDone:
 		s.metrics.Done = uint64(int64(s.metrics.Done) + int64(delta))
 	case StatusFailed:
 		s.metrics.Failed = uint64(int64(s.metrics.Failed) + int64(delta))
+	case StatusCanceled:
+		s.metrics.Canceled = uint64(int64(s.metrics.Canceled) + int64(delta))
+	case StatusSkipped:
+		s.metrics.Skipped = uint64(int64(s.metrics.Skipped) + int64(delta))
 	}
 }
@@ -5,25 +5,108 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"time"
+
+	"github.com/optongroup/kaspersky-safeboard-go-container-security/internal/tracing"
 )
 
 type TaskStatus string
 
 const (
-	StatusQueued  TaskStatus = "queued"
-	StatusRunning TaskStatus = "running"
-	StatusDone    TaskStatus = "done"
-	StatusFailed  TaskStatus = "failed"
+	StatusScheduled TaskStatus = "scheduled"
+	StatusQueued    TaskStatus = "queued"
+	StatusRunning   TaskStatus = "running"
+	StatusRetrying  TaskStatus = "retrying"
+	StatusDone      TaskStatus = "done"
+	StatusFailed    TaskStatus = "failed"
+	StatusCanceled  TaskStatus = "canceled"
+	StatusSkipped   TaskStatus = "skipped"
+)
+
+// Statuses lists every task status.
+var Statuses = []TaskStatus{StatusScheduled, StatusQueued, StatusRunning, StatusRetrying, StatusDone, StatusFailed, StatusCanceled, StatusSkipped}
+
+// Finished reports whether s is a final status.
+func (s TaskStatus) Finished() bool {
+	return s == StatusDone || s == StatusFailed || s == StatusCanceled || s == StatusSkipped
+}
+
+// Priority bounds accepted on enqueue; higher values are dequeued first.
+const (
+	MinPriority = -1000
+	MaxPriority = 1000
 )
 
 type Task struct {
-	ID         string          `json:"id"`
-	Payload    json.RawMessage `json:"payload"`
-	MaxRetries int             `json:"maxRetries"`
-	Attempt    int             `json:"attempt"`
-	Status     TaskStatus      `json:"status"`
-	CreatedAt  time.Time       `json:"createdAt"`
-	UpdatedAt  time.Time       `json:"updatedAt"`
+	ID      string          `json:"id"`
+	Type    string          `json:"type,omitempty"`
+	Queue   string          `json:"queue,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+	// PayloadEncoding is PayloadBase64 for a binary payload; see PayloadBytes.
+	PayloadEncoding string `json:"payloadEncoding,omitempty"`
+	// ContentType describes a binary payload.
+	ContentType string `json:"contentType,omitempty"`
+	MaxRetries  int    `json:"maxRetries"`
+	Priority    int    `json:"priority,omitempty"`
+	// IdempotencyKey is the client key the task was enqueued with; repeating an enqueue with
+	// the same key returns this task instead of a new one.
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// UniqueKey is set for unique tasks: no other task with the same key is accepted while
+	// this one is unfinished. See UniqueKey.
+	UniqueKey string `json:"uniqueKey,omitempty"`
+	// TraceParent and TraceState are the W3C trace context the task's spans descend from.
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
+	// Labels are free-form key/value pairs for filtering in listings.
+	Labels  map[string]string `json:"labels,omitempty"`
+	Timeout time.Duration     `json:"timeout,omitempty"`
+	Attempt int               `json:"attempt"`
+	Status  TaskStatus        `json:"status"`
+	RunAt   *time.Time        `json:"runAt,omitempty"`
+	// NextAttemptAt is when a retrying task is due for its next attempt.
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	// RetryDelay is the backoff waited before the current attempt.
+	RetryDelay time.Duration `json:"retryDelay,omitempty"`
+	// Retry overrides the retry policy of the task's type and queue.
+	Retry     *RetryPolicy    `json:"retry,omitempty"`
+	Attempts  []AttemptRecord `json:"attempts,omitempty"`
+	LastError string          `json:"lastError,omitempty"`
+	// LastErrorKind is the kind of LastError.
+	LastErrorKind ErrorKind   `json:"lastErrorKind,omitempty"`
+	Result        *TaskResult `json:"result,omitempty"`
+	CreatedAt     time.Time   `json:"createdAt"`
+	UpdatedAt     time.Time   `json:"updatedAt"`
+
+	// enqueuedAt is when a PriorityQueue took the task; it travels with the queued copy only.
+	enqueuedAt time.Time
+}
+
+// DefaultAttemptHistory is the number of attempts kept per task when no limit is configured.
+const DefaultAttemptHistory = 10
+
+// AttemptOutcome is the result of one processing attempt.
+type AttemptOutcome string
+
+const (
+	OutcomeSucceeded AttemptOutcome = "succeeded"
+	OutcomeFailed    AttemptOutcome = "failed"
+	OutcomeCanceled  AttemptOutcome = "canceled"
+	OutcomeTimeout   AttemptOutcome = "timeout"
+	OutcomePanic     AttemptOutcome = "panic"
+	OutcomeSkipped   AttemptOutcome = "skipped"
+)
+
+// AttemptRecord describes one processing attempt of a task.
+type AttemptRecord struct {
+	Attempt    int            `json:"attempt"`
+	WorkerID   string         `json:"workerId"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt"`
+	Outcome    AttemptOutcome `json:"outcome"`
+	Error      string         `json:"error,omitempty"`
+	// ErrorKind is the kind of a failed attempt's error.
+	ErrorKind ErrorKind `json:"errorKind,omitempty"`
+	// Stack is the stack trace of a panicked attempt.
+	Stack string `json:"stack,omitempty"`
 }
 
 // NewTask constructs a new queued task with generated ID and timestamps.
@@ -61,6 +144,31 @@ func NewTaskWithID(id string, payload []byte, maxRetries int) Task {
 	}
 }
 
+// SpanContext returns the trace context the task was enqueued with, if any.
+func (t Task) SpanContext() (tracing.SpanContext, bool) {
+	if t.TraceParent == "" {
+		return tracing.SpanContext{}, false
+	}
+	sc, err := tracing.Parse(t.TraceParent, t.TraceState)
+	return sc, err == nil
+}
+
+// SetSpanContext records sc as the trace context of the task.
+func (t *Task) SetSpanContext(sc tracing.SpanContext) {
+	t.TraceParent, t.TraceState = sc.Traceparent(), ""
+	if t.TraceParent != "" {
+		t.TraceState = sc.State
+	}
+}
+
+// dueAt is when t became due: its run_at, or its creation for an immediate task.
+func (t Task) dueAt() time.Time {
+	if t.RunAt != nil && t.RunAt.After(t.CreatedAt) {
+		return *t.RunAt
+	}
+	return t.CreatedAt
+}
+
 func generateID() string {
 	var b [16]byte
 	_, _ = rand.Read(b[:])
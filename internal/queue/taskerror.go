@@ -0,0 +1,78 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrorKind tells the worker what to do with a task after a failed attempt.
+type ErrorKind string
+
+const (
+	// ErrorRetryable failures are retried with the task's retry policy while attempts are left.
+	// Any error not marked otherwise is retryable.
+	ErrorRetryable ErrorKind = "retryable"
+	// ErrorPermanent failures fail the task at once, whatever attempts are left.
+	ErrorPermanent ErrorKind = "permanent"
+	// ErrorRetryAfter failures are retried after the delay given by the handler instead of the
+	// backoff of the retry policy.
+	ErrorRetryAfter ErrorKind = "retry_after"
+	// ErrorSkip discards the task: it ends skipped, without retries and dead letter.
+	ErrorSkip ErrorKind = "skip"
+)
+
+// TaskError is a handler error marked with the kind that decides the task's fate.
+// Build one with Permanent, RetryAfter or Skip.
+type TaskError struct {
+	Kind ErrorKind
+	// Delay is the wait before the next attempt of an ErrorRetryAfter error.
+	Delay time.Duration
+	Err   error
+}
+
+// Error returns the message of Err, or names the kind when Err is nil, as in Skip(nil).
+func (e *TaskError) Error() string {
+	msg := string(e.Kind) + " error"
+	if e.Err != nil {
+		msg = e.Err.Error()
+	}
+	if e.Kind == ErrorRetryAfter {
+		return fmt.Sprintf("%s (retry after %s)", msg, e.Delay)
+	}
+	return msg
+}
+
+func (e *TaskError) Unwrap() error { return e.Err }
+
+// Permanent marks err as not worth retrying, e.g. for a malformed payload.
+func Permanent(err error) error {
+	return &TaskError{Kind: ErrorPermanent, Err: err}
+}
+
+// RetryAfter asks for the next attempt after d, e.g. to honour a downstream rate limit.
+// The delay still counts against the task's retries and is bounded by the pool's maximum
+// retry delay.
+func RetryAfter(err error, d time.Duration) error {
+	if d < 0 {
+		d = 0
+	}
+	return &TaskError{Kind: ErrorRetryAfter, Delay: d, Err: err}
+}
+
+// Skip discards the task, e.g. when its work turned out to be no longer needed.
+func Skip(err error) error {
+	return &TaskError{Kind: ErrorSkip, Err: err}
+}
+
+// KindOf classifies an attempt error. Missing handlers and oversized results are permanent.
+func KindOf(err error) ErrorKind {
+	var te *TaskError
+	switch {
+	case errors.As(err, &te):
+		return te.Kind
+	case errors.Is(err, ErrNoHandler), errors.Is(err, ErrResultTooLarge):
+		return ErrorPermanent
+	}
+	return ErrorRetryable
+}
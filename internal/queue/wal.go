@@ -0,0 +1,87 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// walOp identifies the kind of mutation stored in a write-ahead log record.
+type walOp uint8
+
+const (
+	walPut walOp = iota + 1
+	walDelete
+)
+
+// walRecord is a single logged mutation. Put records carry the full task state after
+// the mutation, so replaying a record twice is harmless.
+type walRecord struct {
+	Op   walOp
+	ID   string
+	Task Task
+}
+
+// walHeaderSize is the size of the per-record header: payload length and CRC-32C of the payload.
+const walHeaderSize = 8
+
+// walMaxRecord bounds the payload length accepted on replay to reject garbage headers.
+const walMaxRecord = 64 << 20
+
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errWALCorrupt marks a torn or corrupted record; everything from it onward is discarded.
+var errWALCorrupt = errors.New("wal: corrupt record")
+
+// encodeWALRecord frames rec as header + gob payload.
+func encodeWALRecord(rec walRecord) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := gob.NewEncoder(&payload).Encode(rec); err != nil {
+		return nil, fmt.Errorf("wal: encode record: %w", err)
+	}
+	buf := make([]byte, walHeaderSize+payload.Len())
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(payload.Len()))
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.Checksum(payload.Bytes(), walTable))
+	copy(buf[walHeaderSize:], payload.Bytes())
+	return buf, nil
+}
+
+// readWAL decodes records from r and calls apply for each of them. It returns the offset
+// just past the last valid record; a non-nil error wrapping errWALCorrupt means the log has
+// a damaged tail starting at that offset.
+func readWAL(r io.Reader, apply func(walRecord)) (int64, error) {
+	br := bufio.NewReader(r)
+	var offset int64
+	header := make([]byte, walHeaderSize)
+	for {
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF {
+				return offset, nil
+			}
+			return offset, fmt.Errorf("%w: short header at offset %d", errWALCorrupt, offset)
+		}
+		size := binary.LittleEndian.Uint32(header[0:4])
+		sum := binary.LittleEndian.Uint32(header[4:8])
+		if size == 0 || size > walMaxRecord {
+			return offset, fmt.Errorf("%w: bad length %d at offset %d", errWALCorrupt, size, offset)
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return offset, fmt.Errorf("%w: short payload at offset %d", errWALCorrupt, offset)
+		}
+		if crc32.Checksum(payload, walTable) != sum {
+			return offset, fmt.Errorf("%w: checksum mismatch at offset %d", errWALCorrupt, offset)
+		}
+		var rec walRecord
+		if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&rec); err != nil {
+			return offset, fmt.Errorf("%w: decode at offset %d: %v", errWALCorrupt, offset, err)
+		}
+		apply(rec)
+		offset += int64(walHeaderSize) + int64(size)
+	}
+}
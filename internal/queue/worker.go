@@ -2,69 +2,387 @@ package queue
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/optongroup/kaspersky-safeboard-go-container-security/internal/tracing"
 )
 
+// WorkerConfig configures a pool started by StartWorkerPool.
+type WorkerConfig struct {
+	// Workers is the number of goroutines consuming the queue.
+	Workers int
+	// Registry resolves the handler for each dequeued task.
+	Registry *Registry
+	// Seed derives the per-worker RNG used for backoff jitter.
+	Seed int64
+	// RetryPolicy is the retry policy of tasks whose type or task sets none; an empty Strategy
+	// uses DefaultRetryPolicy. Its MaxDelay also caps the delays of type and task policies.
+	RetryPolicy RetryPolicy
+	// BackoffBase, when set, overrides the Base of RetryPolicy.
+	BackoffBase time.Duration
+	// MaxRetries, when set, caps the retries of every task handled by the pool.
+	MaxRetries *int
+	// Stats, when set, receives the pool's running/done/failed counters.
+	Stats *QueueStats
+	// DeadLetters, when set, receives tasks that exhausted their retries along with
+	// the error of every attempt.
+	DeadLetters *DeadLetterQueue
+	// Name prefixes worker ids recorded in task attempt history ("worker" when empty).
+	Name string
+	// AttemptHistory bounds the attempts kept per task; zero uses DefaultAttemptHistory.
+	AttemptHistory int
+	// MaxResultSize bounds the stored result in bytes; zero uses DefaultMaxResultSize and a
+	// negative value disables the limit.
+	MaxResultSize int
+	// Canceler, when set, lets tasks be canceled while queued or running.
+	Canceler *Canceler
+	// MaxTimeout caps the deadline of every attempt and applies to tasks without a timeout
+	// of their own or of their type; zero means no cap.
+	MaxTimeout time.Duration
+	// Retries holds failed tasks until their backoff expires and re-enqueues them. When nil
+	// the pool starts its own Scheduler over its queue.
+	Retries *Scheduler
+	// Logger receives the lifecycle events of every task; nil uses slog.Default().
+	Logger *slog.Logger
+	// Tracer, when set, records a queue wait span and an attempt span for every task that
+	// carries a trace context.
+	Tracer *tracing.Tracer
+}
+
 // StartWorkers launches numWorkers goroutines that consume tasks from queueCh until ctx is done.
-// Each worker marks task as running, simulates processing for 100-500ms, and finishes with
-// either done or failed status, with approximately 20% failure probability.
+// Every task is processed by the built-in simulation handler (see NewSimulateHandler), so each
+// attempt takes 100-500ms and fails with approximately 20% probability.
 // To keep tests deterministic, pass a seed; each worker derives its own independent RNG from this seed.
-func StartWorkers(ctx context.Context, wg *sync.WaitGroup, store *Store, queueCh chan Task, numWorkers int, seed int64) {
-	if numWorkers <= 0 {
+func StartWorkers(ctx context.Context, wg *sync.WaitGroup, store Store, queueCh chan Task, numWorkers int, seed int64) {
+	reg := NewRegistry()
+	reg.SetDefault(NewSimulateHandler(seed))
+	StartWorkerPool(ctx, wg, store, ChanQueue(queueCh), WorkerConfig{Workers: numWorkers, Registry: reg, Seed: seed})
+}
+
+// StartWorkerPool launches cfg.Workers goroutines that consume tasks from queue until ctx is done
+// or the queue is closed and drained.
+// Each worker marks the task as running and dispatches it to the handler registered for its type,
+// under a deadline when the task carries a timeout.
+// A handler error is retried with backoff while attempts are left: the task is marked retrying
+// and handed to cfg.Retries, so the worker is free for other tasks during the backoff. Once
+// attempts are exhausted the task is marked failed and handed to cfg.DeadLetters. The kind of
+// the error (see KindOf) can fail the task at once, set the delay of the retry or discard the
+// task as skipped. Tasks canceled through cfg.Canceler are skipped or stopped and marked canceled.
+func StartWorkerPool(ctx context.Context, wg *sync.WaitGroup, store Store, queue Queue, cfg WorkerConfig) {
+	if cfg.Workers <= 0 {
 		return
 	}
-	for i := 0; i < numWorkers; i++ {
+	if cfg.Registry == nil {
+		cfg.Registry = NewRegistry()
+	}
+	if cfg.RetryPolicy.Strategy == "" {
+		cfg.RetryPolicy.Strategy, cfg.RetryPolicy.Jitter = DefaultRetryPolicy.Strategy, DefaultRetryPolicy.Jitter
+	}
+	if cfg.BackoffBase > 0 {
+		cfg.RetryPolicy.Base = cfg.BackoffBase
+	}
+	if cfg.RetryPolicy.Base <= 0 {
+		cfg.RetryPolicy.Base = BackoffBase
+	}
+	if cfg.MaxResultSize == 0 {
+		cfg.MaxResultSize = DefaultMaxResultSize
+	}
+	stopRetries := func() {}
+	if cfg.Retries == nil {
+		var retryCtx context.Context
+		retryCtx, stopRetries = context.WithCancel(ctx)
+		cfg.Retries = NewScheduler(store, queue)
+		cfg.Retries.Start(retryCtx, wg)
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	name := cfg.Name
+	if name == "" {
+		name = "worker"
+	} else {
+		cfg.Logger = cfg.Logger.With("queue", name)
+	}
+	// workers tracks this pool alone, so that its own retry scheduler stops with it
+	var workers sync.WaitGroup
+	for i := 0; i < cfg.Workers; i++ {
+		id := fmt.Sprintf("%s-%d", name, i+1)
+		w := &poolWorker{
+			id:    id,
+			cfg:   cfg,
+			store: store,
+			rng:   rand.New(rand.NewSource(cfg.Seed + int64(i+1))),
+			log:   cfg.Logger.With("worker_id", id),
+		}
 		wg.Add(1)
-		workerSeed := seed + int64(i+1)
-		go func(localSeed int64) {
+		workers.Add(1)
+		go func() {
 			defer wg.Done()
-			rng := rand.New(rand.NewSource(localSeed))
+			defer workers.Done()
 			for {
-				select {
-				case <-ctx.Done():
+				t, ok := queue.Pop(ctx)
+				if !ok || !w.process(ctx, t) {
 					return
-				case t, ok := <-queueCh:
-					if !ok {
-						return
-					}
-					// Mark running
-					store.UpdateStatus(t.ID, StatusRunning, t.Attempt)
-					// Simulate processing time 100-500ms
-					sleepMs := 100 + rng.Intn(401) // [100,500]
-					timer := time.NewTimer(time.Duration(sleepMs) * time.Millisecond)
-					select {
-					case <-ctx.Done():
-						if !timer.Stop() {
-							<-timer.C
-						}
-						return
-					case <-timer.C:
-					}
-
-					// 20% failure probability
-					if rng.Intn(100) < 20 {
-						// retry if attempts left
-						if t.Attempt < t.MaxRetries {
-							nextAttempt := t.Attempt + 1
-							backoff := BackoffDelay(BackoffBase, nextAttempt, JitterMax, rng)
-							select {
-							case <-ctx.Done():
-								return
-							case <-time.After(backoff):
-							}
-							// re-enqueue with incremented attempt
-							t.Attempt = nextAttempt
-							_ = TryEnqueueWithContext(ctx, queueCh, t, 10*time.Millisecond)
-							continue
-						}
-						store.UpdateStatus(t.ID, StatusFailed, t.Attempt)
-						continue
-					}
-					store.UpdateStatus(t.ID, StatusDone, t.Attempt)
 				}
 			}
-		}(workerSeed)
+		}()
+	}
+	go func() {
+		workers.Wait()
+		stopRetries()
+	}()
+}
+
+// poolWorker is the state of one worker goroutine.
+type poolWorker struct {
+	id    string
+	cfg   WorkerConfig
+	store Store
+	rng   *rand.Rand
+	log   *slog.Logger
+}
+
+// process runs one attempt of t and decides its fate: done, retry, failed, skipped or canceled.
+// It returns false when the worker must stop because ctx is done.
+func (w *poolWorker) process(ctx context.Context, t Task) bool {
+	cfg, store := w.cfg, w.store
+	log := w.log.With("task_id", t.ID, "task_type", t.Type, "attempt", t.Attempt)
+	taskCtx, cancel, ok := cfg.Canceler.begin(ctx, store, t)
+	if !ok {
+		// canceled while waiting in the queue
+		cfg.DeadLetters.forget(t.ID)
+		cfg.Stats.addCanceled()
+		log.Info("task canceled", "while", "queued")
+		return true
+	}
+	defer cancel(nil)
+	defer cfg.Canceler.end(t.ID)
+
+	cfg.Stats.addRunning(1)
+	startedAt := time.Now().UTC()
+	var wait time.Duration
+	if !t.enqueuedAt.IsZero() {
+		wait = startedAt.Sub(t.enqueuedAt)
+		cfg.Stats.observeWait(t.Type, wait)
+	}
+	log.Debug("task started", "wait", wait)
+	attemptCtx, span := w.startSpans(taskCtx, t, startedAt)
+	res, err := runAttempt(attemptCtx, cfg.Registry, t, w.attemptTimeout(t), cfg.Stats)
+	duration := time.Since(startedAt)
+	cfg.Stats.addRunning(-1)
+	cfg.Stats.addAttempt()
+	cfg.Stats.observeRun(t.Type, duration)
+	log = log.With("duration", duration)
+	if ctx.Err() != nil {
+		// shutting down: leave the task as running; recovery retries it without the errors
+		// collected so far, which live in memory only
+		cfg.DeadLetters.forget(t.ID)
+		log.Info("task interrupted by shutdown")
+		span.End(time.Now().UTC(), ctx.Err())
+		return false
+	}
+	canceled := errors.Is(context.Cause(taskCtx), ErrCanceled)
+	if err == nil && cfg.MaxResultSize > 0 && len(res.Data) > cfg.MaxResultSize {
+		err = fmt.Errorf("%w: %d bytes, limit %d", ErrResultTooLarge, len(res.Data), cfg.MaxResultSize)
+	}
+	var panicErr *PanicError
+	rec := AttemptRecord{Attempt: t.Attempt, WorkerID: w.id, StartedAt: startedAt, FinishedAt: time.Now().UTC(), Outcome: OutcomeSucceeded}
+	switch {
+	case err != nil && canceled:
+		rec.Outcome, rec.Error = OutcomeCanceled, err.Error()
+	case errors.Is(err, ErrTimeout):
+		rec.Outcome, rec.Error = OutcomeTimeout, err.Error()
+		cfg.Stats.addTimeout()
+	case errors.As(err, &panicErr):
+		rec.Outcome, rec.Error, rec.Stack = OutcomePanic, err.Error(), panicErr.Stack
+		cfg.Stats.addPanic()
+		log.Error("task panicked", "err", err, "stack", panicErr.Stack)
+	case err != nil:
+		rec.Outcome, rec.Error = OutcomeFailed, err.Error()
+	}
+	if err != nil && !canceled {
+		rec.ErrorKind = KindOf(err)
+		if rec.ErrorKind == ErrorSkip {
+			rec.Outcome = OutcomeSkipped
+		}
+	}
+	store.RecordAttempt(t.ID, rec, cfg.AttemptHistory)
+	span.SetAttributes("task.outcome", string(rec.Outcome))
+	span.End(rec.FinishedAt, err)
+	if err == nil {
+		// a handler that finished its work despite a cancel request still counts as done
+		if res.ContentType != "" || len(res.Data) > 0 {
+			store.SetResult(t.ID, newTaskResult(res, rec.FinishedAt))
+		}
+		cfg.DeadLetters.forget(t.ID)
+		cfg.Stats.addDone()
+		cfg.Stats.observeTotal(t.Type, rec.FinishedAt.Sub(t.dueAt()))
+		store.UpdateStatus(t.ID, StatusDone, t.Attempt)
+		log.Info("task done")
+		return true
+	}
+	if canceled {
+		w.finishCanceled(log, t)
+		return true
+	}
+	if rec.ErrorKind == ErrorSkip {
+		log.Info("task skipped", "err", err)
+		cfg.DeadLetters.forget(t.ID)
+		cfg.Stats.addSkipped()
+		cfg.Stats.observeTotal(t.Type, rec.FinishedAt.Sub(t.dueAt()))
+		store.UpdateStatus(t.ID, StatusSkipped, t.Attempt)
+		return true
+	}
+	attemptErr := AttemptError{Attempt: t.Attempt, Error: rec.Error, StartedAt: rec.StartedAt, FinishedAt: rec.FinishedAt}
+	maxRetries := t.MaxRetries
+	if cfg.MaxRetries != nil && *cfg.MaxRetries < maxRetries {
+		maxRetries = *cfg.MaxRetries
+	}
+	if t.Attempt < maxRetries && rec.ErrorKind != ErrorPermanent {
+		cfg.DeadLetters.recordAttempt(t.ID, attemptErr)
+		t.Attempt++
+		delay := w.retryDelay(t, err)
+		if cur, ok := store.ScheduleRetry(t.ID, t.Attempt, delay); !ok && cur.Status == StatusCanceled {
+			// canceled between the attempt and the retry
+			w.finishCanceled(log, t)
+			return true
+		}
+		// from here on a cancel request finds the task retrying in the store rather than running
+		cfg.Canceler.end(t.ID)
+		if errors.Is(context.Cause(taskCtx), ErrCanceled) {
+			w.finishCanceled(log, t)
+			return true
+		}
+		cfg.Stats.addRetried()
+		at := time.Now().Add(delay)
+		t.Status, t.NextAttemptAt, t.RetryDelay = StatusRetrying, &at, delay
+		cfg.Retries.Schedule(t)
+		log.Warn("task retrying", "err", err, "error_kind", rec.ErrorKind, "delay", delay, "next_attempt", t.Attempt)
+		return true
+	}
+	cfg.Stats.addFailed()
+	if rec.ErrorKind != ErrorPermanent {
+		cfg.Stats.addExhausted()
+	}
+	cfg.Stats.observeTotal(t.Type, rec.FinishedAt.Sub(t.dueAt()))
+	cfg.DeadLetters.bury(t, attemptErr)
+	store.UpdateStatus(t.ID, StatusFailed, t.Attempt)
+	log.Error("task failed", "err", err, "error_kind", rec.ErrorKind)
+	return true
+}
+
+// startSpans records the queue wait of a traced task and starts the span of its attempt.
+// The returned context carries the attempt's span context, or the task's own without a
+// tracer, so that the handler can propagate it.
+func (w *poolWorker) startSpans(ctx context.Context, t Task, startedAt time.Time) (context.Context, *tracing.Span) {
+	parent, ok := t.SpanContext()
+	if !ok {
+		return ctx, nil
+	}
+	attrs := []any{"task.id", t.ID, "task.type", t.Type, "task.queue", t.Queue, "task.attempt", t.Attempt, "worker.id", w.id}
+	if !t.enqueuedAt.IsZero() {
+		wait := w.cfg.Tracer.Start(parent, "queue.wait", t.enqueuedAt)
+		wait.SetAttributes(attrs...)
+		wait.End(startedAt, nil)
+	}
+	span := w.cfg.Tracer.Start(parent, "task.attempt", startedAt)
+	span.SetAttributes(attrs...)
+	if span != nil {
+		parent = span.Context()
+	}
+	return tracing.ContextWithSpanContext(ctx, parent), span
+}
+
+// finishCanceled records the final state of a task canceled while running.
+func (w *poolWorker) finishCanceled(log *slog.Logger, t Task) {
+	w.cfg.DeadLetters.forget(t.ID)
+	w.cfg.Stats.addCanceled()
+	w.store.UpdateStatus(t.ID, StatusCanceled, t.Attempt)
+	log.Info("task canceled", "while", "running")
+}
+
+// retryDelay returns the wait before the next attempt of t after err: the delay requested by
+// a RetryAfter error, else the backoff of t's retry policy. The pool's MaxDelay bounds both.
+func (w *poolWorker) retryDelay(t Task, err error) time.Duration {
+	var te *TaskError
+	if !errors.As(err, &te) || te.Kind != ErrorRetryAfter {
+		return w.retryPolicy(t).Delay(t.Attempt, t.RetryDelay, w.rng)
+	}
+	if limit := w.cfg.RetryPolicy.MaxDelay; limit > 0 && te.Delay > limit {
+		return limit
+	}
+	return te.Delay
+}
+
+// retryPolicy returns the policy for the next retry of t: its own, else its type's, else the
+// pool's. The MaxDelay of the pool's policy bounds all of them.
+func (w *poolWorker) retryPolicy(t Task) RetryPolicy {
+	policy := w.cfg.RetryPolicy
+	if t.Retry != nil {
+		policy = *t.Retry
+	} else if typed := w.cfg.Registry.Policy(t.Type).Retry; typed != nil {
+		policy = *typed
+	}
+	if limit := w.cfg.RetryPolicy.MaxDelay; limit > 0 && (policy.MaxDelay <= 0 || policy.MaxDelay > limit) {
+		policy.MaxDelay = limit
+	}
+	return policy
+}
+
+// attemptTimeout returns the deadline of one attempt of t: its own timeout or else the default
+// of its type, bounded by cfg.MaxTimeout. Zero means no deadline.
+func (w *poolWorker) attemptTimeout(t Task) time.Duration {
+	timeout := t.Timeout
+	if timeout <= 0 {
+		timeout = w.cfg.Registry.Policy(t.Type).Timeout
+	}
+	if limit := w.cfg.MaxTimeout; limit > 0 && (timeout <= 0 || timeout > limit) {
+		timeout = limit
+	}
+	return timeout
+}
+
+// runAttempt dispatches t to its handler, under a deadline when timeout is positive.
+// A handler that outlives its deadline is abandoned: the worker moves on with ErrTimeout while
+// the handler goroutine finishes in the background, counted as abandoned in stats. On
+// cancellation or shutdown the handler is waited for, as it is expected to observe its context.
+func runAttempt(ctx context.Context, reg *Registry, t Task, timeout time.Duration, stats *QueueStats) (Result, error) {
+	if timeout <= 0 {
+		return reg.Dispatch(ctx, t)
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, ErrTimeout)
+	defer cancel()
+	type outcome struct {
+		res Result
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		res, err := reg.Dispatch(ctx, t)
+		done <- outcome{res, err}
+	}()
+	select {
+	case o := <-done:
+		if o.err != nil && errors.Is(context.Cause(ctx), ErrTimeout) {
+			return Result{}, fmt.Errorf("%w after %s: %w", ErrTimeout, timeout, o.err)
+		}
+		return o.res, o.err
+	case <-ctx.Done():
+		if !errors.Is(context.Cause(ctx), ErrTimeout) {
+			o := <-done
+			return o.res, o.err
+		}
+		// the handler ignores its context; it is counted until it returns
+		stats.addAbandoned(1)
+		go func() {
+			<-done
+			stats.addAbandoned(-1)
+		}()
+		return Result{}, fmt.Errorf("%w after %s", ErrTimeout, timeout)
 	}
 }
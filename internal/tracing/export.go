@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Span statuses.
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// SpanData is a finished span as handed to an Exporter.
+type SpanData struct {
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	TraceState   string         `json:"trace_state,omitempty"`
+	Name         string         `json:"name"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	Status       string         `json:"status"`
+	Error        string         `json:"error,omitempty"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+}
+
+// Exporter receives finished spans. Implementations must be safe for concurrent use.
+type Exporter interface {
+	ExportSpan(SpanData) error
+}
+
+// FileExporter appends spans to a file as JSON lines, for local use.
+type FileExporter struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewFileExporter opens path for appending, creating it if needed.
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// ExportSpan writes s as one line.
+func (e *FileExporter) ExportSpan(s SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(s)
+}
+
+// Close closes the file.
+func (e *FileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.f.Close()
+}
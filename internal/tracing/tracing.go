@@ -0,0 +1,194 @@
+// Package tracing propagates W3C trace context (traceparent/tracestate) and records spans
+// through a pluggable Exporter.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// MaxTraceState is the longest tracestate kept; longer values are dropped.
+const MaxTraceState = 512
+
+// ErrInvalidTraceparent is returned for a malformed traceparent header.
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// TraceID and SpanID identify a trace and a span within it.
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// FlagSampled is the sampled bit of the trace flags.
+const FlagSampled byte = 0x01
+
+// SpanContext is the propagated part of a span: the identifiers, the trace flags and the
+// vendor-specific tracestate.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	State   string
+}
+
+// IsValid reports whether sc has non-zero trace and span ids.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Sampled reports whether the caller asked for the trace to be recorded.
+func (sc SpanContext) Sampled() bool { return sc.Flags&FlagSampled != 0 }
+
+// Traceparent formats sc as a version 00 traceparent header; it is empty for an invalid sc.
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+// Parse reads a traceparent header and its tracestate. Versions above 00 are read by their
+// version 00 prefix, as the specification requires; a tracestate over MaxTraceState bytes
+// is dropped.
+func Parse(traceparent, tracestate string) (SpanContext, error) {
+	var sc SpanContext
+	h := traceparent
+	if len(h) < 55 || (len(h) > 55 && (h[:2] == "00" || h[55] != '-')) {
+		return sc, ErrInvalidTraceparent
+	}
+	if h[2] != '-' || h[35] != '-' || h[52] != '-' ||
+		!isLowerHex(h[0:2]) || !isLowerHex(h[3:35]) || !isLowerHex(h[36:52]) || !isLowerHex(h[53:55]) {
+		return sc, ErrInvalidTraceparent
+	}
+	version, flags := decodeByte(h[0:2]), decodeByte(h[53:55])
+	if version == 0xff {
+		return sc, ErrInvalidTraceparent
+	}
+	_, _ = hex.Decode(sc.TraceID[:], []byte(h[3:35]))
+	_, _ = hex.Decode(sc.SpanID[:], []byte(h[36:52]))
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	sc.Flags = flags
+	if len(tracestate) <= MaxTraceState {
+		sc.State = tracestate
+	}
+	return sc, nil
+}
+
+// isLowerHex reports whether s holds only lower-case hex digits.
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeByte(s string) byte {
+	var b [1]byte
+	_, _ = hex.Decode(b[:], []byte(s))
+	return b[0]
+}
+
+type contextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx carrying sc.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// SpanContextFromContext returns the span context carried by ctx, if any. Task handlers use
+// it to continue the trace in outgoing calls.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// Tracer creates spans and hands the finished, sampled ones to its Exporter. A nil *Tracer
+// records nothing.
+type Tracer struct {
+	exporter Exporter
+}
+
+// NewTracer returns a tracer exporting to e.
+func NewTracer(e Exporter) *Tracer {
+	return &Tracer{exporter: e}
+}
+
+// Start begins a span named name at start, as a child of parent or as the root of a new
+// sampled trace when parent is invalid. It returns nil for a nil Tracer.
+func (t *Tracer) Start(parent SpanContext, name string, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{tracer: t, data: SpanData{Name: name, Start: start}}
+	if parent.IsValid() {
+		s.ctx = SpanContext{TraceID: parent.TraceID, Flags: parent.Flags, State: parent.State}
+		s.data.ParentSpanID = parent.SpanID.String()
+	} else {
+		_, _ = rand.Read(s.ctx.TraceID[:])
+		s.ctx.Flags = FlagSampled
+	}
+	_, _ = rand.Read(s.ctx.SpanID[:])
+	s.data.TraceID, s.data.SpanID, s.data.TraceState = s.ctx.TraceID.String(), s.ctx.SpanID.String(), s.ctx.State
+	return s
+}
+
+// Span is a span in progress. Its methods are not safe for concurrent use; a nil *Span
+// ignores them.
+type Span struct {
+	tracer *Tracer
+	ctx    SpanContext
+	data   SpanData
+}
+
+// Context returns the span context children of s descend from.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// SetAttributes adds key/value pairs to the span.
+func (s *Span) SetAttributes(kv ...any) {
+	if s == nil {
+		return
+	}
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]any, len(kv)/2)
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if k, ok := kv[i].(string); ok {
+			s.data.Attributes[k] = kv[i+1]
+		}
+	}
+}
+
+// End finishes the span at end with the outcome err and exports it when sampled.
+func (s *Span) End(end time.Time, err error) {
+	if s == nil {
+		return
+	}
+	s.data.End = end
+	s.data.Status = StatusOK
+	if err != nil {
+		s.data.Status, s.data.Error = StatusError, err.Error()
+	}
+	if !s.ctx.Sampled() || s.tracer.exporter == nil {
+		return
+	}
+	if err := s.tracer.exporter.ExportSpan(s.data); err != nil {
+		slog.Warn("tracing: export span", "err", err, "span", s.data.Name)
+	}
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func TestStore_RecordAttemptBounded(t *testing.T) {
+	store := q.NewStore()
+	store.Save(q.NewTaskWithID("h1", []byte(`1`), 10))
+	for i := 0; i < 5; i++ {
+		rec := q.AttemptRecord{Attempt: i, Outcome: q.OutcomeFailed, Error: "err"}
+		if i == 4 {
+			rec = q.AttemptRecord{Attempt: i, Outcome: q.OutcomeSucceeded}
+		}
+		if _, ok := store.RecordAttempt("h1", rec, 3); !ok {
+			t.Fatalf("record %d: task not found", i)
+		}
+	}
+	got, _ := store.Get("h1")
+	if len(got.Attempts) != 3 || got.Attempts[0].Attempt != 2 || got.Attempts[2].Attempt != 4 {
+		t.Fatalf("expected the 3 most recent attempts, got %+v", got.Attempts)
+	}
+	if got.LastError != "err" {
+		t.Fatalf("last error must survive a later success, got %q", got.LastError)
+	}
+	if _, ok := store.RecordAttempt("missing", q.AttemptRecord{}, 0); ok {
+		t.Fatal("recording an attempt of an unknown task must fail")
+	}
+	if m := store.GetMetrics(); m.Queued != 1 {
+		t.Fatalf("recording attempts must not touch metrics: %+v", m)
+	}
+}
+
+func TestWorker_RecordsAttemptHistory(t *testing.T) {
+	store := q.NewStore()
+	registry := q.NewRegistry()
+	var calls atomic.Int32
+	registry.Register("flaky", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		if calls.Add(1) <= 2 {
+			return q.Result{}, errors.New("scanner busy")
+		}
+		return q.Result{}, nil
+	}))
+	ch := make(chan q.Task, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	q.StartWorkerPool(ctx, &wg, store, q.ChanQueue(ch), q.WorkerConfig{Workers: 2, Registry: registry, BackoffBase: time.Millisecond, Name: "scan"})
+
+	task := q.NewTaskWithID("h2", []byte(`{}`), 3)
+	task.Type = "flaky"
+	store.Save(task)
+	ch <- task
+	got := waitForStatus(t, store, "h2", q.StatusDone, 2*time.Second)
+
+	if len(got.Attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %+v", got.Attempts)
+	}
+	for i, rec := range got.Attempts {
+		wantOutcome := q.OutcomeFailed
+		if i == 2 {
+			wantOutcome = q.OutcomeSucceeded
+		}
+		if rec.Attempt != i || rec.Outcome != wantOutcome || !strings.HasPrefix(rec.WorkerID, "scan-") {
+			t.Fatalf("unexpected attempt %d: %+v", i, rec)
+		}
+		if rec.StartedAt.IsZero() || rec.FinishedAt.Before(rec.StartedAt) {
+			t.Fatalf("attempt %d has invalid timing: %+v", i, rec)
+		}
+		if (rec.Error != "") != (wantOutcome == q.OutcomeFailed) {
+			t.Fatalf("attempt %d error mismatch: %+v", i, rec)
+		}
+	}
+	if got.LastError != "scanner busy" {
+		t.Fatalf("unexpected last error %q", got.LastError)
+	}
+
+	// the status endpoint exposes the history
+	h := httpserver.NewHandlerWithDeps(store, make(chan q.Task, 1), new(atomic.Bool))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status/h2", nil))
+	var resp struct {
+		Attempts []struct {
+			Outcome  string `json:"outcome"`
+			WorkerID string `json:"workerId"`
+		} `json:"attempts"`
+		LastError string `json:"lastError"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid status body: %v", err)
+	}
+	if len(resp.Attempts) != 3 || resp.Attempts[2].Outcome != "succeeded" || resp.Attempts[0].WorkerID == "" || resp.LastError != "scanner busy" {
+		t.Fatalf("unexpected status body: %s", rr.Body.String())
+	}
+}
+
+func TestFileStore_AttemptHistoryPersisted(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{NoSync: true})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	fs.Save(q.NewTaskWithID("h3", []byte(`1`), 1))
+	fs.RecordAttempt("h3", q.AttemptRecord{Attempt: 0, WorkerID: "default-1", Outcome: q.OutcomeFailed, Error: "boom"}, 0)
+	fs.UpdateStatus("h3", q.StatusFailed, 0)
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	reopened, err := q.OpenFileStore(dir, q.FileStoreOptions{NoSync: true})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	got, ok := reopened.Get("h3")
+	if !ok || len(got.Attempts) != 1 || got.Attempts[0].WorkerID != "default-1" || got.LastError != "boom" {
+		t.Fatalf("history not recovered: %+v", got)
+	}
+}
@@ -0,0 +1,282 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+type batchItem struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Error  string `json:"error"`
+}
+
+type batchResult struct {
+	Mode     string      `json:"mode"`
+	Accepted int         `json:"accepted"`
+	Rejected int         `json:"rejected"`
+	Items    []batchItem `json:"items"`
+}
+
+// newBatchHandler serves a two-queue set without workers, so queued tasks stay queued.
+func newBatchHandler(store q.Store, capacity int) (http.Handler, *q.QueueSet, *q.Scheduler) {
+	queues := q.NewQueueSet([]q.QueueSpec{
+		{Name: q.DefaultQueueName, Capacity: capacity, Workers: 1},
+		{Name: "bulk", Capacity: capacity, Workers: 1},
+	}, 0)
+	sched := q.NewScheduler(store, queues)
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queues: queues, Accepting: &acc, Scheduler: sched})
+	return h, queues, sched
+}
+
+func postBatch(h http.Handler, query, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/enqueue/batch"+query, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func itemStatuses(items []batchItem) string {
+	out := make([]string, 0, len(items))
+	for _, it := range items {
+		s := it.Status
+		if it.Error != "" {
+			s += ":" + it.Error
+		}
+		out = append(out, s)
+	}
+	return strings.Join(out, ",")
+}
+
+func TestEnqueueBatch_BestEffort(t *testing.T) {
+	store := q.NewStore()
+	store.Save(q.NewTaskWithID("old", []byte(`{}`), 0))
+	h, queues, sched := newBatchHandler(store, 2)
+
+	rr := postBatch(h, "", "application/json", `[
+		{"id":"a","payload":{"n":1}},
+		{"id":"old","payload":{}},
+		{"id":"b","payload":{},"priority":5000},
+		{"id":"a","payload":{}},
+		{"id":"c","payload":{},"delay":"1h"},
+		{"id":"d","payload":{}},
+		{"id":"e","payload":{}},
+		{"id":7},
+		{"id":"f","payload":{},"queue":"bulk"}
+	]`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+	var res batchResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	want := "queued,duplicate:duplicate_id,rejected:invalid_priority,duplicate:duplicate_id,scheduled,queued,rejected:queue_full,rejected:invalid_json,queued"
+	if got := itemStatuses(res.Items); got != want {
+		t.Fatalf("unexpected items:\n got %s\nwant %s", got, want)
+	}
+	if res.Mode != "best_effort" || res.Accepted != 4 || res.Rejected != 5 || res.Items[8].Index != 8 || res.Items[8].ID != "f" {
+		t.Fatalf("unexpected summary: %+v", res)
+	}
+	if _, ok := store.Get("e"); ok {
+		t.Fatal("a task rejected for capacity must not be stored")
+	}
+	if m := queues.Metrics(); m[q.DefaultQueueName].Depth != 2 || m["bulk"].Depth != 1 || sched.Len() != 1 {
+		t.Fatalf("unexpected queue state: %+v scheduled=%d", m, sched.Len())
+	}
+}
+
+func TestEnqueueBatch_Atomic(t *testing.T) {
+	store := q.NewStore()
+	h, queues, _ := newBatchHandler(store, 2)
+
+	// one invalid item rejects the batch
+	rr := postBatch(h, "?mode=atomic", "", `[{"id":"a","payload":{}},{"id":"b","payload":{},"queue":"nope"},{"id":"a","payload":{}}]`)
+	var res batchResult
+	_ = json.Unmarshal(rr.Body.Bytes(), &res)
+	if rr.Code != http.StatusBadRequest || itemStatuses(res.Items) != "aborted:batch_rejected,rejected:unknown_queue,duplicate:duplicate_id" {
+		t.Fatalf("expected 400 with per-item reasons, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// three tasks for a queue of two: nothing is enqueued
+	rr = postBatch(h, "?mode=atomic", "", `[{"id":"a","payload":{}},{"id":"b","payload":{}},{"id":"c","payload":{},"queue":"bulk"},{"id":"d","payload":{}}]`)
+	res = batchResult{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &res)
+	if rr.Code != http.StatusServiceUnavailable || res.Accepted != 0 || itemStatuses(res.Items) != strings.Repeat("aborted:queue_full,", 3)+"aborted:queue_full" {
+		t.Fatalf("expected 503 with all items aborted, got %d %s", rr.Code, rr.Body.String())
+	}
+	if m := queues.Metrics(); m[q.DefaultQueueName].Depth != 0 || m["bulk"].Depth != 0 {
+		t.Fatalf("a failed atomic batch must leave the queues untouched: %+v", m)
+	}
+	if _, ok := store.Get("a"); ok {
+		t.Fatal("a failed atomic batch must not store tasks")
+	}
+
+	rr = postBatch(h, "?mode=atomic", "", `[{"id":"a","payload":{}},{"id":"b","payload":{},"delay":"1h"},{"id":"c","payload":{},"queue":"bulk"},{"id":"d","payload":{}}]`)
+	res = batchResult{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &res)
+	if rr.Code != http.StatusOK || res.Accepted != 4 || itemStatuses(res.Items) != "queued,scheduled,queued,queued" {
+		t.Fatalf("expected the whole batch, got %d %s", rr.Code, rr.Body.String())
+	}
+	if m := queues.Metrics(); m[q.DefaultQueueName].Depth != 2 || m[q.DefaultQueueName].Enqueued != 2 || m["bulk"].Depth != 1 {
+		t.Fatalf("unexpected queue state: %+v", m)
+	}
+	if task, ok := store.Get("d"); !ok || task.Status != q.StatusQueued {
+		t.Fatalf("expected stored queued task, got %+v", task)
+	}
+}
+
+func TestEnqueueBatch_NDJSON(t *testing.T) {
+	store := q.NewStore()
+	h, _, _ := newBatchHandler(store, 8)
+
+	body := "{\"id\":\"n1\",\"payload\":{}}\n\n{\"id\":\"n2\",\"payload\":\"AAE=\",\"payload_encoding\":\"base64\"}\nnot json\n{\"id\":\"n1\",\"payload\":{}}\n"
+	rr := postBatch(h, "", "application/x-ndjson", body)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("expected 200 NDJSON, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var items []batchItem
+	sc := bufio.NewScanner(bytes.NewReader(rr.Body.Bytes()))
+	for sc.Scan() {
+		var it batchItem
+		if err := json.Unmarshal(sc.Bytes(), &it); err != nil {
+			t.Fatalf("invalid line %q: %v", sc.Text(), err)
+		}
+		items = append(items, it)
+	}
+	if got := itemStatuses(items); got != "queued,queued,rejected:invalid_json,duplicate:duplicate_id" || items[3].Index != 3 {
+		t.Fatalf("unexpected NDJSON results: %s", rr.Body.String())
+	}
+
+	// atomic NDJSON is buffered and answered the same way
+	rr = postBatch(h, "?mode=atomic", "application/x-ndjson; charset=utf-8", "{\"id\":\"n3\",\"payload\":{}}\n{\"id\":\"n4\",\"payload\":{}}")
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), `"status":"queued"`) != 2 {
+		t.Fatalf("unexpected atomic NDJSON response: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestEnqueueBatch_InvalidRequests(t *testing.T) {
+	store := q.NewStore()
+	h, _, _ := newBatchHandler(store, 8)
+	chanHandler, _, _ := newTestHandler(8, true, nil)
+	cases := []struct {
+		h           http.Handler
+		query, body string
+		code        string
+	}{
+		{h, "?mode=maybe", `[{"id":"a","payload":{}}]`, "invalid_mode"},
+		{h, "", `{"id":"a","payload":{}}`, "invalid_json"},
+		{h, "", `[]`, "empty_batch"},
+		{chanHandler, "?mode=atomic", `[{"id":"a","payload":{}}]`, "atomic_unsupported"},
+	}
+	for _, c := range cases {
+		rr := postBatch(c.h, c.query, "", c.body)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), c.code) {
+			t.Fatalf("%s %s: expected 400 %s, got %d %s", c.query, c.body, c.code, rr.Code, rr.Body.String())
+		}
+	}
+	huge := "[" + strings.TrimSuffix(strings.Repeat(`{"id":"x","payload":1},`, 10001), ",") + "]"
+	if rr := postBatch(h, "", "", huge); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "batch_too_large") {
+		t.Fatalf("expected batch_too_large, got %d", rr.Code)
+	}
+
+	// buffered bodies are capped in bytes: a JSON array and NDJSON in atomic mode alike
+	line := `{"id":"x","payload":"` + strings.Repeat("a", 1<<19) + `"}`
+	for _, c := range []struct{ query, contentType, body string }{
+		{"", "", "[" + strings.TrimSuffix(strings.Repeat(line+",", 65), ",") + "]"},
+		{"?mode=atomic", "application/x-ndjson", strings.Repeat(line+"\n", 65)},
+	} {
+		rr := postBatch(h, c.query, c.contentType, c.body)
+		if rr.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rr.Body.String(), "body_too_large") {
+			t.Fatalf("%s %s: expected 413 body_too_large, got %d %s", c.query, c.contentType, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+// instantPusher completes every task the moment it is pushed, like a worker that wins the
+// race against the enqueue path; full rejects every push.
+type instantPusher struct {
+	store q.Store
+	full  bool
+}
+
+func (p *instantPusher) TryPush(t q.Task) bool {
+	if p.full {
+		return false
+	}
+	p.store.UpdateStatus(t.ID, q.StatusRunning, 0)
+	p.store.UpdateStatus(t.ID, q.StatusDone, 0)
+	return true
+}
+
+func (p *instantPusher) TryPushAll(ts []q.Task) bool {
+	if p.full {
+		return false
+	}
+	for _, t := range ts {
+		p.TryPush(t)
+	}
+	return true
+}
+
+func TestEnqueue_SavesBeforePush(t *testing.T) {
+	store := q.NewStore()
+	pusher := &instantPusher{store: store}
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queue: pusher, Accepting: &acc})
+
+	if rr := postEnqueue(h, `{"id":"fast","payload":{}}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	for _, mode := range []string{"best_effort", "atomic"} {
+		body := `[{"id":"` + mode + `-1","payload":{}},{"id":"` + mode + `-2","payload":{}}]`
+		if rr := postBatch(h, "?mode="+mode, "", body); rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d %s", mode, rr.Code, rr.Body.String())
+		}
+	}
+	for _, id := range []string{"fast", "best_effort-1", "best_effort-2", "atomic-1", "atomic-2"} {
+		if task, _ := store.Get(id); task.Status != q.StatusDone {
+			t.Fatalf("task %s finished before the enqueue returned must stay done, got %s", id, task.Status)
+		}
+	}
+	if m := store.GetMetrics(); m != (q.Metrics{Done: 5}) {
+		t.Fatalf("unexpected metrics %+v", m)
+	}
+
+	// a task its queue rejects is rolled back, releasing its id and idempotency key
+	pusher.full = true
+	if rr := postEnqueue(h, `{"id":"full","payload":{},"idempotency_key":"k"}`); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rr.Code)
+	}
+	if rr := postBatch(h, "?mode=atomic", "", `[{"id":"full1","payload":{}},{"id":"full2","payload":{}}]`); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for the atomic batch, got %d", rr.Code)
+	}
+	for _, id := range []string{"full", "full1", "full2"} {
+		if _, ok := store.Get(id); ok {
+			t.Fatalf("rejected task %s must not stay stored", id)
+		}
+	}
+	if m := store.GetMetrics(); m != (q.Metrics{Done: 5}) {
+		t.Fatalf("rollback must leave the metrics untouched, got %+v", m)
+	}
+	pusher.full = false
+	if rr := postEnqueue(h, `{"id":"full","payload":{},"idempotency_key":"k"}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("a rolled back task must be accepted again, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,266 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+type cancelFixture struct {
+	store    *q.MemoryStore
+	canceler *q.Canceler
+	ch       chan q.Task
+	h        http.Handler
+	dlq      *q.DeadLetterQueue
+}
+
+func newCancelFixture() *cancelFixture {
+	dlq, _ := q.NewDeadLetterQueue("")
+	f := &cancelFixture{store: q.NewStore(), canceler: q.NewCanceler(dlq), ch: make(chan q.Task, 4), dlq: dlq}
+	var acc atomic.Bool
+	acc.Store(true)
+	f.h = httpserver.NewHandlerWithOptions(httpserver.Options{Store: f.store, Queue: q.ChanQueue(f.ch), Accepting: &acc, Canceler: f.canceler})
+	return f
+}
+
+// start runs a single-worker pool with the given handler until the test ends.
+func (f *cancelFixture) start(t *testing.T, h q.Handler, backoff time.Duration) {
+	registry := q.NewRegistry()
+	registry.SetDefault(h)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	q.StartWorkerPool(ctx, &wg, f.store, q.ChanQueue(f.ch), q.WorkerConfig{
+		Workers:     1,
+		Registry:    registry,
+		BackoffBase: backoff,
+		Canceler:    f.canceler,
+		DeadLetters: f.dlq,
+	})
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+}
+
+func (f *cancelFixture) enqueue(id string, maxRetries int) {
+	task := q.NewTaskWithID(id, []byte(`{}`), maxRetries)
+	f.store.Save(task)
+	f.ch <- task
+}
+
+func (f *cancelFixture) cancel(id string) (*httptest.ResponseRecorder, map[string]any) {
+	rr := httptest.NewRecorder()
+	f.h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/tasks/"+id+"/cancel", nil))
+	var body map[string]any
+	_ = json.Unmarshal(rr.Body.Bytes(), &body)
+	return rr, body
+}
+
+func TestCancel_QueuedTaskSkipped(t *testing.T) {
+	f := newCancelFixture()
+	f.enqueue("c1", 0)
+	rr, body := f.cancel("c1")
+	if rr.Code != http.StatusOK || body["status"] != string(q.StatusCanceled) {
+		t.Fatalf("expected 200 canceled, got %d %s", rr.Code, rr.Body.String())
+	}
+	if m := f.store.GetMetrics(); m.Canceled != 1 || m.Queued != 0 {
+		t.Fatalf("unexpected metrics: %+v", m)
+	}
+
+	var calls atomic.Int32
+	f.start(t, q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		calls.Add(1)
+		return q.Result{}, nil
+	}), time.Millisecond)
+	f.enqueue("c2", 0)
+	waitForStatus(t, f.store, "c2", q.StatusDone, time.Second)
+	if calls.Load() != 1 {
+		t.Fatalf("canceled task must not reach its handler, got %d calls", calls.Load())
+	}
+	if got, _ := f.store.Get("c1"); got.Status != q.StatusCanceled {
+		t.Fatalf("expected canceled, got %s", got.Status)
+	}
+}
+
+func TestCancel_RunningTaskStopsCooperatively(t *testing.T) {
+	f := newCancelFixture()
+	var calls atomic.Int32
+	f.start(t, q.HandlerFunc(func(ctx context.Context, _ q.Task) (q.Result, error) {
+		calls.Add(1)
+		<-ctx.Done()
+		return q.Result{}, ctx.Err()
+	}), time.Millisecond)
+	f.enqueue("r1", 3)
+	waitForStatus(t, f.store, "r1", q.StatusRunning, time.Second)
+
+	rr, body := f.cancel("r1")
+	if rr.Code != http.StatusAccepted || body["cancel_requested"] != true {
+		t.Fatalf("expected 202 with cancel_requested, got %d %s", rr.Code, rr.Body.String())
+	}
+	got := waitForStatus(t, f.store, "r1", q.StatusCanceled, time.Second)
+	if calls.Load() != 1 {
+		t.Fatalf("canceled task must not be retried, got %d calls", calls.Load())
+	}
+	if len(got.Attempts) != 1 || got.Attempts[0].Outcome != q.OutcomeCanceled {
+		t.Fatalf("expected one canceled attempt, got %+v", got.Attempts)
+	}
+	if f.dlq.Len() != 0 {
+		t.Fatal("canceled task must not be dead-lettered")
+	}
+	if m := f.store.GetMetrics(); m.Canceled != 1 || m.Running != 0 {
+		t.Fatalf("unexpected metrics: %+v", m)
+	}
+
+	// finished tasks cannot be canceled
+	if rr, body := f.cancel("r1"); rr.Code != http.StatusConflict || body["error"] != "task_finished" {
+		t.Fatalf("expected 409 task_finished, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr, _ := f.cancel("missing"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown task, got %d", rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	f.h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/tasks/r1/cancel", nil))
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for GET, got %d", rr.Code)
+	}
+}
+
+func TestCancel_DuringBackoffAndIgnoredCancel(t *testing.T) {
+	f := newCancelFixture()
+	var calls atomic.Int32
+	release := make(chan struct{})
+	f.start(t, q.HandlerFunc(func(_ context.Context, task q.Task) (q.Result, error) {
+		calls.Add(1)
+		if task.ID == "stubborn" {
+			<-release // ignores cancellation and finishes its work
+			return q.Result{}, nil
+		}
+		return q.Result{}, errors.New("downstream unavailable")
+	}), time.Hour)
+
+	f.enqueue("b1", 3)
+	// the task now waits out its backoff outside the worker; canceling drops it at once
+	waitForStatus(t, f.store, "b1", q.StatusRetrying, time.Second)
+	if rr, body := f.cancel("b1"); rr.Code != http.StatusOK || body["status"] != string(q.StatusCanceled) {
+		t.Fatalf("expected 200 canceled, got %d %s", rr.Code, rr.Body.String())
+	}
+	if got, _ := f.store.Get("b1"); got.Status != q.StatusCanceled || got.NextAttemptAt != nil {
+		t.Fatalf("expected canceled without a pending attempt, got %+v", got)
+	}
+
+	f.enqueue("stubborn", 0)
+	waitForStatus(t, f.store, "stubborn", q.StatusRunning, time.Second)
+	f.cancel("stubborn")
+	close(release)
+	waitForStatus(t, f.store, "stubborn", q.StatusDone, time.Second)
+}
+
+func TestCancel_ScheduledTaskNotReleased(t *testing.T) {
+	f := newCancelFixture()
+	sched := q.NewScheduler(f.store, q.ChanQueue(f.ch))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	sched.Start(ctx, &wg)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	task := q.NewTaskWithID("s1", []byte(`1`), 0)
+	at := time.Now().Add(30 * time.Millisecond)
+	task.RunAt, task.Status = &at, q.StatusScheduled
+	sched.Schedule(f.store.Save(task))
+	if rr, body := f.cancel("s1"); rr.Code != http.StatusOK || body["status"] != string(q.StatusCanceled) {
+		t.Fatalf("expected 200 canceled, got %d %s", rr.Code, rr.Body.String())
+	}
+	time.Sleep(80 * time.Millisecond)
+	if len(f.ch) != 0 || sched.Len() != 0 {
+		t.Fatalf("canceled task must be dropped: queue=%d scheduler=%d", len(f.ch), sched.Len())
+	}
+	if got, _ := f.store.Get("s1"); got.Status != q.StatusCanceled {
+		t.Fatalf("expected canceled, got %s", got.Status)
+	}
+}
+
+func TestCancel_RetryingTaskDropsItsAttemptErrors(t *testing.T) {
+	f := newCancelFixture()
+	f.start(t, q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		return q.Result{}, errors.New("downstream unavailable")
+	}), time.Hour)
+
+	f.enqueue("r1", 3)
+	waitForStatus(t, f.store, "r1", q.StatusRetrying, time.Second)
+	if rr, _ := f.cancel("r1"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// a later task with the same id must not inherit the errors of the canceled one
+	f.enqueue("r1", 0)
+	waitForStatus(t, f.store, "r1", q.StatusFailed, time.Second)
+	dl, ok := f.dlq.Get("r1")
+	if !ok || len(dl.Errors) != 1 {
+		t.Fatalf("expected a dead letter with one attempt error, got %+v", dl)
+	}
+}
+
+func TestCancel_RacingWorkerStartNeverLeavesCompletedTaskCanceled(t *testing.T) {
+	f := newCancelFixture()
+	f.ch = make(chan q.Task, 256)
+	var mu sync.Mutex
+	completed := map[string]bool{}
+	f.start(t, q.HandlerFunc(func(ctx context.Context, task q.Task) (q.Result, error) {
+		select {
+		case <-ctx.Done():
+			return q.Result{}, context.Cause(ctx)
+		case <-time.After(time.Millisecond):
+		}
+		mu.Lock()
+		completed[task.ID] = true
+		mu.Unlock()
+		return q.Result{}, nil
+	}), time.Millisecond)
+
+	ids := make([]string, 200)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("race-%d", i)
+		f.enqueue(ids[i], 0)
+	}
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = f.canceler.Cancel(f.store, id)
+		}()
+	}
+	wg.Wait()
+	for _, id := range ids {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			got, _ := f.store.Get(id)
+			if got.Status.Finished() {
+				mu.Lock()
+				done := completed[id]
+				mu.Unlock()
+				if done && got.Status != q.StatusDone {
+					t.Fatalf("task %s ran to completion but ended %s", id, got.Status)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("task %s stuck in %s", id, got.Status)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
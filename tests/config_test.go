@@ -1,7 +1,9 @@
 package tests
 
 import (
+	"log/slog"
 	"testing"
+	"time"
 
 	cfg "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/config"
 )
@@ -41,3 +43,159 @@ func TestLoadInvalidValuesFallback(t *testing.T) {
 		t.Fatalf("expected default queue size %d on invalid, got %d", cfg.DefaultQueueSize, c.QueueSize)
 	}
 }
+
+func TestLoadDataDir(t *testing.T) {
+	t.Setenv("DATA_DIR", "")
+	if c := cfg.Load(); c.DataDir != "" {
+		t.Fatalf("expected in-memory store by default, got data dir %q", c.DataDir)
+	}
+	t.Setenv("DATA_DIR", "/var/lib/queue")
+	if c := cfg.Load(); c.DataDir != "/var/lib/queue" {
+		t.Fatalf("expected data dir /var/lib/queue, got %q", c.DataDir)
+	}
+}
+
+func TestLoadPriorityAging(t *testing.T) {
+	t.Setenv("PRIORITY_AGING", "30s")
+	if c := cfg.Load(); c.PriorityAging != 30*time.Second {
+		t.Fatalf("expected aging 30s, got %v", c.PriorityAging)
+	}
+	t.Setenv("PRIORITY_AGING", "bogus")
+	if c := cfg.Load(); c.PriorityAging != 0 {
+		t.Fatalf("expected aging disabled on invalid value, got %v", c.PriorityAging)
+	}
+}
+
+func TestLoadQueues(t *testing.T) {
+	t.Setenv("WORKERS", "3")
+	t.Setenv("QUEUE_SIZE", "16")
+	t.Setenv("QUEUES", "")
+	c := cfg.Load()
+	if len(c.Queues) != 1 || c.Queues[0].Name != cfg.DefaultQueueName || c.Queues[0].Size != 16 || c.Queues[0].Workers != 3 {
+		t.Fatalf("expected single default queue from WORKERS/QUEUE_SIZE, got %+v", c.Queues)
+	}
+
+	t.Setenv("QUEUES", "critical:64:8:5:100ms, default:256:4,bulk:1024:2:0,bad:x:1,critical:1:1")
+	c = cfg.Load()
+	if len(c.Queues) != 3 {
+		t.Fatalf("expected 3 queues, got %+v", c.Queues)
+	}
+	crit, def, bulk := c.Queues[0], c.Queues[1], c.Queues[2]
+	if crit.Name != "critical" || crit.Size != 64 || crit.Workers != 8 || crit.MaxRetries != 5 || crit.BackoffBase != 100*time.Millisecond {
+		t.Fatalf("unexpected critical queue: %+v", crit)
+	}
+	if def.Name != "default" || def.Size != 256 || def.Workers != 4 || def.MaxRetries != -1 {
+		t.Fatalf("unexpected default queue: %+v", def)
+	}
+	if bulk.Name != "bulk" || bulk.MaxRetries != 0 {
+		t.Fatalf("unexpected bulk queue: %+v", bulk)
+	}
+}
+
+func TestLoadAttemptHistory(t *testing.T) {
+	t.Setenv("ATTEMPT_HISTORY", "")
+	if c := cfg.Load(); c.AttemptHistory != cfg.DefaultAttemptHistory {
+		t.Fatalf("expected default history %d, got %d", cfg.DefaultAttemptHistory, c.AttemptHistory)
+	}
+	t.Setenv("ATTEMPT_HISTORY", "25")
+	if c := cfg.Load(); c.AttemptHistory != 25 {
+		t.Fatalf("expected history 25, got %d", c.AttemptHistory)
+	}
+	t.Setenv("ATTEMPT_HISTORY", "0")
+	if c := cfg.Load(); c.AttemptHistory != cfg.DefaultAttemptHistory {
+		t.Fatalf("expected default history on invalid value, got %d", c.AttemptHistory)
+	}
+}
+
+func TestLoadResultLimits(t *testing.T) {
+	t.Setenv("RESULT_MAX_BYTES", "")
+	t.Setenv("RESULT_TTL", "")
+	c := cfg.Load()
+	if c.ResultMaxBytes != cfg.DefaultResultMaxBytes || c.ResultTTL != cfg.DefaultResultTTL {
+		t.Fatalf("unexpected defaults: max=%d ttl=%v", c.ResultMaxBytes, c.ResultTTL)
+	}
+	t.Setenv("RESULT_MAX_BYTES", "4096")
+	t.Setenv("RESULT_TTL", "0")
+	c = cfg.Load()
+	if c.ResultMaxBytes != 4096 || c.ResultTTL != 0 {
+		t.Fatalf("unexpected overrides: max=%d ttl=%v", c.ResultMaxBytes, c.ResultTTL)
+	}
+}
+
+func TestLoadMaxTaskTimeout(t *testing.T) {
+	t.Setenv("MAX_TASK_TIMEOUT", "")
+	if c := cfg.Load(); c.MaxTaskTimeout != 0 || cfg.DefaultMaxTaskTimeout != 0 {
+		t.Fatalf("expected no cap by default, got %v", c.MaxTaskTimeout)
+	}
+	t.Setenv("MAX_TASK_TIMEOUT", "90s")
+	if c := cfg.Load(); c.MaxTaskTimeout != 90*time.Second {
+		t.Fatalf("expected cap 90s, got %v", c.MaxTaskTimeout)
+	}
+	t.Setenv("MAX_TASK_TIMEOUT", "0")
+	if c := cfg.Load(); c.MaxTaskTimeout != 0 {
+		t.Fatalf("expected disabled cap, got %v", c.MaxTaskTimeout)
+	}
+}
+
+func TestLoadRetryPolicy(t *testing.T) {
+	c := cfg.Load()
+	if c.RetryStrategy != cfg.DefaultRetryStrategy || c.RetryBase != cfg.DefaultRetryBase || c.RetryMaxDelay != cfg.DefaultRetryMaxDelay {
+		t.Fatalf("unexpected default retry policy: %s %v %v", c.RetryStrategy, c.RetryBase, c.RetryMaxDelay)
+	}
+	t.Setenv("RETRY_STRATEGY", "decorrelated_jitter")
+	t.Setenv("RETRY_BASE", "1s")
+	t.Setenv("RETRY_JITTER", "0")
+	t.Setenv("RETRY_MAX_DELAY", "30s")
+	c = cfg.Load()
+	if c.RetryStrategy != "decorrelated_jitter" || c.RetryBase != time.Second || c.RetryJitter != 0 || c.RetryMaxDelay != 30*time.Second {
+		t.Fatalf("unexpected retry policy: %s %v %v %v", c.RetryStrategy, c.RetryBase, c.RetryJitter, c.RetryMaxDelay)
+	}
+	t.Setenv("RETRY_STRATEGY", "random")
+	t.Setenv("RETRY_BASE", "-1s")
+	t.Setenv("RETRY_MAX_DELAY", "500ms")
+	c = cfg.Load()
+	if c.RetryStrategy != cfg.DefaultRetryStrategy || c.RetryBase != cfg.DefaultRetryBase || c.RetryMaxDelay != 500*time.Millisecond {
+		t.Fatalf("invalid values must fall back: %s %v %v", c.RetryStrategy, c.RetryBase, c.RetryMaxDelay)
+	}
+}
+
+func TestLoadIdempotencyWindow(t *testing.T) {
+	if c := cfg.Load(); c.IdempotencyWindow != cfg.DefaultIdempotencyWindow {
+		t.Fatalf("unexpected default window %v", c.IdempotencyWindow)
+	}
+	t.Setenv("IDEMPOTENCY_WINDOW", "15m")
+	if c := cfg.Load(); c.IdempotencyWindow != 15*time.Minute {
+		t.Fatalf("unexpected window %v", c.IdempotencyWindow)
+	}
+	t.Setenv("IDEMPOTENCY_WINDOW", "0")
+	if c := cfg.Load(); c.IdempotencyWindow != cfg.DefaultIdempotencyWindow {
+		t.Fatalf("a zero window must fall back, got %v", c.IdempotencyWindow)
+	}
+}
+
+func TestLoadLogging(t *testing.T) {
+	if c := cfg.Load(); c.LogFormat != "text" || c.LogLevel != slog.LevelInfo {
+		t.Fatalf("unexpected defaults %q %v", c.LogFormat, c.LogLevel)
+	}
+	t.Setenv("LOG_FORMAT", "JSON")
+	t.Setenv("LOG_LEVEL", "debug")
+	if c := cfg.Load(); c.LogFormat != "json" || c.LogLevel != slog.LevelDebug {
+		t.Fatalf("unexpected logging %q %v", c.LogFormat, c.LogLevel)
+	}
+	t.Setenv("LOG_FORMAT", "xml")
+	t.Setenv("LOG_LEVEL", "loud")
+	if c := cfg.Load(); c.LogFormat != "text" || c.LogLevel != slog.LevelInfo {
+		t.Fatalf("invalid values must fall back, got %q %v", c.LogFormat, c.LogLevel)
+	}
+}
+
+func TestLoadTraceFile(t *testing.T) {
+	t.Setenv("TRACE_FILE", "")
+	if c := cfg.Load(); c.TraceFile != "" {
+		t.Fatalf("expected span export off by default, got %q", c.TraceFile)
+	}
+	t.Setenv("TRACE_FILE", "/tmp/spans.jsonl")
+	if c := cfg.Load(); c.TraceFile != "/tmp/spans.jsonl" {
+		t.Fatalf("expected TRACE_FILE to be used, got %q", c.TraceFile)
+	}
+}
@@ -0,0 +1,317 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/optongroup/kaspersky-safeboard-go-container-security/internal/cron"
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func mustTime(t *testing.T, s string) time.Time {
+	t.Helper()
+	v, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatalf("bad time %q: %v", s, err)
+	}
+	return v
+}
+
+func TestCronParse_Next(t *testing.T) {
+	cases := []struct {
+		spec, from, want string
+	}{
+		{"* * * * *", "2025-01-01T10:00:30Z", "2025-01-01T10:01:00Z"},
+		{"*/15 * * * *", "2025-01-01T10:01:00Z", "2025-01-01T10:15:00Z"},
+		{"0 2 * * *", "2025-01-01T02:00:00Z", "2025-01-02T02:00:00Z"},
+		{"30 9 * * mon-fri", "2025-01-03T10:00:00Z", "2025-01-06T09:30:00Z"}, // Fri -> Mon
+		{"0 0 1 jan,jul *", "2025-02-10T00:00:00Z", "2025-07-01T00:00:00Z"},
+		{"0 0 29 2 *", "2025-01-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"0 12 13 * 5", "2025-01-01T00:00:00Z", "2025-01-03T12:00:00Z"}, // dom OR dow: Friday Jan 3
+		{"0 0 * * 7", "2025-01-01T00:00:00Z", "2025-01-05T00:00:00Z"},   // 7 is Sunday
+		{"5-10/5 * * * *", "2025-01-01T00:06:00Z", "2025-01-01T00:10:00Z"},
+		{"@daily", "2025-01-01T12:00:00Z", "2025-01-02T00:00:00Z"},
+	}
+	for _, tc := range cases {
+		s, err := cron.Parse(tc.spec)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.spec, err)
+		}
+		got := s.Next(mustTime(t, tc.from))
+		if !got.Equal(mustTime(t, tc.want)) {
+			t.Fatalf("%q from %s: expected %s, got %s", tc.spec, tc.from, tc.want, got)
+		}
+	}
+}
+
+func TestCronParse_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "* * * foo *"} {
+		if _, err := cron.Parse(spec); !errors.Is(err, cron.ErrInvalidSpec) {
+			t.Fatalf("expected ErrInvalidSpec for %q, got %v", spec, err)
+		}
+	}
+}
+
+func TestCronManager_FiresAndPreventsOverlap(t *testing.T) {
+	store := q.NewStore()
+	ch := make(chan q.Task, 4)
+	now := mustTime(t, "2025-01-01T10:00:00Z")
+	mgr, err := cron.NewManager(store, q.ChanQueue(ch), cron.Options{Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	job, err := mgr.Add(cron.Job{ID: "rescan", Schedule: "*/5 * * * *", Type: "image_scan", Payload: json.RawMessage(`{"all":true}`)})
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if !job.NextRun.Equal(mustTime(t, "2025-01-01T10:05:00Z")) {
+		t.Fatalf("unexpected next run: %s", job.NextRun)
+	}
+
+	mgr.Tick(mustTime(t, "2025-01-01T10:04:59Z"))
+	if len(ch) != 0 {
+		t.Fatal("job fired before its time")
+	}
+	mgr.Tick(mustTime(t, "2025-01-01T10:05:00Z"))
+	first := <-ch
+	if first.Type != "image_scan" || string(first.Payload) != `{"all":true}` {
+		t.Fatalf("unexpected materialised task: %+v", first)
+	}
+	if got, _ := mgr.Get("rescan"); got.LastTaskID != first.ID || !got.NextRun.Equal(mustTime(t, "2025-01-01T10:10:00Z")) {
+		t.Fatalf("unexpected job state: %+v", got)
+	}
+
+	// previous run still running: the next fire is skipped
+	store.UpdateStatus(first.ID, q.StatusRunning, 0)
+	mgr.Tick(mustTime(t, "2025-01-01T10:10:00Z"))
+	if len(ch) != 0 {
+		t.Fatal("overlapping run must be skipped")
+	}
+	if got, _ := mgr.Get("rescan"); got.SkippedRuns != 1 {
+		t.Fatalf("expected 1 skipped run, got %d", got.SkippedRuns)
+	}
+
+	store.UpdateStatus(first.ID, q.StatusDone, 0)
+	mgr.Tick(mustTime(t, "2025-01-01T10:15:00Z"))
+	if len(ch) != 1 {
+		t.Fatal("job must fire once the previous run finished")
+	}
+}
+
+func TestCronManager_MissedFiresPolicy(t *testing.T) {
+	dir := t.TempDir()
+	state := filepath.Join(dir, "cron.json")
+	start := mustTime(t, "2025-01-01T10:00:00Z")
+	clock := start
+	now := func() time.Time { return clock }
+
+	mgr, err := cron.NewManager(q.NewStore(), q.NewPriorityQueue(1, 0), cron.Options{StatePath: state, Now: now})
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	if _, err := mgr.Add(cron.Job{ID: "skip", Schedule: "0 * * * *", MissedPolicy: cron.MissedSkip}); err != nil {
+		t.Fatalf("add skip: %v", err)
+	}
+	if _, err := mgr.Add(cron.Job{ID: "catch", Schedule: "0 * * * *", MissedPolicy: cron.MissedCatchUp}); err != nil {
+		t.Fatalf("add catch: %v", err)
+	}
+
+	// service is down for three hours
+	clock = start.Add(3*time.Hour + 30*time.Minute)
+	ch := make(chan q.Task, 10)
+	restarted, err := cron.NewManager(q.NewStore(), q.ChanQueue(ch), cron.Options{StatePath: state, Now: now})
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got, _ := restarted.Get("skip"); !got.NextRun.Equal(mustTime(t, "2025-01-01T14:00:00Z")) {
+		t.Fatalf("skip policy must move next run past now, got %s", got.NextRun)
+	}
+	restarted.Tick(clock)
+	if len(ch) != 1 {
+		t.Fatalf("catch_up must run exactly once after downtime, got %d runs", len(ch))
+	}
+	if tk := <-ch; tk.ID == "" {
+		t.Fatal("expected materialised task")
+	}
+	if got, _ := restarted.Get("catch"); !got.NextRun.Equal(mustTime(t, "2025-01-01T14:00:00Z")) {
+		t.Fatalf("unexpected next run after catch up: %s", got.NextRun)
+	}
+}
+
+func TestCronHTTP_CRUD(t *testing.T) {
+	store := q.NewStore()
+	ch := make(chan q.Task, 1)
+	mgr, err := cron.NewManager(store, q.ChanQueue(ch), cron.Options{})
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	reg := q.NewRegistry()
+	reg.Register("cleanup", noopHandler())
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queue: q.ChanQueue(ch), Accepting: &acc, Registry: reg, Cron: mgr})
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader([]byte(body)))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do(http.MethodPost, "/cron", `{"id":"c1","schedule":"0 3 * * *","type":"cleanup"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("create: expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodPost, "/cron", `{"id":"c1","schedule":"0 3 * * *","type":"cleanup"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("duplicate: expected 400, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPost, "/cron", `{"schedule":"61 * * * *","type":"cleanup"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("bad spec: expected 400, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPost, "/cron", `{"schedule":"* * * * *","type":"unknown"}`); rr.Code != http.StatusBadRequest {
+		t.Fatalf("unknown type: expected 400, got %d", rr.Code)
+	}
+
+	rr := do(http.MethodPut, "/cron/c1", `{"schedule":"*/10 * * * *","type":"cleanup","missed_policy":"catch_up"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("update: expected 200, got %d", rr.Code)
+	}
+	var job cron.Job
+	if err := json.Unmarshal(rr.Body.Bytes(), &job); err != nil || job.Schedule != "*/10 * * * *" || job.MissedPolicy != cron.MissedCatchUp {
+		t.Fatalf("unexpected updated job: %+v err=%v", job, err)
+	}
+
+	rr = do(http.MethodGet, "/cron", "")
+	var jobs []cron.Job
+	if err := json.Unmarshal(rr.Body.Bytes(), &jobs); err != nil || len(jobs) != 1 {
+		t.Fatalf("list: unexpected %s err=%v", rr.Body.String(), err)
+	}
+	if rr := do(http.MethodGet, "/cron/c1", ""); rr.Code != http.StatusOK {
+		t.Fatalf("get: expected 200, got %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, "/cron/c1", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete: expected 204, got %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/cron/c1", ""); rr.Code != http.StatusNotFound {
+		t.Fatalf("get deleted: expected 404, got %d", rr.Code)
+	}
+	if rr := do(http.MethodPut, "/cron/missing", `{"schedule":"* * * * *","type":"cleanup"}`); rr.Code != http.StatusNotFound {
+		t.Fatalf("update missing: expected 404, got %d", rr.Code)
+	}
+}
+
+func TestCronManager_RunCompletedDuringFireDoesNotBlockTheJob(t *testing.T) {
+	store := q.NewStore()
+	mgr, err := cron.NewManager(store, &instantPusher{store: store}, cron.Options{})
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	if _, err := mgr.Add(cron.Job{ID: "fast", Schedule: "* * * * *", Type: "cleanup", Payload: json.RawMessage(`{}`)}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	at := time.Now().Add(time.Minute)
+	for i := 0; i < 3; i++ {
+		mgr.Tick(at.Add(time.Duration(i) * time.Minute))
+	}
+	job, _ := mgr.Get("fast")
+	if job.SkippedRuns != 0 {
+		t.Fatalf("a run finished before fire returned must not block the next ones, skipped %d", job.SkippedRuns)
+	}
+	if last, _ := store.Get(job.LastTaskID); last.Status != q.StatusDone {
+		t.Fatalf("last run must stay done, got %s", last.Status)
+	}
+	if m := store.GetMetrics(); m != (q.Metrics{Done: 3}) {
+		t.Fatalf("unexpected metrics %+v", m)
+	}
+}
+
+func TestCronManager_ValidatesTypeAndQueue(t *testing.T) {
+	reg := q.NewRegistry()
+	reg.Register("cleanup", noopHandler())
+	queues := q.NewQueueSet([]q.QueueSpec{{Name: "main", Capacity: 4, Workers: 1}, {Name: "bulk", Capacity: 4, Workers: 1}}, 0)
+	mgr, err := cron.NewManager(q.NewStore(), queues, cron.Options{Registry: reg})
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	if _, err := mgr.Add(cron.Job{ID: "t", Schedule: "@hourly", Type: "nope"}); !errors.Is(err, cron.ErrUnknownType) {
+		t.Fatalf("expected ErrUnknownType, got %v", err)
+	}
+	if _, err := mgr.Add(cron.Job{ID: "q", Schedule: "@hourly", Type: "cleanup", Queue: "nope"}); !errors.Is(err, cron.ErrUnknownQueue) {
+		t.Fatalf("expected ErrUnknownQueue, got %v", err)
+	}
+	if _, err := mgr.Update("missing", cron.Job{Schedule: "@hourly", Type: "nope"}); !errors.Is(err, cron.ErrUnknownType) {
+		t.Fatalf("update must validate the type too, got %v", err)
+	}
+	job, err := mgr.Add(cron.Job{ID: "ok", Schedule: "@hourly", Type: "cleanup"})
+	if err != nil || job.Queue != "main" {
+		t.Fatalf("an empty queue must resolve to the default one, got %q %v", job.Queue, err)
+	}
+}
+
+func TestCronManager_RemovedQueueFallsBackToDefault(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "cron.json")
+	before := q.NewQueueSet([]q.QueueSpec{{Name: "main", Capacity: 4, Workers: 1}, {Name: "bulk", Capacity: 4, Workers: 1}}, 0)
+	mgr, err := cron.NewManager(q.NewStore(), before, cron.Options{StatePath: state})
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	if _, err := mgr.Add(cron.Job{ID: "b", Schedule: "@hourly", Queue: "bulk"}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	after := q.NewQueueSet([]q.QueueSpec{{Name: "main", Capacity: 4, Workers: 1}}, 0)
+	restarted, err := cron.NewManager(q.NewStore(), after, cron.Options{StatePath: state})
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if job, _ := restarted.Get("b"); job.Queue != "main" {
+		t.Fatalf("job of a removed queue must move to the default one, got %q", job.Queue)
+	}
+}
+
+func TestCronManager_AppliesTypePolicy(t *testing.T) {
+	reg := q.NewRegistry()
+	retry := &q.RetryPolicy{Strategy: q.RetryFixed, Base: time.Second}
+	reg.RegisterType("scan", noopHandler(), q.TypePolicy{MaxRetries: 3, Timeout: 5 * time.Second, Retry: retry, Unique: true})
+	store := q.NewStore()
+	ch := make(chan q.Task, 4)
+	mgr, err := cron.NewManager(store, q.ChanQueue(ch), cron.Options{Registry: reg})
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	one := 1
+	for _, j := range []cron.Job{
+		{ID: "default", Schedule: "* * * * *", Type: "scan", Payload: json.RawMessage(`{"n":1}`), AllowOverlap: true},
+		{ID: "override", Schedule: "* * * * *", Type: "scan", Payload: json.RawMessage(`{"n":2}`), MaxRetries: &one},
+	} {
+		if _, err := mgr.Add(j); err != nil {
+			t.Fatalf("add %s: %v", j.ID, err)
+		}
+	}
+	at := time.Now().Add(time.Minute)
+	mgr.Tick(at)
+	byJob := map[string]q.Task{}
+	for len(ch) > 0 {
+		task := <-ch
+		byJob[string(task.Payload)] = task
+	}
+	def, over := byJob[`{"n":1}`], byJob[`{"n":2}`]
+	if def.MaxRetries != 3 || def.Timeout != 5*time.Second || def.Retry == nil || *def.Retry != *retry || def.UniqueKey == "" {
+		t.Fatalf("type policy not applied: %+v", def)
+	}
+	if over.MaxRetries != 1 {
+		t.Fatalf("job max_retries must override the policy, got %d", over.MaxRetries)
+	}
+
+	// unique type: even with allow_overlap no second task with the same payload is created
+	mgr.Tick(at.Add(time.Minute))
+	if job, _ := mgr.Get("default"); job.SkippedRuns != 1 || job.LastTaskID != def.ID {
+		t.Fatalf("duplicate run of a unique type must be skipped: %+v", job)
+	}
+}
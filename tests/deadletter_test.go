@@ -0,0 +1,237 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+// buryTasks runs the given tasks through a worker pool whose handler always fails,
+// so each of them ends up in dlq after its retries.
+func buryTasks(t *testing.T, store q.Store, dlq *q.DeadLetterQueue, tasks ...q.Task) {
+	t.Helper()
+	registry := q.NewRegistry()
+	var calls atomic.Int32
+	registry.SetDefault(q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		n := calls.Add(1)
+		return q.Result{}, fmt.Errorf("downstream unavailable (call %d)", n)
+	}))
+	ch := make(chan q.Task, len(tasks))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	q.StartWorkerPool(ctx, &wg, store, q.ChanQueue(ch), q.WorkerConfig{
+		Workers:     2,
+		Registry:    registry,
+		BackoffBase: time.Millisecond,
+		DeadLetters: dlq,
+	})
+	for _, task := range tasks {
+		task.Status = q.StatusQueued
+		store.Save(task)
+		ch <- task
+	}
+	for _, task := range tasks {
+		waitForStatus(t, store, task.ID, q.StatusFailed, 2*time.Second)
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestDeadLetter_KeepsEveryAttemptError(t *testing.T) {
+	store := q.NewStore()
+	dlq, _ := q.NewDeadLetterQueue("")
+	buryTasks(t, store, dlq, q.Task{ID: "dl1", Payload: []byte(`{"k":1}`), MaxRetries: 2})
+
+	dl, ok := dlq.Get("dl1")
+	if !ok {
+		t.Fatal("failed task must be dead-lettered")
+	}
+	if len(dl.Errors) != 3 {
+		t.Fatalf("expected 3 attempt errors, got %+v", dl.Errors)
+	}
+	for i, ae := range dl.Errors {
+		if ae.Attempt != i || ae.Error == "" || ae.StartedAt.IsZero() || ae.FinishedAt.Before(ae.StartedAt) {
+			t.Fatalf("unexpected attempt error %d: %+v", i, ae)
+		}
+	}
+	if dl.Task.Status != q.StatusFailed || dl.Task.Attempt != 2 || !dl.DeadAt.Equal(dl.Errors[2].FinishedAt) {
+		t.Fatalf("unexpected dead letter: %+v", dl)
+	}
+}
+
+func TestDeadLetter_RedriveKeepsStoredHistory(t *testing.T) {
+	store := q.NewStore()
+	dlq, _ := q.NewDeadLetterQueue("")
+	buryTasks(t, store, dlq, q.Task{ID: "dl-live", Payload: []byte(`{}`), MaxRetries: 1})
+
+	// written after the dead letter took its copy of the task
+	now := time.Now().UTC()
+	store.RecordAttempt("dl-live", q.AttemptRecord{Attempt: 2, StartedAt: now, FinishedAt: now, Outcome: q.OutcomeFailed, Error: "late"}, 0)
+	store.SetResult("dl-live", q.TaskResult{Data: []byte(`{"partial":true}`), StoredAt: now})
+
+	full := &instantPusher{store: store, full: true}
+	if _, err := dlq.Redrive(store, full, "dl-live"); !errors.Is(err, q.ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+	if got, _ := store.Get("dl-live"); got.Status != q.StatusFailed || got.LastError != "late" || len(got.Attempts) != 3 {
+		t.Fatalf("a rejected redrive must restore the stored task: %+v", got)
+	}
+
+	ch := make(chan q.Task, 1)
+	if _, err := dlq.Redrive(store, q.ChanQueue(ch), "dl-live"); err != nil {
+		t.Fatalf("redrive: %v", err)
+	}
+	got, _ := store.Get("dl-live")
+	if got.Status != q.StatusQueued || got.Attempt != 0 || got.LastError != "late" || len(got.Attempts) != 3 || got.Result == nil {
+		t.Fatalf("redrive must keep the stored history and result: %+v", got)
+	}
+}
+
+func TestDeadLetter_NoHandlerAndSuccessPaths(t *testing.T) {
+	store := q.NewStore()
+	dlq, _ := q.NewDeadLetterQueue("")
+	registry := q.NewRegistry()
+	var failedOnce atomic.Bool
+	registry.Register("flaky", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		if failedOnce.CompareAndSwap(false, true) {
+			return q.Result{}, errors.New("transient")
+		}
+		return q.Result{}, nil
+	}))
+	ch := make(chan q.Task, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	q.StartWorkerPool(ctx, &wg, store, q.ChanQueue(ch), q.WorkerConfig{Workers: 1, Registry: registry, BackoffBase: time.Millisecond, DeadLetters: dlq})
+	for _, task := range []q.Task{
+		{ID: "ok", Type: "flaky", MaxRetries: 3, Status: q.StatusQueued},
+		{ID: "orphan", Type: "unknown", MaxRetries: 3, Status: q.StatusQueued},
+	} {
+		store.Save(task)
+		ch <- task
+	}
+	waitForStatus(t, store, "ok", q.StatusDone, time.Second)
+	waitForStatus(t, store, "orphan", q.StatusFailed, time.Second)
+	if _, ok := dlq.Get("ok"); ok {
+		t.Fatal("a task that eventually succeeded must not be dead-lettered")
+	}
+	dl, ok := dlq.Get("orphan")
+	if !ok || len(dl.Errors) != 1 || !strings.Contains(dl.Errors[0].Error, q.ErrNoHandler.Error()) {
+		t.Fatalf("task without handler must be dead-lettered after one attempt: %+v", dl)
+	}
+}
+
+func TestDeadLetter_HTTPListRedrivePurge(t *testing.T) {
+	store := q.NewStore()
+	dlq, _ := q.NewDeadLetterQueue("")
+	buryTasks(t, store, dlq,
+		q.Task{ID: "a", Payload: []byte(`1`)},
+		q.Task{ID: "b", Payload: []byte(`2`)},
+		q.Task{ID: "c", Payload: []byte(`3`)},
+		q.Task{ID: "d", Payload: []byte(`4`)},
+	)
+	ch := make(chan q.Task, 2)
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queue: q.ChanQueue(ch), Accepting: &acc, DeadLetters: dlq})
+	do := func(method, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+		return rr
+	}
+
+	rr := do(http.MethodGet, "/deadletters")
+	var list []q.DeadLetter
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil || rr.Code != http.StatusOK || len(list) != 4 {
+		t.Fatalf("unexpected list: %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := do(http.MethodGet, "/deadletters/a"); rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 for get, got %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/deadletters/missing"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown dead letter, got %d", rr.Code)
+	}
+
+	// redrive one: back to queued with reset attempts, metrics follow
+	if rr := do(http.MethodPost, "/deadletters/a/redrive"); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for redrive, got %d: %s", rr.Code, rr.Body.String())
+	}
+	select {
+	case tk := <-ch:
+		if tk.ID != "a" || tk.Attempt != 0 || tk.Status != q.StatusQueued {
+			t.Fatalf("unexpected redriven task: %+v", tk)
+		}
+	default:
+		t.Fatal("redriven task not queued")
+	}
+	if got, _ := store.Get("a"); got.Status != q.StatusQueued || got.Attempt != 0 {
+		t.Fatalf("store not updated on redrive: %+v", got)
+	}
+	if m := store.GetMetrics(); m.Failed != 3 || m.Queued != 1 {
+		t.Fatalf("unexpected metrics after redrive: %+v", m)
+	}
+	if rr := do(http.MethodPost, "/deadletters/a/redrive"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for already redriven task, got %d", rr.Code)
+	}
+
+	// redrive all stops when the queue is full
+	rr = do(http.MethodPost, "/deadletters/redrive")
+	var all struct {
+		Redriven  int `json:"redriven"`
+		Remaining int `json:"remaining"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &all); err != nil || rr.Code != http.StatusOK || all.Redriven != 2 || all.Remaining != 1 {
+		t.Fatalf("unexpected redrive-all response: %d %s", rr.Code, rr.Body.String())
+	}
+	remaining := dlq.List()[0].Task.ID
+	if rr := do(http.MethodPost, "/deadletters/"+remaining+"/redrive"); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when queue is full, got %d", rr.Code)
+	}
+	if got, _ := store.Get(remaining); got.Status != q.StatusFailed {
+		t.Fatalf("task must stay failed when redrive is rejected, got %s", got.Status)
+	}
+
+	if rr := do(http.MethodDelete, "/deadletters/"+remaining); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for purge, got %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, "/deadletters/"+remaining); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for purged dead letter, got %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, "/deadletters"); rr.Code != http.StatusOK || dlq.Len() != 0 {
+		t.Fatalf("expected empty dead-letter queue after purge all, got %d len=%d", rr.Code, dlq.Len())
+	}
+}
+
+func TestDeadLetter_Persisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deadletters.json")
+	dlq, err := q.NewDeadLetterQueue(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	store := q.NewStore()
+	buryTasks(t, store, dlq, q.Task{ID: "p1", Payload: []byte(`{}`), MaxRetries: 1}, q.Task{ID: "p2", Payload: []byte(`{}`)})
+	dlq.Purge("p2")
+
+	reopened, err := q.NewDeadLetterQueue(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if reopened.Len() != 1 {
+		t.Fatalf("expected 1 dead letter after reopen, got %d", reopened.Len())
+	}
+	if dl, ok := reopened.Get("p1"); !ok || len(dl.Errors) != 2 || dl.Task.MaxRetries != 1 {
+		t.Fatalf("unexpected reloaded dead letter: %+v", dl)
+	}
+}
@@ -12,7 +12,7 @@ import (
 	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
 )
 
-func newTestHandler(queueSize int, accepting bool, store *q.Store) (http.Handler, chan q.Task, *atomic.Bool) {
+func newTestHandler(queueSize int, accepting bool, store q.Store) (http.Handler, chan q.Task, *atomic.Bool) {
 	ch := make(chan q.Task, queueSize)
 	var acc atomic.Bool
 	acc.Store(accepting)
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func TestKindOf(t *testing.T) {
+	cause := errors.New("bad input")
+	cases := []struct {
+		err  error
+		want q.ErrorKind
+	}{
+		{cause, q.ErrorRetryable},
+		{q.Permanent(cause), q.ErrorPermanent},
+		{fmt.Errorf("scan: %w", q.Permanent(cause)), q.ErrorPermanent},
+		{q.RetryAfter(cause, time.Second), q.ErrorRetryAfter},
+		{q.Skip(cause), q.ErrorSkip},
+		{fmt.Errorf("dispatch: %w", q.ErrNoHandler), q.ErrorPermanent},
+		{fmt.Errorf("%w: 10 bytes", q.ErrResultTooLarge), q.ErrorPermanent},
+	}
+	for _, c := range cases {
+		if got := q.KindOf(c.err); got != c.want {
+			t.Fatalf("%v: expected %s, got %s", c.err, c.want, got)
+		}
+	}
+	if err := q.Permanent(cause); !errors.Is(err, cause) {
+		t.Fatal("kind wrappers must keep the cause")
+	}
+	if msg := q.RetryAfter(cause, time.Minute).Error(); msg != "bad input (retry after 1m0s)" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+}
+
+func TestTaskError_NilCause(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind q.ErrorKind
+		msg  string
+	}{
+		{q.Permanent(nil), q.ErrorPermanent, "permanent error"},
+		{q.Skip(nil), q.ErrorSkip, "skip error"},
+		{q.RetryAfter(nil, time.Second), q.ErrorRetryAfter, "retry_after error (retry after 1s)"},
+	}
+	for _, c := range cases {
+		if got := c.err.Error(); got != c.msg {
+			t.Fatalf("expected %q, got %q", c.msg, got)
+		}
+		if got := q.KindOf(c.err); got != c.kind {
+			t.Fatalf("%v: expected %s, got %s", c.err, c.kind, got)
+		}
+	}
+}
+
+func TestWorker_ErrorKinds(t *testing.T) {
+	store := q.NewStore()
+	dlq, _ := q.NewDeadLetterQueue("")
+	queues := q.NewQueueSet([]q.QueueSpec{{Name: q.DefaultQueueName, Capacity: 8, Workers: 2, MaxRetries: -1, BackoffBase: time.Millisecond}}, 0)
+	registry := q.NewRegistry()
+	registry.SetDefault(q.HandlerFunc(func(_ context.Context, task q.Task) (q.Result, error) {
+		switch task.ID {
+		case "malformed":
+			return q.Result{}, q.Permanent(errors.New("payload is not an image reference"))
+		case "throttled":
+			return q.Result{}, q.RetryAfter(errors.New("429 from registry"), 10*time.Minute)
+		case "throttled-long":
+			return q.Result{}, q.RetryAfter(errors.New("429 from registry"), 2*time.Hour)
+		default:
+			return q.Result{}, q.Skip(errors.New("image already scanned"))
+		}
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	queues.Start(ctx, &wg, store, q.WorkerConfig{
+		Registry:    registry,
+		DeadLetters: dlq,
+		RetryPolicy: q.RetryPolicy{Strategy: q.RetryFixed, Base: time.Millisecond, MaxDelay: 30 * time.Minute},
+	})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for _, id := range []string{"malformed", "throttled", "throttled-long", "duplicate"} {
+		task := q.NewTaskWithID(id, []byte(`{}`), 5)
+		store.Save(task)
+		queues.TryPush(task)
+	}
+
+	failed := waitForStatus(t, store, "malformed", q.StatusFailed, time.Second)
+	if len(failed.Attempts) != 1 || failed.LastErrorKind != q.ErrorPermanent || failed.Attempts[0].ErrorKind != q.ErrorPermanent {
+		t.Fatalf("permanent error must fail at once: %+v", failed)
+	}
+	if _, ok := dlq.Get("malformed"); !ok {
+		t.Fatal("permanently failed task must be dead-lettered")
+	}
+
+	// the handler's delay replaces the 1ms backoff, bounded by the pool's maximum delay
+	for id, want := range map[string]time.Duration{"throttled": 10 * time.Minute, "throttled-long": 30 * time.Minute} {
+		got := waitForStatus(t, store, id, q.StatusRetrying, time.Second)
+		if got.RetryDelay != want || got.LastErrorKind != q.ErrorRetryAfter || !strings.Contains(got.LastError, "429") {
+			t.Fatalf("%s: expected retry after %v, got %+v", id, want, got)
+		}
+	}
+
+	skipped := waitForStatus(t, store, "duplicate", q.StatusSkipped, time.Second)
+	if len(skipped.Attempts) != 1 || skipped.Attempts[0].Outcome != q.OutcomeSkipped || skipped.LastErrorKind != q.ErrorSkip {
+		t.Fatalf("unexpected skipped task: %+v", skipped)
+	}
+	if _, ok := dlq.Get("duplicate"); ok {
+		t.Fatal("skipped task must not be dead-lettered")
+	}
+	if m := store.GetMetrics(); m.Skipped != 1 || m.Failed != 1 || m.Retrying != 2 {
+		t.Fatalf("unexpected metrics: %+v", m)
+	}
+	if m := queues.Metrics()[q.DefaultQueueName]; m.Skipped != 1 || m.Failed != 1 {
+		t.Fatalf("unexpected queue metrics: %+v", m)
+	}
+}
@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func TestFileStore_ReplayAndRecover(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	queued := fs.Save(q.NewTaskWithID("queued", []byte(`1`), 1))
+	running := fs.Save(q.NewTaskWithID("running", []byte(`2`), 1))
+	done := fs.Save(q.NewTaskWithID("done", []byte(`3`), 1))
+	fs.UpdateStatus(running.ID, q.StatusRunning, 1)
+	fs.UpdateStatus(done.ID, q.StatusDone, 0)
+	// simulate a crash: drop the store without Close so only the log is on disk
+
+	reopened, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	got, ok := reopened.Get(done.ID)
+	if !ok || got.Status != q.StatusDone {
+		t.Fatalf("done task not restored: %+v ok=%v", got, ok)
+	}
+	if got, _ := reopened.Get(running.ID); got.Status != q.StatusQueued || got.Attempt != 1 {
+		t.Fatalf("running task must be reset to queued keeping its attempt, got %s/%d", got.Status, got.Attempt)
+	}
+	rec := reopened.Recovered()
+	if len(rec) != 2 || rec[0].ID != queued.ID || rec[1].ID != running.ID {
+		t.Fatalf("unexpected recovered tasks: %+v", rec)
+	}
+	if string(rec[0].Payload) != `1` {
+		t.Fatalf("payload lost: %s", rec[0].Payload)
+	}
+	m := reopened.GetMetrics()
+	if m.Queued != 2 || m.Running != 0 || m.Done != 1 {
+		t.Fatalf("unexpected metrics after recovery: %+v", m)
+	}
+}
+
+func TestFileStore_SnapshotCompaction(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{SnapshotEvery: 3, NoSync: true})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		fs.Save(q.NewTask(json.RawMessage(`{}`), 0))
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "wal.log")); err != nil || fi.Size() != 0 {
+		t.Fatalf("expected empty wal after close, got %v err=%v", fi, err)
+	}
+	reopened, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if got := reopened.GetMetrics().Queued; got != 10 {
+		t.Fatalf("expected 10 tasks from snapshot, got %d", got)
+	}
+}
+
+func TestFileStore_TornTailIsDiscarded(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	fs.Save(q.NewTaskWithID("a", []byte(`1`), 0))
+	fs.Save(q.NewTaskWithID("b", []byte(`2`), 0))
+
+	// corrupt the last record as if the process died mid-write
+	walPath := filepath.Join(dir, "wal.log")
+	data, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("read wal: %v", err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(walPath, data, 0o644); err != nil {
+		t.Fatalf("write wal: %v", err)
+	}
+
+	reopened, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if _, ok := reopened.Get("a"); !ok {
+		t.Fatal("intact record must be replayed")
+	}
+	if _, ok := reopened.Get("b"); ok {
+		t.Fatal("corrupted record must be discarded")
+	}
+	// the store keeps working after truncation
+	reopened.Save(q.NewTaskWithID("c", []byte(`3`), 0))
+	if _, ok := reopened.Get("c"); !ok {
+		t.Fatal("save after recovery failed")
+	}
+}
+
+// snapshotGen reads the generation of the snapshot in dir.
+func snapshotGen(t *testing.T, dir string) uint64 {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, "snapshot.gob"))
+	if err != nil {
+		t.Fatalf("open snapshot: %v", err)
+	}
+	defer f.Close()
+	var snap struct{ Gen uint64 }
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	return snap.Gen
+}
+
+func openFileStore(t *testing.T, dir string) *q.FileStore {
+	t.Helper()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{NoSync: true})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	return fs
+}
+
+func TestFileStore_RecoversInterruptedCompaction(t *testing.T) {
+	dir := t.TempDir()
+	fs := openFileStore(t, dir)
+	fs.Save(q.NewTaskWithID("a", []byte(`1`), 0))
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// the process dies after a compaction rotated the log but before its snapshot landed
+	fs = openFileStore(t, dir)
+	gen := snapshotGen(t, dir)
+	fs.Save(q.NewTaskWithID("b", []byte(`2`), 0))
+	fs.UpdateStatus("a", q.StatusDone, 0)
+	walPath := filepath.Join(dir, "wal.log")
+	stale, err := os.ReadFile(walPath)
+	if err != nil {
+		t.Fatalf("read wal: %v", err)
+	}
+	if err := os.Rename(walPath, filepath.Join(dir, fmt.Sprintf("wal.%d.log", gen))); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if err := os.WriteFile(walPath, nil, 0o644); err != nil {
+		t.Fatalf("write wal: %v", err)
+	}
+
+	fs = openFileStore(t, dir)
+	if a, _ := fs.Get("a"); a.Status != q.StatusDone {
+		t.Fatalf("rotated log must be replayed, a is %s", a.Status)
+	}
+	if _, ok := fs.Get("b"); !ok {
+		t.Fatal("rotated log must be replayed, b is missing")
+	}
+	fs.UpdateStatus("b", q.StatusDone, 0)
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fmt.Sprintf("wal.%d.log", gen))); !os.IsNotExist(err) {
+		t.Fatalf("a snapshot must drop the logs it covers, got %v", err)
+	}
+
+	// a rotated log older than the snapshot is already folded in and must not be replayed
+	stalePath := filepath.Join(dir, fmt.Sprintf("wal.%d.log", snapshotGen(t, dir)-1))
+	if err := os.WriteFile(stalePath, stale, 0o644); err != nil {
+		t.Fatalf("write stale log: %v", err)
+	}
+	fs = openFileStore(t, dir)
+	defer fs.Close()
+	if b, _ := fs.Get("b"); b.Status != q.StatusDone {
+		t.Fatalf("stale log must not roll b back, got %s", b.Status)
+	}
+	if _, err := os.Stat(stalePath); !os.IsNotExist(err) {
+		t.Fatalf("stale log must be deleted, got %v", err)
+	}
+}
+
+func TestFileStore_ConcurrentWritesDuringCompaction(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{SnapshotEvery: 5, NoSync: true})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				id := fmt.Sprintf("w%d-%d", w, i)
+				fs.Save(q.NewTaskWithID(id, []byte(`{}`), 0))
+				fs.UpdateStatus(id, q.StatusDone, 0)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	reopened := openFileStore(t, dir)
+	defer reopened.Close()
+	if m := reopened.GetMetrics(); m != (q.Metrics{Done: 400}) {
+		t.Fatalf("expected 400 done tasks after reopening, got %+v", m)
+	}
+}
@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func waitForStatus(t *testing.T, store q.Store, id string, want q.TaskStatus, timeout time.Duration) q.Task {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		got, ok := store.Get(id)
+		if ok && got.Status == want {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task %s: expected status %s, got %s (found=%v)", id, want, got.Status, ok)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRegistry_LookupAndDefault(t *testing.T) {
+	reg := q.NewRegistry()
+	if _, ok := reg.Lookup("scan"); ok {
+		t.Fatal("empty registry must not resolve handlers")
+	}
+	_, err := reg.Dispatch(context.Background(), q.Task{Type: "scan"})
+	if !errors.Is(err, q.ErrNoHandler) {
+		t.Fatalf("expected ErrNoHandler, got %v", err)
+	}
+
+	var scanned, fallback atomic.Int32
+	reg.Register("scan", q.HandlerFunc(func(ctx context.Context, _ q.Task) (q.Result, error) {
+		scanned.Add(1)
+		return q.Result{}, nil
+	}))
+	reg.SetDefault(q.HandlerFunc(func(ctx context.Context, _ q.Task) (q.Result, error) {
+		fallback.Add(1)
+		return q.Result{}, nil
+	}))
+	if _, err := reg.Dispatch(context.Background(), q.Task{Type: "scan"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := reg.Dispatch(context.Background(), q.Task{Type: "other"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if scanned.Load() != 1 || fallback.Load() != 1 {
+		t.Fatalf("unexpected dispatch counts: scan=%d default=%d", scanned.Load(), fallback.Load())
+	}
+}
+
+func TestWorkerPool_HandlerErrorRetriedThenFailed(t *testing.T) {
+	store := q.NewStore()
+	ch := make(chan q.Task, 4)
+	var calls atomic.Int32
+	reg := q.NewRegistry()
+	reg.Register("flaky", q.HandlerFunc(func(ctx context.Context, _ q.Task) (q.Result, error) {
+		calls.Add(1)
+		return q.Result{}, errors.New("boom")
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	q.StartWorkerPool(ctx, &wg, store, q.ChanQueue(ch), q.WorkerConfig{Workers: 1, Registry: reg, Seed: 1})
+
+	task := q.NewTask(json.RawMessage(`{}`), 1)
+	task.Type = "flaky"
+	ch <- store.Save(task)
+
+	got := waitForStatus(t, store, task.ID, q.StatusFailed, 2*time.Second)
+	cancel()
+	wg.Wait()
+	if calls.Load() != 2 {
+		t.Fatalf("expected 2 attempts, got %d", calls.Load())
+	}
+	if got.Attempt != 1 {
+		t.Fatalf("expected final attempt 1, got %d", got.Attempt)
+	}
+}
+
+func TestWorkerPool_UnknownTypeFailsWithoutRetry(t *testing.T) {
+	store := q.NewStore()
+	ch := make(chan q.Task, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	q.StartWorkerPool(ctx, &wg, store, q.ChanQueue(ch), q.WorkerConfig{Workers: 1, Registry: q.NewRegistry()})
+
+	task := q.NewTask(json.RawMessage(`{}`), 3)
+	task.Type = "missing"
+	ch <- store.Save(task)
+
+	got := waitForStatus(t, store, task.ID, q.StatusFailed, time.Second)
+	cancel()
+	wg.Wait()
+	if got.Attempt != 0 {
+		t.Fatalf("expected no retries for unknown type, got attempt %d", got.Attempt)
+	}
+}
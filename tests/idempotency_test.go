@@ -0,0 +1,266 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func newDedupeHandler(store q.Store, registry *q.Registry, window time.Duration) http.Handler {
+	var acc atomic.Bool
+	acc.Store(true)
+	return httpserver.NewHandlerWithOptions(httpserver.Options{
+		Store:             store,
+		Queue:             q.NewPriorityQueue(64, 0),
+		Accepting:         &acc,
+		Registry:          registry,
+		IdempotencyWindow: window,
+	})
+}
+
+func postWithKey(h http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/enqueue", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestEnqueue_IdempotencyKey(t *testing.T) {
+	store := q.NewStore()
+	h := newDedupeHandler(store, nil, time.Hour)
+
+	if rr := postWithKey(h, "order-1", `{"id":"t1","payload":{"n":1}}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d %s", rr.Code, rr.Body.String())
+	}
+	// a client retry, even with a fresh id, gets the original task back
+	rr := postWithKey(h, "order-1", `{"id":"t1-retry","payload":{"n":1}}`)
+	var resp struct {
+		ID       string `json:"id"`
+		Status   string `json:"status"`
+		Replayed bool   `json:"replayed"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusOK || resp.ID != "t1" || resp.Status != "queued" || !resp.Replayed || rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("expected replay of t1, got %d %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := store.Get("t1-retry"); ok {
+		t.Fatal("a replayed request must not create a task")
+	}
+	// the body field works the same; the key reports the task's current status
+	store.UpdateStatus("t1", q.StatusDone, 0)
+	rr = postWithKey(h, "", `{"id":"t1-again","payload":{"n":1},"idempotency_key":"order-1"}`)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"status":"done"`) {
+		t.Fatalf("expected replay with status done, got %d %s", rr.Code, rr.Body.String())
+	}
+	// without a key the id check still applies
+	if rr := postWithKey(h, "", `{"id":"t1","payload":{}}`); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "duplicate_id") {
+		t.Fatalf("expected duplicate_id, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	// keys older than the window are forgotten
+	old := q.NewTaskWithID("old", []byte(`{}`), 0)
+	old.IdempotencyKey = "order-0"
+	old.CreatedAt = time.Now().Add(-2 * time.Hour)
+	store.Save(old)
+	if rr := postWithKey(h, "order-0", `{"id":"new","payload":{}}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected a new task after the window, got %d %s", rr.Code, rr.Body.String())
+	}
+	if task, ok := store.FindByIdempotencyKey("order-0", time.Time{}); !ok || task.ID != "new" {
+		t.Fatalf("the key must point at the newest task, got %+v", task)
+	}
+
+	if rr := postWithKey(h, strings.Repeat("k", 256), `{"id":"long","payload":{}}`); rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_idempotency_key") {
+		t.Fatalf("expected invalid_idempotency_key, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestEnqueue_IdempotencyKeyConcurrent(t *testing.T) {
+	store := q.NewStore()
+	h := newDedupeHandler(store, nil, 0)
+	var (
+		wg      sync.WaitGroup
+		created atomic.Int32
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rr := postWithKey(h, "same", fmt.Sprintf(`{"id":"c%d","payload":{}}`, i))
+			if rr.Code == http.StatusAccepted {
+				created.Add(1)
+			} else if rr.Code != http.StatusOK {
+				t.Errorf("unexpected %d %s", rr.Code, rr.Body.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+	if created.Load() != 1 || store.GetMetrics().Queued != 1 {
+		t.Fatalf("expected exactly one task, got %d created, metrics %+v", created.Load(), store.GetMetrics())
+	}
+}
+
+func TestEnqueue_UniqueTasks(t *testing.T) {
+	store := q.NewStore()
+	registry := q.NewRegistry()
+	noop := q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) { return q.Result{}, nil })
+	registry.Register("scan", noop)
+	registry.RegisterType("digest", noop, q.TypePolicy{Unique: true})
+	h := newDedupeHandler(store, registry, 0)
+
+	if rr := postEnqueue(h, `{"id":"s1","type":"scan","payload":{"image":"nginx"},"unique":true}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr := postEnqueue(h, `{"id":"s2","type":"scan","payload":{"image":"nginx"},"unique":true}`)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), `"existing_id":"s1"`) || !strings.Contains(rr.Body.String(), "duplicate_task") {
+		t.Fatalf("expected 409 duplicate_task, got %d %s", rr.Code, rr.Body.String())
+	}
+	// other payloads, other types and non-unique requests are not affected
+	for _, body := range []string{
+		`{"id":"s3","type":"scan","payload":{"image":"redis"},"unique":true}`,
+		`{"id":"s4","type":"digest","payload":{"image":"nginx"}}`,
+		`{"id":"s5","type":"scan","payload":{"image":"nginx"}}`,
+	} {
+		if rr := postEnqueue(h, body); rr.Code != http.StatusAccepted {
+			t.Fatalf("%s: expected 202, got %d %s", body, rr.Code, rr.Body.String())
+		}
+	}
+	// the type policy makes digest tasks unique without the flag
+	if rr := postEnqueue(h, `{"id":"s6","type":"digest","payload":{"image":"nginx"}}`); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 for a unique type, got %d %s", rr.Code, rr.Body.String())
+	}
+	// running still blocks; a finished task releases the key
+	store.UpdateStatus("s1", q.StatusRunning, 0)
+	if rr := postEnqueue(h, `{"id":"s7","type":"scan","payload":{"image":"nginx"},"unique":true}`); rr.Code != http.StatusConflict {
+		t.Fatalf("expected 409 while running, got %d", rr.Code)
+	}
+	store.UpdateStatus("s1", q.StatusFailed, 0)
+	if rr := postEnqueue(h, `{"id":"s8","type":"scan","payload":{"image":"nginx"},"unique":true}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 once finished, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestEnqueueBatch_Dedupe(t *testing.T) {
+	store := q.NewStore()
+	h, _, _ := newBatchHandler(store, 8)
+
+	rr := postBatch(h, "", "", `[
+		{"id":"a","payload":{},"idempotency_key":"k1"},
+		{"id":"a2","payload":{},"idempotency_key":"k1"},
+		{"id":"u1","payload":{"x":1},"unique":true},
+		{"id":"u2","payload":{"x":1},"unique":true}
+	]`)
+	var res batchResult
+	_ = json.Unmarshal(rr.Body.Bytes(), &res)
+	if got := itemStatuses(res.Items); got != "queued,queued,queued,duplicate:duplicate_task" || res.Items[1].ID != "a" || res.Accepted != 3 {
+		t.Fatalf("unexpected best-effort results: %s", rr.Body.String())
+	}
+
+	// atomic: a replay is fine, a unique collision inside the batch rejects it
+	rr = postBatch(h, "?mode=atomic", "", `[{"id":"b","payload":{},"idempotency_key":"k1"},{"id":"v1","payload":{"y":1},"unique":true},{"id":"v2","payload":{"y":1},"unique":true}]`)
+	res = batchResult{}
+	_ = json.Unmarshal(rr.Body.Bytes(), &res)
+	if rr.Code != http.StatusBadRequest || itemStatuses(res.Items) != "queued,aborted:batch_rejected,duplicate:duplicate_task" {
+		t.Fatalf("unexpected atomic results: %d %s", rr.Code, rr.Body.String())
+	}
+	if _, ok := store.Get("v1"); ok {
+		t.Fatal("a rejected atomic batch must not store tasks")
+	}
+}
+
+func TestFileStore_DedupeIndexesRestored(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	task := q.NewTaskWithID("p1", []byte(`{"a":1}`), 0)
+	task.IdempotencyKey = "key"
+	task.UniqueKey = q.UniqueKey("scan", task.Payload)
+	fs.Save(task)
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	reopened, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if got, ok := reopened.FindByIdempotencyKey("key", time.Now().Add(-time.Hour)); !ok || got.ID != "p1" {
+		t.Fatalf("idempotency key not restored: %+v", got)
+	}
+	if got, ok := reopened.FindActiveUnique(task.UniqueKey); !ok || got.ID != "p1" {
+		t.Fatalf("unique key not restored: %+v", got)
+	}
+	reopened.UpdateStatus("p1", q.StatusDone, 0)
+	if _, ok := reopened.FindActiveUnique(task.UniqueKey); ok {
+		t.Fatal("a done task must release its unique key")
+	}
+}
+
+// slowUniqueStore widens the window between the unique lookup and the save of a task.
+type slowUniqueStore struct{ *q.MemoryStore }
+
+func (s slowUniqueStore) FindActiveUnique(key string) (q.Task, bool) {
+	t, ok := s.MemoryStore.FindActiveUnique(key)
+	time.Sleep(2 * time.Millisecond)
+	return t, ok
+}
+
+func TestEnqueue_UniqueDefaultTypeConcurrent(t *testing.T) {
+	store := q.NewStore()
+	registry := q.NewRegistry()
+	registry.RegisterType("digest", noopHandler(), q.TypePolicy{Unique: true})
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{
+		Store:       slowUniqueStore{store},
+		Queue:       q.NewPriorityQueue(64, 0),
+		Accepting:   &acc,
+		Registry:    registry,
+		DefaultType: "digest",
+	})
+	var (
+		wg      sync.WaitGroup
+		created atomic.Int32
+	)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// requests without a type get the unique default one; half of them come as atomic batches
+			var rr *httptest.ResponseRecorder
+			if i%2 == 0 {
+				rr = postEnqueue(h, fmt.Sprintf(`{"id":"d%d","payload":{"n":1}}`, i))
+			} else {
+				rr = postBatch(h, "?mode=atomic", "", fmt.Sprintf(`[{"id":"d%d","payload":{"n":1}}]`, i))
+			}
+			switch rr.Code {
+			case http.StatusAccepted, http.StatusOK:
+				if strings.Contains(rr.Body.String(), "duplicate_task") {
+					return
+				}
+				created.Add(1)
+			case http.StatusConflict, http.StatusBadRequest:
+			default:
+				t.Errorf("unexpected %d %s", rr.Code, rr.Body.String())
+			}
+		}(i)
+	}
+	wg.Wait()
+	if created.Load() != 1 || store.GetMetrics().Queued != 1 {
+		t.Fatalf("expected exactly one task, got %d created, metrics %+v", created.Load(), store.GetMetrics())
+	}
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func TestHistogram_Quantile(t *testing.T) {
+	var h q.Histogram
+	if got := h.Snapshot().Quantile(0.5); got != 0 {
+		t.Fatalf("empty histogram: expected 0, got %v", got)
+	}
+	// 90 fast observations in (5ms, 10ms], 10 slow ones in (250ms, 500ms]
+	for i := 0; i < 90; i++ {
+		h.Observe(8 * time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		h.Observe(300 * time.Millisecond)
+	}
+	s := h.Snapshot()
+	if s.Count != 100 || s.Sum != 90*8*time.Millisecond+10*300*time.Millisecond {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+	cases := []struct {
+		p        float64
+		min, max time.Duration
+	}{
+		{0.5, 5 * time.Millisecond, 10 * time.Millisecond},
+		{0.9, 5 * time.Millisecond, 10 * time.Millisecond},
+		{0.99, 250 * time.Millisecond, 500 * time.Millisecond},
+		{1, 500 * time.Millisecond, 500 * time.Millisecond},
+	}
+	for _, c := range cases {
+		if got := s.Quantile(c.p); got < c.min || got > c.max {
+			t.Fatalf("p%v: expected within [%v, %v], got %v", c.p*100, c.min, c.max, got)
+		}
+	}
+	if s.Quantile(0.5) > s.Quantile(0.9) || s.Quantile(0.9) > s.Quantile(0.99) {
+		t.Fatal("quantiles must not decrease")
+	}
+
+	// observations beyond the last bucket report the last bound
+	var slow q.Histogram
+	slow.Observe(time.Hour)
+	if got := slow.Snapshot().Quantile(0.99); got != q.DurationBuckets[len(q.DurationBuckets)-1] {
+		t.Fatalf("expected the last bound, got %v", got)
+	}
+	p := s.Percentiles()
+	if p.Count != 100 || p.Mean < 0.0371 || p.Mean > 0.0373 || p.P50 != s.Quantile(0.5).Seconds() {
+		t.Fatalf("unexpected percentiles %+v", p)
+	}
+}
+
+func TestMetrics_LatencyByType(t *testing.T) {
+	store := q.NewStore()
+	queues := q.NewQueueSet([]q.QueueSpec{
+		{Name: q.DefaultQueueName, Capacity: 8, Workers: 1, MaxRetries: -1},
+		{Name: "bulk", Capacity: 8, Workers: 1, MaxRetries: -1},
+	}, 0)
+	registry := q.NewRegistry()
+	registry.Register("fast", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) { return q.Result{}, nil }))
+	registry.Register("slow", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		time.Sleep(30 * time.Millisecond)
+		return q.Result{}, nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	queues.Start(ctx, &wg, store, q.WorkerConfig{Registry: registry})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queues: queues, Accepting: &acc, Registry: registry})
+
+	for _, body := range []string{
+		`{"id":"f1","type":"fast","payload":{}}`,
+		`{"id":"f2","type":"fast","payload":{},"queue":"bulk"}`,
+		`{"id":"s1","type":"slow","payload":{}}`,
+	} {
+		if rr := postEnqueue(h, body); rr.Code != http.StatusAccepted {
+			t.Fatalf("%s: expected 202, got %d %s", body, rr.Code, rr.Body.String())
+		}
+	}
+	for _, id := range []string{"f1", "f2", "s1"} {
+		waitForStatus(t, store, id, q.StatusDone, 2*time.Second)
+	}
+
+	qm := queues.Metrics()
+	if qm["default"].Types["fast"].Run.Count != 1 || qm["default"].Types["slow"].Run.Count != 1 || qm["bulk"].Types["fast"].Run.Count != 1 {
+		t.Fatalf("unexpected per-type histograms %+v", qm)
+	}
+	if got := qm["default"].Latency.Total.Count; got != 2 {
+		t.Fatalf("queue latency must sum its types, got %d", got)
+	}
+
+	var resp struct {
+		Latency struct {
+			Queues map[string]q.LatencyReport
+			Types  map[string]q.LatencyReport
+		}
+	}
+	rr := getMetrics(h, "", "")
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	fast, slow := resp.Latency.Types["fast"], resp.Latency.Types["slow"]
+	if fast.Run.Count != 2 || fast.Total.Count != 2 || slow.Run.Count != 1 || resp.Latency.Queues["bulk"].Wait.Count != 1 {
+		t.Fatalf("unexpected latency report %s", rr.Body.String())
+	}
+	// the slow handler sleeps 30ms, which falls in the (25ms, 50ms] bucket
+	if slow.Run.P50 < 0.025 || slow.Run.P99 > 0.05 || slow.Total.P50 < slow.Run.P50 {
+		t.Fatalf("unexpected slow percentiles %+v", slow)
+	}
+	if fast.Run.P99 >= slow.Run.P50 {
+		t.Fatalf("fast tasks must report lower latency: fast %+v slow %+v", fast.Run, slow.Run)
+	}
+}
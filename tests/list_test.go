@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+// seedListTasks saves tasks created one minute apart, starting at base.
+func seedListTasks(store q.Store, base time.Time) {
+	for i, spec := range []struct {
+		id, typ, queue string
+		status         q.TaskStatus
+		labels         map[string]string
+	}{
+		{"a", "scan", "default", q.StatusDone, map[string]string{"env": "prod"}},
+		{"b", "scan", "bulk", q.StatusFailed, map[string]string{"env": "prod", "team": "sec"}},
+		{"c", "report", "default", q.StatusQueued, nil},
+		{"d", "scan", "default", q.StatusFailed, map[string]string{"env": "dev"}},
+		{"e", "report", "bulk", q.StatusRetrying, map[string]string{"env": "prod"}},
+		{"f", "scan", "default", q.StatusDone, nil},
+	} {
+		t := q.NewTaskWithID(spec.id, []byte(`{}`), 0)
+		t.Type, t.Queue, t.Status, t.Labels = spec.typ, spec.queue, spec.status, spec.labels
+		t.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		store.Save(t)
+	}
+}
+
+func listTasks(t *testing.T, h http.Handler, query string) ([]string, string) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/tasks?"+query, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("GET /tasks?%s: expected 200, got %d %s", query, rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Tasks      []q.Task `json:"tasks"`
+		NextCursor string   `json:"next_cursor"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid body: %v", err)
+	}
+	ids := make([]string, 0, len(resp.Tasks))
+	for _, task := range resp.Tasks {
+		ids = append(ids, task.ID)
+	}
+	return ids, resp.NextCursor
+}
+
+func TestListTasks_Filters(t *testing.T) {
+	store := q.NewStore()
+	base := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	seedListTasks(store, base)
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queue: q.NewPriorityQueue(4, 0), Accepting: &acc})
+
+	cases := map[string]string{
+		"":                              "a,b,c,d,e,f",
+		"status=failed":                 "b,d",
+		"status=failed,retrying":        "b,d,e",
+		"status=done&status=queued":     "a,c,f",
+		"type=scan&queue=default":       "a,d,f",
+		"label=env=prod":                "a,b,e",
+		"label=env=prod&label=team=sec": "b",
+		"type=scan&label=env=dev":       "d",
+		"queue=missing":                 "",
+		"created_after=" + url.QueryEscape(base.Add(2*time.Minute).Format(time.RFC3339)) +
+			"&created_before=" + url.QueryEscape(base.Add(4*time.Minute).Format(time.RFC3339)): "c,d",
+	}
+	for query, want := range cases {
+		ids, next := listTasks(t, h, query)
+		if strings.Join(ids, ",") != want || next != "" {
+			t.Fatalf("%q: expected [%s], got %v next=%q", query, want, ids, next)
+		}
+	}
+
+	// the status index follows status changes
+	store.UpdateStatus("c", q.StatusRunning, 0)
+	if ids, _ := listTasks(t, h, "status=running"); strings.Join(ids, ",") != "c" {
+		t.Fatalf("expected the running task, got %v", ids)
+	}
+	if ids, _ := listTasks(t, h, "status=queued"); len(ids) != 0 {
+		t.Fatalf("expected no queued task, got %v", ids)
+	}
+	future := url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))
+	if ids, _ := listTasks(t, h, "updated_after="+future); len(ids) != 0 {
+		t.Fatalf("expected nothing updated in the future, got %v", ids)
+	}
+
+	for _, query := range []string{"status=lost", "label=env", "created_after=yesterday", "limit=0", "limit=5000"} {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/tasks?"+query, nil))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_filter") {
+			t.Fatalf("%q: expected 400 invalid_filter, got %d %s", query, rr.Code, rr.Body.String())
+		}
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/tasks?cursor=bogus", nil))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid_cursor") {
+		t.Fatalf("expected 400 invalid_cursor, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestListTasks_CursorPagination(t *testing.T) {
+	store := q.NewStore()
+	base := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	seedListTasks(store, base)
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queue: q.NewPriorityQueue(4, 0), Accepting: &acc})
+
+	var all []string
+	query := "limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination does not terminate")
+		}
+		ids, next := listTasks(t, h, query)
+		all = append(all, ids...)
+		if next == "" {
+			break
+		}
+		// tasks created meanwhile do not shift later pages
+		if pages == 0 {
+			late := q.NewTaskWithID("0-late", []byte(`{}`), 0)
+			late.CreatedAt = base.Add(-time.Hour)
+			store.Save(late)
+		}
+		query = "limit=2&cursor=" + url.QueryEscape(next)
+	}
+	if strings.Join(all, ",") != "a,b,c,d,e,f" {
+		t.Fatalf("unexpected pages: %v", all)
+	}
+
+	// filtered pages walk the index in the same order
+	page, err := store.List(q.TaskFilter{Type: "scan", Limit: 3})
+	if err != nil || len(page.Tasks) != 3 || page.NextCursor == "" {
+		t.Fatalf("unexpected first page: %+v %v", page, err)
+	}
+	page, err = store.List(q.TaskFilter{Type: "scan", Limit: 3, Cursor: page.NextCursor})
+	if err != nil || len(page.Tasks) != 1 || page.Tasks[0].ID != "f" || page.NextCursor != "" {
+		t.Fatalf("unexpected last page: %+v %v", page, err)
+	}
+	if _, err := store.List(q.TaskFilter{Cursor: "%%%"}); !errors.Is(err, q.ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestListTasks_FileStoreIndexRestored(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	base := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	seedListTasks(fs, base)
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	reopened, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	page, err := reopened.List(q.TaskFilter{Labels: map[string]string{"env": "prod"}, Statuses: []q.TaskStatus{q.StatusDone, q.StatusFailed}})
+	if err != nil || len(page.Tasks) != 2 || page.Tasks[0].ID != "a" || page.Tasks[1].ID != "b" {
+		t.Fatalf("index not restored: %+v %v", page.Tasks, err)
+	}
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+// logBuffer collects the output of a JSON slog handler from concurrent goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records returns the logged records whose task_id is id, in order.
+func (b *logBuffer) records(t *testing.T, id string) []map[string]any {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		if rec["task_id"] == id {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// waitFor polls until a record of task id with msg is logged and returns the task's records.
+func (b *logBuffer) waitFor(t *testing.T, id, msg string) []map[string]any {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		recs := b.records(t, id)
+		for _, rec := range recs {
+			if rec["msg"] == msg {
+				return recs
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task %s: %q not logged, got %q", id, msg, messages(recs))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func messages(recs []map[string]any) string {
+	msgs := make([]string, len(recs))
+	for i, rec := range recs {
+		msgs[i] = rec["msg"].(string)
+	}
+	return strings.Join(msgs, ",")
+}
+
+func TestLogging_TaskLifecycle(t *testing.T) {
+	var out logBuffer
+	logger := slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	store := q.NewStore()
+	queues := q.NewQueueSet([]q.QueueSpec{{Name: q.DefaultQueueName, Capacity: 8, Workers: 1, MaxRetries: -1, BackoffBase: time.Millisecond}}, 0)
+	registry := q.NewRegistry()
+	registry.Register("flaky", q.HandlerFunc(func(_ context.Context, task q.Task) (q.Result, error) {
+		if task.Attempt == 0 {
+			return q.Result{}, errors.New("try again")
+		}
+		return q.Result{}, nil
+	}))
+	registry.Register("broken", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		return q.Result{}, q.Permanent(errors.New("malformed payload"))
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	queues.Start(ctx, &wg, store, q.WorkerConfig{Registry: registry, Logger: logger})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queues: queues, Accepting: &acc, Registry: registry, Logger: logger})
+
+	for _, body := range []string{
+		`{"id":"l1","type":"flaky","payload":{},"max_retries":2}`,
+		`{"id":"l2","type":"broken","payload":{},"max_retries":2}`,
+	} {
+		if rr := postEnqueue(h, body); rr.Code != http.StatusAccepted {
+			t.Fatalf("expected 202, got %d %s", rr.Code, rr.Body.String())
+		}
+	}
+	recs := out.waitFor(t, "l1", "task done")
+	if got := messages(recs); got != "task enqueued,task started,task retrying,task started,task done" {
+		t.Fatalf("unexpected lifecycle %q", got)
+	}
+	if recs[0]["task_type"] != "flaky" || recs[0]["queue"] != "default" {
+		t.Fatalf("unexpected enqueue record %v", recs[0])
+	}
+	retry, done := recs[2], recs[4]
+	if retry["level"] != "WARN" || retry["attempt"] != 0.0 || retry["next_attempt"] != 1.0 || retry["err"] != "try again" || retry["delay"] == nil {
+		t.Fatalf("unexpected retry record %v", retry)
+	}
+	for _, rec := range recs[1:] {
+		if rec["worker_id"] != "default-1" || rec["queue"] != "default" || rec["task_type"] != "flaky" {
+			t.Fatalf("worker records must carry the task context, got %v", rec)
+		}
+	}
+	if done["level"] != "INFO" || done["attempt"] != 1.0 || done["duration"] == nil {
+		t.Fatalf("unexpected done record %v", done)
+	}
+
+	recs = out.waitFor(t, "l2", "task failed")
+	if got := messages(recs); got != "task enqueued,task started,task failed" {
+		t.Fatalf("unexpected lifecycle %q", got)
+	}
+	if failed := recs[2]; failed["level"] != "ERROR" || failed["error_kind"] != string(q.ErrorPermanent) || failed["err"] != "malformed payload" {
+		t.Fatalf("unexpected failed record %v", failed)
+	}
+}
+
+func TestLogging_LevelFiltersStart(t *testing.T) {
+	var out logBuffer
+	logger := slog.New(slog.NewJSONHandler(&out, nil))
+	ch := make(chan q.Task, 1)
+	store := q.NewStore()
+	registry := q.NewRegistry()
+	registry.SetDefault(noopHandler())
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	q.StartWorkerPool(ctx, &wg, store, q.ChanQueue(ch), q.WorkerConfig{Workers: 1, Registry: registry, Logger: logger})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	task := q.NewTaskWithID("i1", []byte(`{}`), 0)
+	store.Save(task)
+	ch <- task
+	recs := out.waitFor(t, "i1", "task done")
+	if got := messages(recs); got != "task done" || recs[0]["worker_id"] != "worker-1" {
+		t.Fatalf("at info level only the outcome is logged, got %v", recs)
+	}
+}
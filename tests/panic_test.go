@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func explode(depth int) {
+	if depth == 0 {
+		panic("scanner crashed")
+	}
+	explode(depth - 1)
+}
+
+func TestRegistry_DispatchRecoversPanic(t *testing.T) {
+	registry := q.NewRegistry()
+	registry.Register("boom", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		explode(500)
+		return q.Result{}, nil
+	}))
+	_, err := registry.Dispatch(context.Background(), q.Task{ID: "p", Type: "boom"})
+	var pe *q.PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected *PanicError, got %v", err)
+	}
+	if pe.Value != "scanner crashed" || err.Error() != "panic: scanner crashed" {
+		t.Fatalf("unexpected panic error: %v (%v)", err, pe.Value)
+	}
+	if !strings.Contains(pe.Stack, "explode") || len(pe.Stack) > 8<<10 {
+		t.Fatalf("stack must name the panicking function and stay bounded, got %d bytes", len(pe.Stack))
+	}
+}
+
+func TestWorker_PanicFailsAttemptAndWorkerSurvives(t *testing.T) {
+	store := q.NewStore()
+	queues := q.NewQueueSet([]q.QueueSpec{{Name: q.DefaultQueueName, Capacity: 4, Workers: 1, MaxRetries: -1, BackoffBase: time.Millisecond}}, 0)
+	var calls atomic.Int32
+	registry := q.NewRegistry()
+	registry.Register("flaky", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		if calls.Add(1) == 1 {
+			var m map[string]int
+			m["nil map"]++ // runtime error panic
+		}
+		return q.Result{}, nil
+	}))
+	registry.Register("always", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		panic(errors.New("corrupt archive"))
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	queues.Start(ctx, &wg, store, q.WorkerConfig{Registry: registry})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for _, task := range []q.Task{
+		{ID: "p1", Type: "flaky", MaxRetries: 1, Status: q.StatusQueued},
+		// with a timeout the handler runs in its own goroutine; its panic is recovered too
+		{ID: "p2", Type: "always", Timeout: time.Second, Status: q.StatusQueued},
+		{ID: "p3", Type: "flaky", Status: q.StatusQueued},
+	} {
+		store.Save(task)
+		queues.TryPush(task)
+	}
+	got := waitForStatus(t, store, "p1", q.StatusDone, time.Second)
+	if len(got.Attempts) != 2 || got.Attempts[0].Outcome != q.OutcomePanic || got.Attempts[1].Outcome != q.OutcomeSucceeded {
+		t.Fatalf("expected a panicked attempt followed by a success, got %+v", got.Attempts)
+	}
+	if !strings.Contains(got.Attempts[0].Error, "assignment to entry in nil map") || got.Attempts[0].Stack == "" {
+		t.Fatalf("panic value and stack must be recorded: %+v", got.Attempts[0])
+	}
+	failed := waitForStatus(t, store, "p2", q.StatusFailed, time.Second)
+	if failed.LastError != "panic: corrupt archive" || failed.Attempts[0].Outcome != q.OutcomePanic {
+		t.Fatalf("unexpected failed task: %+v", failed)
+	}
+	// the same single worker keeps processing
+	waitForStatus(t, store, "p3", q.StatusDone, time.Second)
+
+	rr := httptest.NewRecorder()
+	newQueuesHandler(store, queues).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	var m struct {
+		Panics uint64
+		Queues map[string]q.QueueMetrics
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &m); err != nil {
+		t.Fatalf("invalid metrics body: %v", err)
+	}
+	if m.Panics != 2 || m.Queues[q.DefaultQueueName].Panics != 2 {
+		t.Fatalf("unexpected panic counters: %s", rr.Body.String())
+	}
+}
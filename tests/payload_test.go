@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func TestEnqueue_JSONPayloads(t *testing.T) {
+	store := q.NewStore()
+	h, _, _ := newTestHandler(8, true, store)
+
+	cases := []struct{ body, want string }{
+		{`{"id":"obj","payload":{"any":"json","n":[1,2]}}`, `{"any":"json","n":[1,2]}`},
+		{`{"id":"num","payload":42}`, `42`},
+		// strings from older clients: stringified JSON is unwrapped, plain text stays a string
+		{`{"id":"legacy-json","payload":"{\"k\":\"v\"}"}`, `{"k":"v"}`},
+		{`{"id":"legacy-text","payload":"hello"}`, `"hello"`},
+		{`{"id":"explicit","payload":"{\"k\":\"v\"}","payload_encoding":"string"}`, `"{\"k\":\"v\"}"`},
+	}
+	for _, c := range cases {
+		if rr := postEnqueue(h, c.body); rr.Code != http.StatusAccepted {
+			t.Fatalf("%s: expected 202, got %d %s", c.body, rr.Code, rr.Body.String())
+		}
+	}
+	for _, c := range cases {
+		var req struct{ ID string }
+		_ = json.Unmarshal([]byte(c.body), &req)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status/"+req.ID, nil))
+		var got struct {
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil || string(got.Payload) != c.want {
+			t.Fatalf("%s: expected payload %s, got %s (%v)", req.ID, c.want, rr.Body.String(), err)
+		}
+		task, _ := store.Get(req.ID)
+		if data, err := task.PayloadBytes(); err != nil || string(data) != c.want {
+			t.Fatalf("%s: expected handler payload %s, got %s (%v)", req.ID, c.want, data, err)
+		}
+	}
+}
+
+func TestEnqueue_BinaryPayload(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	h, _, _ := newTestHandler(8, true, fs)
+	// "\x00\x01\xffPNG" in base64
+	rr := postEnqueue(h, `{"id":"bin","payload":"AAH/UE5H","payload_encoding":"base64","content_type":"image/png"}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status/bin", nil))
+	if body := rr.Body.String(); !strings.Contains(body, `"payload":"AAH/UE5H"`) || !strings.Contains(body, `"payloadEncoding":"base64"`) || !strings.Contains(body, `"contentType":"image/png"`) {
+		t.Fatalf("unexpected status body: %s", body)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	reopened, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	task, ok := reopened.Get("bin")
+	if !ok {
+		t.Fatal("binary task not persisted")
+	}
+	if data, err := task.PayloadBytes(); err != nil || string(data) != "\x00\x01\xffPNG" || task.ContentType != "image/png" {
+		t.Fatalf("unexpected payload %q %q (%v)", data, task.ContentType, err)
+	}
+
+	var task2 q.Task
+	task2.SetBinaryPayload([]byte("raw"), "")
+	if task2.ContentType != "application/octet-stream" {
+		t.Fatalf("expected default content type, got %q", task2.ContentType)
+	}
+}
+
+func TestEnqueue_InvalidPayload(t *testing.T) {
+	h, _, _ := newTestHandler(8, true, nil)
+	cases := []struct{ body, code string }{
+		{`{"id":"x1"}`, "missing_field"},
+		{`{"id":"x2","payload":null}`, "missing_field"},
+		{`{"id":"x3","payload":"  "}`, "missing_field"},
+		{`{"id":"x4","payload":"%%%","payload_encoding":"base64"}`, "invalid_payload"},
+		{`{"id":"x5","payload":{"a":1},"payload_encoding":"base64"}`, "invalid_payload"},
+		{`{"id":"x6","payload":"p","payload_encoding":"gzip"}`, "invalid_payload"},
+		{`{"id":"x7","payload":{"a":1},"content_type":"text/plain"}`, "invalid_payload"},
+		{`{"id":"x8","payload":{"a":1},"payload_encoding":"string"}`, "invalid_payload"},
+	}
+	for _, c := range cases {
+		rr := postEnqueue(h, c.body)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), c.code) {
+			t.Fatalf("%s: expected 400 %s, got %d %s", c.body, c.code, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func popIDs(t *testing.T, pq q.Queue, n int) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ids := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		tk, ok := pq.Pop(ctx)
+		if !ok {
+			t.Fatalf("pop %d: queue empty", i)
+		}
+		ids = append(ids, tk.ID)
+	}
+	return ids
+}
+
+func TestPriorityQueue_OrderAndFIFOWithinPriority(t *testing.T) {
+	pq := q.NewPriorityQueue(10, 0)
+	for _, spec := range []struct {
+		id       string
+		priority int
+	}{{"bulk-1", 0}, {"bulk-2", 0}, {"urgent-1", 10}, {"low", -5}, {"urgent-2", 10}, {"bulk-3", 0}} {
+		if !pq.TryPush(q.Task{ID: spec.id, Priority: spec.priority}) {
+			t.Fatalf("push %s rejected", spec.id)
+		}
+	}
+	got := fmt.Sprint(popIDs(t, pq, 6))
+	want := fmt.Sprint([]string{"urgent-1", "urgent-2", "bulk-1", "bulk-2", "bulk-3", "low"})
+	if got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestPriorityQueue_CapacityAndClose(t *testing.T) {
+	pq := q.NewPriorityQueue(2, 0)
+	if !pq.TryPush(q.Task{ID: "a"}) || !pq.TryPush(q.Task{ID: "b"}) {
+		t.Fatal("pushes within capacity must succeed")
+	}
+	if pq.TryPush(q.Task{ID: "c", Priority: 100}) {
+		t.Fatal("push over capacity must fail regardless of priority")
+	}
+	if pq.Len() != 2 || pq.Cap() != 2 {
+		t.Fatalf("unexpected len/cap: %d/%d", pq.Len(), pq.Cap())
+	}
+	pq.Close()
+	if pq.TryPush(q.Task{ID: "d"}) {
+		t.Fatal("push after close must fail")
+	}
+	// buffered tasks are still drained after close, then Pop stops
+	popIDs(t, pq, 2)
+	if _, ok := pq.Pop(context.Background()); ok {
+		t.Fatal("pop on closed empty queue must return false")
+	}
+}
+
+func TestPriorityQueue_AgingPreventsStarvation(t *testing.T) {
+	pq := q.NewPriorityQueue(10, 10*time.Millisecond)
+	pq.TryPush(q.Task{ID: "old-low", Priority: 0})
+	time.Sleep(50 * time.Millisecond) // old-low has aged by ~5 levels
+	pq.TryPush(q.Task{ID: "new-mid", Priority: 2})
+	pq.TryPush(q.Task{ID: "new-high", Priority: 50})
+	got := fmt.Sprint(popIDs(t, pq, 3))
+	want := fmt.Sprint([]string{"new-high", "old-low", "new-mid"})
+	if got != want {
+		t.Fatalf("expected %s, got %s", want, got)
+	}
+}
+
+func TestPriorityQueue_PopWaitsForPush(t *testing.T) {
+	pq := q.NewPriorityQueue(1, 0)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		pq.TryPush(q.Task{ID: "late"})
+	}()
+	if ids := popIDs(t, pq, 1); ids[0] != "late" {
+		t.Fatalf("unexpected task %s", ids[0])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok := pq.Pop(ctx); ok {
+		t.Fatal("pop must give up when ctx is done")
+	}
+}
+
+func TestEnqueue_PriorityHonouredAndFull503(t *testing.T) {
+	store := q.NewStore()
+	pq := q.NewPriorityQueue(2, 0)
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queue: pq, Accepting: &acc})
+	for _, body := range []string{
+		`{"id":"bulk","payload":"p"}`,
+		`{"id":"scan","payload":"p","priority":100}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/enqueue", bytes.NewReader([]byte(body)))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("expected 202 for %s, got %d", body, rr.Code)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, "/enqueue", bytes.NewReader([]byte(`{"id":"over","payload":"p","priority":1000}`)))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when full, got %d", rr.Code)
+	}
+	req = httptest.NewRequest(http.MethodPost, "/enqueue", bytes.NewReader([]byte(`{"id":"bad","payload":"p","priority":5000}`)))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for out-of-range priority, got %d", rr.Code)
+	}
+	if ids := popIDs(t, pq, 2); ids[0] != "scan" {
+		t.Fatalf("urgent task must be dequeued first, got %v", ids)
+	}
+	if got, _ := store.Get("scan"); got.Priority != 100 {
+		t.Fatalf("priority not stored: %d", got.Priority)
+	}
+}
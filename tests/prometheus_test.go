@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func getMetrics(h http.Handler, query, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/metrics"+query, nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestMetrics_ContentNegotiation(t *testing.T) {
+	h, _, _ := newBatchHandler(q.NewStore(), 4)
+	const scraper = "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5,*/*;q=0.1"
+	cases := []struct {
+		query, accept string
+		text          bool
+	}{
+		{"", "", false},
+		{"", "*/*", false},
+		{"", "application/json", false},
+		{"", "application/json, text/plain;q=0.5", false},
+		{"", "text/plain", true},
+		{"", scraper, true},
+		{"?format=prometheus", "application/json", true},
+		{"?format=json", scraper, false},
+	}
+	for _, c := range cases {
+		rr := getMetrics(h, c.query, c.accept)
+		ct := rr.Header().Get("Content-Type")
+		if c.text != strings.HasPrefix(ct, "text/plain; version=0.0.4") || (!c.text && ct != "application/json") {
+			t.Fatalf("%q %q: unexpected content type %q", c.query, c.accept, ct)
+		}
+	}
+}
+
+func TestMetrics_PrometheusFormat(t *testing.T) {
+	store := q.NewStore()
+	queues := q.NewQueueSet([]q.QueueSpec{{Name: q.DefaultQueueName, Capacity: 8, Workers: 1, MaxRetries: -1, BackoffBase: time.Millisecond}}, 0)
+	registry := q.NewRegistry()
+	var calls atomic.Int32
+	registry.SetDefault(q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		time.Sleep(20 * time.Millisecond)
+		if calls.Add(1) == 1 {
+			return q.Result{}, errors.New("flaky")
+		}
+		return q.Result{}, nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	queues.Start(ctx, &wg, store, q.WorkerConfig{Registry: registry})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queues: queues, Accepting: &acc, Registry: registry})
+
+	if rr := postEnqueue(h, `{"id":"p1","payload":{},"max_retries":2}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	waitForStatus(t, store, "p1", q.StatusDone, 2*time.Second)
+
+	rr := getMetrics(h, "?format=prometheus", "")
+	body := rr.Body.String()
+	for _, want := range []string{
+		`taskqueue_tasks{status="done"} 1`,
+		`taskqueue_enqueued_total{queue="default"} 1`,
+		`taskqueue_completed_total{queue="default"} 1`,
+		`taskqueue_retried_total{queue="default"} 1`,
+		`taskqueue_failed_total{queue="default"} 0`,
+		`taskqueue_queue_depth{queue="default"} 0`,
+		`taskqueue_running{queue="default"} 0`,
+		`taskqueue_abandoned_handlers{queue="default"} 0`,
+		"# TYPE taskqueue_queue_wait_seconds histogram",
+		`taskqueue_queue_wait_seconds_count{queue="default",type=""} 2`,
+		`taskqueue_attempt_duration_seconds_bucket{queue="default",type="",le="0.01"} 0`,
+		`taskqueue_attempt_duration_seconds_bucket{queue="default",type="",le="+Inf"} 2`,
+		`taskqueue_attempt_duration_seconds_count{queue="default",type=""} 2`,
+		`taskqueue_task_latency_seconds_count{queue="default",type=""} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Fatalf("missing %q in:\n%s", want, body)
+		}
+	}
+
+	sample := regexp.MustCompile(`^[a-z_]+(\{[a-z_]+="[^"]*"(,[a-z_]+="[^"]*")*\})? [0-9.e+-]+$`)
+	declared := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			name := strings.Fields(line)[2]
+			if declared[name] {
+				t.Fatalf("family %s declared twice", name)
+			}
+			declared[name] = true
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		if !sample.MatchString(line) {
+			t.Fatalf("malformed sample line %q", line)
+		}
+	}
+
+	// the JSON form keeps raw histograms out
+	rr = getMetrics(h, "", "")
+	if !strings.Contains(rr.Body.String(), `"Done":1`) || !strings.Contains(rr.Body.String(), `"Retried":1`) || strings.Contains(rr.Body.String(), "Counts") {
+		t.Fatalf("unexpected JSON metrics: %s", rr.Body.String())
+	}
+}
+
+func TestQueueSet_EnqueuedCountsRetriesOnce(t *testing.T) {
+	store := q.NewStore()
+	queues := q.NewQueueSet([]q.QueueSpec{{Name: q.DefaultQueueName, Capacity: 8, Workers: 1, MaxRetries: -1, BackoffBase: time.Millisecond}}, 0)
+	registry := q.NewRegistry()
+	registry.SetDefault(q.HandlerFunc(func(_ context.Context, task q.Task) (q.Result, error) {
+		if task.Attempt < 2 {
+			return q.Result{}, errors.New("try again")
+		}
+		return q.Result{}, nil
+	}))
+	// retries go back through the queue set, as with the shared scheduler of the server
+	sched := q.NewScheduler(store, queues)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	sched.Start(ctx, &wg)
+	queues.Start(ctx, &wg, store, q.WorkerConfig{Registry: registry, Retries: sched})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	task := q.Task{ID: "r", MaxRetries: 3, Status: q.StatusQueued}
+	store.Save(task)
+	queues.TryPush(task)
+	waitForStatus(t, store, "r", q.StatusDone, 2*time.Second)
+
+	if qm := queues.Metrics()[q.DefaultQueueName]; qm.Enqueued != 1 || qm.Retried != 2 {
+		t.Fatalf("a retried task must be counted as enqueued once: %+v", qm)
+	}
+}
@@ -0,0 +1,162 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func newQueuesHandler(store q.Store, queues *q.QueueSet) http.Handler {
+	var acc atomic.Bool
+	acc.Store(true)
+	return httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queues: queues, Accepting: &acc})
+}
+
+func postEnqueue(h http.Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/enqueue", bytes.NewReader([]byte(body)))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestQueueSet_RoutingAndDefault(t *testing.T) {
+	queues := q.NewQueueSet([]q.QueueSpec{
+		{Name: "critical", Capacity: 2, Workers: 1},
+		{Name: q.DefaultQueueName, Capacity: 2, Workers: 1},
+	}, 0)
+	if queues.DefaultName() != q.DefaultQueueName {
+		t.Fatalf("expected default queue, got %s", queues.DefaultName())
+	}
+	if !queues.TryPush(q.Task{ID: "a", Queue: "critical"}) || !queues.TryPush(q.Task{ID: "b"}) {
+		t.Fatal("pushes to declared queues must succeed")
+	}
+	if queues.TryPush(q.Task{ID: "c", Queue: "missing"}) {
+		t.Fatal("push to unknown queue must fail")
+	}
+	crit, _ := queues.Queue("critical")
+	def, _ := queues.Queue(q.DefaultQueueName)
+	if crit.Len() != 1 || def.Len() != 1 {
+		t.Fatalf("unexpected depths: critical=%d default=%d", crit.Len(), def.Len())
+	}
+
+	// without a queue named "default" the first declared queue is the default
+	other := q.NewQueueSet([]q.QueueSpec{{Name: "fast", Capacity: 1, Workers: 1}, {Name: "slow", Capacity: 1, Workers: 1}}, 0)
+	if other.DefaultName() != "fast" {
+		t.Fatalf("expected first queue as default, got %s", other.DefaultName())
+	}
+}
+
+func TestEnqueue_NamedQueues(t *testing.T) {
+	store := q.NewStore()
+	queues := q.NewQueueSet([]q.QueueSpec{
+		{Name: q.DefaultQueueName, Capacity: 4, Workers: 1},
+		{Name: "bulk", Capacity: 1, Workers: 1},
+	}, 0)
+	h := newQueuesHandler(store, queues)
+
+	if rr := postEnqueue(h, `{"id":"b1","payload":"p","queue":"bulk"}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	// bulk is full, but the default queue still accepts work
+	if rr := postEnqueue(h, `{"id":"b2","payload":"p","queue":"bulk"}`); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for full bulk queue, got %d", rr.Code)
+	}
+	if rr := postEnqueue(h, `{"id":"d1","payload":"p"}`); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for default queue, got %d", rr.Code)
+	}
+	if got, _ := store.Get("d1"); got.Queue != q.DefaultQueueName {
+		t.Fatalf("task without queue must be stored in default queue, got %q", got.Queue)
+	}
+
+	rr := postEnqueue(h, `{"id":"u1","payload":"p","queue":"nope"}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown queue, got %d", rr.Code)
+	}
+	var resp struct {
+		Error       string   `json:"error"`
+		KnownQueues []string `json:"known_queues"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid error body: %v", err)
+	}
+	if resp.Error != "unknown_queue" || len(resp.KnownQueues) != 2 {
+		t.Fatalf("unexpected error body: %+v", resp)
+	}
+}
+
+func TestQueueSet_IndependentPoolsAndRetryCap(t *testing.T) {
+	store := q.NewStore()
+	queues := q.NewQueueSet([]q.QueueSpec{
+		{Name: "slow", Capacity: 4, Workers: 1, MaxRetries: -1},
+		{Name: "fast", Capacity: 4, Workers: 1, MaxRetries: 0},
+	}, 0)
+	release := make(chan struct{})
+	var failing atomic.Int32
+	registry := q.NewRegistry()
+	registry.Register("block", q.HandlerFunc(func(ctx context.Context, _ q.Task) (q.Result, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return q.Result{}, nil
+	}))
+	registry.Register("fail", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		failing.Add(1)
+		return q.Result{}, errors.New("boom")
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	queues.Start(ctx, &wg, store, q.WorkerConfig{Registry: registry, Seed: 1})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	defer close(release)
+
+	for _, task := range []q.Task{
+		{ID: "s1", Type: "block", Queue: "slow", Status: q.StatusQueued},
+		{ID: "f1", Type: "fail", Queue: "fast", MaxRetries: 3, Status: q.StatusQueued},
+	} {
+		store.Save(task)
+		if !queues.TryPush(task) {
+			t.Fatalf("push %s rejected", task.ID)
+		}
+	}
+	waitForStatus(t, store, "s1", q.StatusRunning, time.Second)
+	// the fast pool keeps working while the slow one is blocked; its cap of 0 retries wins
+	// over the task's own max_retries
+	got := waitForStatus(t, store, "f1", q.StatusFailed, time.Second)
+	if got.Attempt != 0 || failing.Load() != 1 {
+		t.Fatalf("expected no retries, got attempt=%d calls=%d", got.Attempt, failing.Load())
+	}
+
+	m := queues.Metrics()
+	if m["slow"].Running != 1 || m["slow"].Enqueued != 1 || m["slow"].Workers != 1 {
+		t.Fatalf("unexpected slow metrics: %+v", m["slow"])
+	}
+	if m["fast"].Failed != 1 || m["fast"].Running != 0 || m["fast"].Capacity != 4 {
+		t.Fatalf("unexpected fast metrics: %+v", m["fast"])
+	}
+
+	rr := httptest.NewRecorder()
+	newQueuesHandler(store, queues).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	var resp struct {
+		Queues map[string]q.QueueMetrics
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid metrics body: %v", err)
+	}
+	if resp.Queues["fast"].Failed != 1 || resp.Queues["slow"].Running != 1 {
+		t.Fatalf("per-queue metrics missing from /metrics: %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+// runResultTasks processes tasks with handlers returning fixed results and waits for each
+// of them to reach want.
+func runResultTasks(t *testing.T, store q.Store, maxResultSize int, tasks map[string]q.Result, want q.TaskStatus) {
+	t.Helper()
+	registry := q.NewRegistry()
+	var calls atomic.Int32
+	registry.SetDefault(q.HandlerFunc(func(_ context.Context, task q.Task) (q.Result, error) {
+		calls.Add(1)
+		return tasks[task.ID], nil
+	}))
+	ch := make(chan q.Task, len(tasks))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	q.StartWorkerPool(ctx, &wg, store, q.ChanQueue(ch), q.WorkerConfig{Workers: 1, Registry: registry, MaxResultSize: maxResultSize, BackoffBase: time.Millisecond})
+	for id := range tasks {
+		task := q.NewTaskWithID(id, []byte(`{}`), 3)
+		store.Save(task)
+		ch <- task
+	}
+	for id := range tasks {
+		waitForStatus(t, store, id, want, 2*time.Second)
+	}
+	cancel()
+	wg.Wait()
+	if int(calls.Load()) != len(tasks) {
+		t.Fatalf("expected one attempt per task, got %d calls", calls.Load())
+	}
+}
+
+func getResult(h http.Handler, id string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/result/"+id, nil))
+	return rr
+}
+
+func TestResult_StoredAndServed(t *testing.T) {
+	store := q.NewStore()
+	runResultTasks(t, store, 0, map[string]q.Result{
+		"json":  {Data: []byte(`{"verdict":"clean"}`)},
+		"bytes": {ContentType: "text/plain; charset=utf-8", Data: []byte("sha256:abc")},
+		"raw":   {Data: []byte{0xde, 0xad}},
+		"empty": {},
+	}, q.StatusDone)
+	h := httpserver.NewHandlerWithDeps(store, make(chan q.Task, 1), new(atomic.Bool))
+
+	for _, tc := range []struct {
+		id, contentType, body string
+	}{
+		{"json", "application/json", `{"verdict":"clean"}`},
+		{"bytes", "text/plain; charset=utf-8", "sha256:abc"},
+		{"raw", "application/octet-stream", "\xde\xad"},
+	} {
+		rr := getResult(h, tc.id)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != tc.contentType || rr.Body.String() != tc.body {
+			t.Fatalf("%s: unexpected result %d %q %q", tc.id, rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+		}
+	}
+	if rr := getResult(h, "empty"); rr.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for a task without output, got %d", rr.Code)
+	}
+
+	// the status endpoint shows result metadata only
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status/json", nil))
+	var status struct {
+		Result map[string]any `json:"result"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("invalid status body: %v", err)
+	}
+	if status.Result["size"] != float64(19) || status.Result["contentType"] != "application/json" {
+		t.Fatalf("unexpected result metadata: %s", rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), "verdict") {
+		t.Fatalf("status must not embed result data: %s", rr.Body.String())
+	}
+}
+
+func TestResult_TooLargeFailsWithoutRetry(t *testing.T) {
+	store := q.NewStore()
+	runResultTasks(t, store, 8, map[string]q.Result{"big": {Data: []byte("0123456789")}}, q.StatusFailed)
+	got, _ := store.Get("big")
+	if got.Result != nil || got.Attempt != 0 || !strings.Contains(got.LastError, q.ErrResultTooLarge.Error()) {
+		t.Fatalf("unexpected task: %+v", got)
+	}
+}
+
+func TestResult_NotReadyAndUnknown(t *testing.T) {
+	store := q.NewStore()
+	store.Save(q.NewTaskWithID("pending", []byte(`1`), 0))
+	h := httpserver.NewHandlerWithDeps(store, make(chan q.Task, 1), new(atomic.Bool))
+	rr := getResult(h, "pending")
+	var resp struct {
+		Error string `json:"error"`
+	}
+	_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+	if rr.Code != http.StatusConflict || resp.Error != "not_ready" {
+		t.Fatalf("expected 409 not_ready, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := getResult(h, "missing"); rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown task, got %d", rr.Code)
+	}
+}
+
+func TestResult_EvictedAfterTTL(t *testing.T) {
+	store := q.NewStore()
+	for _, id := range []string{"old", "fresh"} {
+		store.Save(q.NewTaskWithID(id, []byte(`1`), 0))
+		store.UpdateStatus(id, q.StatusDone, 0)
+	}
+	store.SetResult("old", q.TaskResult{ContentType: "application/json", Size: 2, Data: []byte(`{}`), StoredAt: time.Now().Add(-time.Hour)})
+	store.SetResult("fresh", q.TaskResult{ContentType: "application/json", Size: 2, Data: []byte(`[]`), StoredAt: time.Now()})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	q.StartResultJanitor(ctx, &wg, store, 30*time.Minute, 5*time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if got, _ := store.Get("old"); got.Result.Evicted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expired result not evicted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+
+	h := httpserver.NewHandlerWithDeps(store, make(chan q.Task, 1), new(atomic.Bool))
+	if rr := getResult(h, "old"); rr.Code != http.StatusGone {
+		t.Fatalf("expected 410 for evicted result, got %d", rr.Code)
+	}
+	if rr := getResult(h, "fresh"); rr.Code != http.StatusOK || rr.Body.String() != `[]` {
+		t.Fatalf("fresh result must be kept, got %d %q", rr.Code, rr.Body.String())
+	}
+	if got, _ := store.Get("old"); got.Status != q.StatusDone || got.Result.Data != nil || got.Result.Size != 2 {
+		t.Fatalf("eviction must keep the task and result metadata: %+v", got)
+	}
+}
+
+func TestFileStore_ResultPersistedAndEvictionLogged(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{NoSync: true})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	for _, id := range []string{"r1", "r2"} {
+		fs.Save(q.NewTaskWithID(id, []byte(`1`), 0))
+		fs.UpdateStatus(id, q.StatusDone, 0)
+	}
+	fs.SetResult("r1", q.TaskResult{ContentType: "text/plain", Size: 3, Data: []byte("abc"), StoredAt: time.Now()})
+	fs.SetResult("r2", q.TaskResult{ContentType: "text/plain", Size: 3, Data: []byte("xyz"), StoredAt: time.Now().Add(-time.Hour)})
+	if n := fs.EvictResults(time.Now().Add(-time.Minute)); n != 1 {
+		t.Fatalf("expected 1 eviction, got %d", n)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	reopened, err := q.OpenFileStore(dir, q.FileStoreOptions{NoSync: true})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if got, _ := reopened.Get("r1"); got.Result == nil || string(got.Result.Data) != "abc" {
+		t.Fatalf("result not recovered: %+v", got.Result)
+	}
+	if got, _ := reopened.Get("r2"); got.Result == nil || !got.Result.Evicted || got.Result.Data != nil {
+		t.Fatalf("eviction not recovered: %+v", got.Result)
+	}
+}
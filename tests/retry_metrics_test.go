@@ -0,0 +1,171 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+// transition is one store call and the per-status counters expected after it.
+type transition struct {
+	name string
+	do   func(s q.Store)
+	want q.Metrics
+}
+
+func metricsTransitions() []transition {
+	return []transition{
+		{"save queued", func(s q.Store) { s.Save(q.NewTaskWithID("a", []byte(`{}`), 2)) },
+			q.Metrics{Queued: 1}},
+		{"start", func(s q.Store) { s.UpdateStatus("a", q.StatusRunning, 0) },
+			q.Metrics{Running: 1}},
+		{"retry", func(s q.Store) { s.ScheduleRetry("a", 1, time.Minute) },
+			q.Metrics{Retrying: 1}},
+		{"retry released", func(s q.Store) { s.UpdateStatus("a", q.StatusQueued, 1) },
+			q.Metrics{Queued: 1}},
+		{"restart", func(s q.Store) { s.UpdateStatus("a", q.StatusRunning, 1) },
+			q.Metrics{Running: 1}},
+		{"done", func(s q.Store) { s.UpdateStatus("a", q.StatusDone, 1) },
+			q.Metrics{Done: 1}},
+
+		{"save scheduled", func(s q.Store) {
+			t := q.NewTaskWithID("b", []byte(`{}`), 0)
+			t.Status = q.StatusScheduled
+			s.Save(t)
+		}, q.Metrics{Scheduled: 1, Done: 1}},
+		{"schedule released", func(s q.Store) { s.UpdateStatus("b", q.StatusQueued, 0) },
+			q.Metrics{Queued: 1, Done: 1}},
+		{"start scheduled", func(s q.Store) { s.UpdateStatus("b", q.StatusRunning, 0) },
+			q.Metrics{Running: 1, Done: 1}},
+		{"failed", func(s q.Store) { s.UpdateStatus("b", q.StatusFailed, 0) },
+			q.Metrics{Done: 1, Failed: 1}},
+
+		{"save and start", func(s q.Store) {
+			s.Save(q.NewTaskWithID("c", []byte(`{}`), 0))
+			s.UpdateStatus("c", q.StatusRunning, 0)
+		}, q.Metrics{Running: 1, Done: 1, Failed: 1}},
+		{"skipped", func(s q.Store) { s.UpdateStatus("c", q.StatusSkipped, 0) },
+			q.Metrics{Done: 1, Failed: 1, Skipped: 1}},
+
+		{"canceled while retrying", func(s q.Store) {
+			s.Save(q.NewTaskWithID("d", []byte(`{}`), 3))
+			s.ScheduleRetry("d", 1, time.Minute)
+			s.UpdateStatus("d", q.StatusCanceled, 1)
+		}, q.Metrics{Done: 1, Failed: 1, Skipped: 1, Canceled: 1}},
+		{"canceled stays canceled", func(s q.Store) {
+			s.UpdateStatus("d", q.StatusQueued, 1)
+			s.ScheduleRetry("d", 2, time.Minute)
+		}, q.Metrics{Done: 1, Failed: 1, Skipped: 1, Canceled: 1}},
+
+		{"repeated status", func(s q.Store) {
+			s.Save(q.NewTaskWithID("e", []byte(`{}`), 3))
+			s.ScheduleRetry("e", 1, time.Minute)
+			s.ScheduleRetry("e", 2, time.Minute)
+			s.UpdateStatus("e", q.StatusQueued, 2)
+			s.UpdateStatus("e", q.StatusQueued, 2)
+		}, q.Metrics{Queued: 1, Done: 1, Failed: 1, Skipped: 1, Canceled: 1}},
+	}
+}
+
+func TestStore_MetricsFollowEveryTransition(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	stores := []struct {
+		name  string
+		store q.Store
+	}{{"memory", q.NewStore()}, {"file", fs}}
+	for _, s := range stores {
+		for _, tr := range metricsTransitions() {
+			tr.do(s.store)
+			if got := s.store.GetMetrics(); got != tr.want {
+				t.Fatalf("%s store, %s: expected %+v, got %+v", s.name, tr.name, tr.want, got)
+			}
+		}
+	}
+	want := fs.GetMetrics()
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	reopened, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if got := reopened.GetMetrics(); got != want {
+		t.Fatalf("restored metrics: expected %+v, got %+v", want, got)
+	}
+}
+
+func TestWorker_RetryCounters(t *testing.T) {
+	store := q.NewStore()
+	queues := q.NewQueueSet([]q.QueueSpec{{Name: q.DefaultQueueName, Capacity: 8, Workers: 2, MaxRetries: -1, BackoffBase: time.Millisecond}}, 0)
+	registry := q.NewRegistry()
+	registry.Register("flaky", q.HandlerFunc(func(_ context.Context, task q.Task) (q.Result, error) {
+		if task.Attempt < 2 {
+			return q.Result{}, errors.New("try again")
+		}
+		return q.Result{}, nil
+	}))
+	registry.Register("broken", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		return q.Result{}, errors.New("always fails")
+	}))
+	registry.Register("invalid", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		return q.Result{}, q.Permanent(errors.New("malformed payload"))
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	queues.Start(ctx, &wg, store, q.WorkerConfig{Registry: registry})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for _, task := range []q.Task{
+		{ID: "flaky", Type: "flaky", MaxRetries: 3, Status: q.StatusQueued},
+		{ID: "broken", Type: "broken", MaxRetries: 1, Status: q.StatusQueued},
+		{ID: "invalid", Type: "invalid", MaxRetries: 3, Status: q.StatusQueued},
+	} {
+		store.Save(task)
+		queues.TryPush(task)
+	}
+	waitForStatus(t, store, "flaky", q.StatusDone, 2*time.Second)
+	waitForStatus(t, store, "broken", q.StatusFailed, 2*time.Second)
+	waitForStatus(t, store, "invalid", q.StatusFailed, 2*time.Second)
+
+	// 3 attempts of flaky, 2 of broken and 1 of invalid; only broken ran out of retries
+	qm := queues.Metrics()[q.DefaultQueueName]
+	if qm.Attempts != 6 || qm.Retried != 3 || qm.Exhausted != 1 || qm.Failed != 2 || qm.Done != 1 || qm.Running != 0 {
+		t.Fatalf("unexpected queue counters %+v", qm)
+	}
+	if m := store.GetMetrics(); m != (q.Metrics{Done: 1, Failed: 2}) {
+		t.Fatalf("unexpected store metrics %+v", m)
+	}
+
+	h := newQueuesHandler(store, queues)
+	var resp struct{ Attempts, Retried, Exhausted uint64 }
+	if err := json.Unmarshal(getMetrics(h, "", "").Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.Attempts != 6 || resp.Retried != 3 || resp.Exhausted != 1 {
+		t.Fatalf("unexpected totals %+v", resp)
+	}
+	body := getMetrics(h, "?format=prometheus", "").Body.String()
+	for _, want := range []string{
+		`taskqueue_attempts_total{queue="default"} 6`,
+		`taskqueue_retried_total{queue="default"} 3`,
+		`taskqueue_retries_exhausted_total{queue="default"} 1`,
+	} {
+		if !strings.Contains(body, want+"\n") {
+			t.Fatalf("missing %q in:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func TestRetryPolicy_Delays(t *testing.T) {
+	cases := []struct {
+		name    string
+		policy  q.RetryPolicy
+		attempt int
+		prev    time.Duration
+		want    time.Duration
+	}{
+		{"fixed", q.RetryPolicy{Strategy: q.RetryFixed, Base: time.Second}, 5, 0, time.Second},
+		{"linear", q.RetryPolicy{Strategy: q.RetryLinear, Base: time.Second}, 3, 0, 3 * time.Second},
+		{"exponential", q.RetryPolicy{Strategy: q.RetryExponential, Base: 100 * time.Millisecond}, 2, 0, 400 * time.Millisecond},
+		{"exponential capped", q.RetryPolicy{Strategy: q.RetryExponential, Base: 100 * time.Millisecond, MaxDelay: 5 * time.Second}, 10, 0, 5 * time.Second},
+		{"exponential saturates", q.RetryPolicy{Strategy: q.RetryExponential, Base: time.Hour, MaxDelay: 24 * time.Hour}, 100, 0, 24 * time.Hour},
+		// without an rng jittered strategies take their upper bound
+		{"fixed with jitter", q.RetryPolicy{Strategy: q.RetryFixed, Base: time.Second, Jitter: time.Second}, 1, 0, 2 * time.Second},
+		{"full jitter bound", q.RetryPolicy{Strategy: q.RetryFullJitter, Base: time.Second}, 3, 0, 8 * time.Second},
+		{"decorrelated bound", q.RetryPolicy{Strategy: q.RetryDecorrelatedJitter, Base: time.Second, MaxDelay: 20 * time.Second}, 4, 10 * time.Second, 20 * time.Second},
+	}
+	for _, c := range cases {
+		if got := c.policy.Delay(c.attempt, c.prev, nil); got != c.want {
+			t.Fatalf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+
+	rng := rand.New(rand.NewSource(7))
+	full := q.RetryPolicy{Strategy: q.RetryFullJitter, Base: time.Second, MaxDelay: 10 * time.Second}
+	decorrelated := q.RetryPolicy{Strategy: q.RetryDecorrelatedJitter, Base: time.Second, MaxDelay: time.Minute}
+	var prev time.Duration
+	for i := 1; i <= 200; i++ {
+		if d := full.Delay(i, 0, rng); d < 0 || d > 10*time.Second {
+			t.Fatalf("full jitter delay out of range: %v", d)
+		}
+		d := decorrelated.Delay(i, prev, rng)
+		if d < time.Second || d > time.Minute || d > 3*max(prev, time.Second) {
+			t.Fatalf("decorrelated delay %v out of range after %v", d, prev)
+		}
+		prev = d
+	}
+}
+
+func TestRetryPolicy_Validate(t *testing.T) {
+	for _, p := range []q.RetryPolicy{
+		{Strategy: "random", Base: time.Second},
+		{Strategy: q.RetryFixed},
+		{Strategy: q.RetryLinear, Base: time.Second, Jitter: -1},
+		{Strategy: q.RetryExponential, Base: time.Minute, MaxDelay: time.Second},
+	} {
+		if err := p.Validate(); !errors.Is(err, q.ErrInvalidRetryPolicy) {
+			t.Fatalf("expected ErrInvalidRetryPolicy for %+v, got %v", p, err)
+		}
+	}
+	if err := q.DefaultRetryPolicy.Validate(); err != nil {
+		t.Fatalf("default policy must be valid: %v", err)
+	}
+}
+
+func TestEnqueue_RetryPolicyField(t *testing.T) {
+	store := q.NewStore()
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queue: q.NewPriorityQueue(8, 0), Accepting: &acc})
+
+	rr := postEnqueue(h, `{"id":"p1","payload":"1","retry":{"strategy":"linear","base":"2s","max_delay":"1m","jitter":"100ms"}}`)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	want := q.RetryPolicy{Strategy: q.RetryLinear, Base: 2 * time.Second, MaxDelay: time.Minute, Jitter: 100 * time.Millisecond}
+	if got, _ := store.Get("p1"); got.Retry == nil || *got.Retry != want {
+		t.Fatalf("retry policy not stored: %+v", got.Retry)
+	}
+	for _, body := range []string{
+		`{"id":"x1","payload":"1","retry":{"strategy":"sometimes","base":"1s"}}`,
+		`{"id":"x2","payload":"1","retry":{"strategy":"fixed"}}`,
+		`{"id":"x3","payload":"1","retry":{"strategy":"fixed","base":"soon"}}`,
+		`{"id":"x4","payload":"1","retry":{"strategy":"exponential","base":"1m","max_delay":"1s"}}`,
+	} {
+		rr := postEnqueue(h, body)
+		var resp struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		if rr.Code != http.StatusBadRequest || resp.Error != "invalid_retry_policy" {
+			t.Fatalf("expected 400 invalid_retry_policy for %s, got %d %s", body, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestWorker_RetryPolicyPrecedence(t *testing.T) {
+	store := q.NewStore()
+	failing := q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		return q.Result{}, errors.New("unavailable")
+	})
+	registry := q.NewRegistry()
+	registry.RegisterType("typed", failing, q.TypePolicy{Retry: &q.RetryPolicy{Strategy: q.RetryFixed, Base: time.Hour}})
+	registry.Register("plain", failing)
+	ch := make(chan q.Task, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	q.StartWorkerPool(ctx, &wg, store, q.ChanQueue(ch), q.WorkerConfig{
+		Workers:     2,
+		Registry:    registry,
+		RetryPolicy: q.RetryPolicy{Strategy: q.RetryLinear, Base: 10 * time.Minute, MaxDelay: 90 * time.Minute},
+	})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	own := &q.RetryPolicy{Strategy: q.RetryFixed, Base: 2 * time.Hour}
+	for _, task := range []q.Task{
+		{ID: "plain", Type: "plain", MaxRetries: 1, Status: q.StatusQueued},
+		{ID: "typed", Type: "typed", MaxRetries: 1, Status: q.StatusQueued},
+		// the task's own policy wins but is still bound by the pool's ceiling
+		{ID: "own", Type: "typed", MaxRetries: 1, Retry: own, Status: q.StatusQueued},
+	} {
+		store.Save(task)
+		ch <- task
+	}
+	for id, want := range map[string]time.Duration{"plain": 10 * time.Minute, "typed": time.Hour, "own": 90 * time.Minute} {
+		got := waitForStatus(t, store, id, q.StatusRetrying, time.Second)
+		if got.RetryDelay != want || got.NextAttemptAt == nil || got.NextAttemptAt.Sub(got.UpdatedAt) != want {
+			t.Fatalf("%s: expected a %v delay, got %v (next attempt %v)", id, want, got.RetryDelay, got.NextAttemptAt)
+		}
+	}
+}
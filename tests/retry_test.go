@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func TestRetry_BackoffDoesNotBlockWorker(t *testing.T) {
+	store := q.NewStore()
+	queues := q.NewQueueSet([]q.QueueSpec{{Name: q.DefaultQueueName, Capacity: 4, Workers: 1, MaxRetries: -1, BackoffBase: time.Hour}}, 0)
+	registry := q.NewRegistry()
+	registry.Register("broken", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		return q.Result{}, errors.New("registry unreachable")
+	}))
+	registry.Register("quick", noopHandler())
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	queues.Start(ctx, &wg, store, q.WorkerConfig{Registry: registry})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for _, task := range []q.Task{
+		{ID: "r1", Type: "broken", MaxRetries: 3, Status: q.StatusQueued},
+		{ID: "r2", Type: "quick", Status: q.StatusQueued},
+	} {
+		store.Save(task)
+		queues.TryPush(task)
+	}
+	waitForStatus(t, store, "r1", q.StatusRetrying, time.Second)
+	// the single worker is not held by the hour-long backoff
+	waitForStatus(t, store, "r2", q.StatusDone, time.Second)
+
+	h := newQueuesHandler(store, queues)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status/r1", nil))
+	var got q.Task
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid status body: %v", err)
+	}
+	if got.Status != q.StatusRetrying || got.Attempt != 1 || got.NextAttemptAt == nil || time.Until(*got.NextAttemptAt) < time.Hour {
+		t.Fatalf("unexpected retrying task: %s", rr.Body.String())
+	}
+	if m := store.GetMetrics(); m.Retrying != 1 || m.Running != 0 {
+		t.Fatalf("unexpected metrics: %+v", m)
+	}
+}
+
+func TestRetry_ReleasedWhenBackoffExpires(t *testing.T) {
+	store := q.NewStore()
+	ch := make(chan q.Task, 4)
+	retries := q.NewScheduler(store, q.ChanQueue(ch))
+	var calls atomic.Int32
+	registry := q.NewRegistry()
+	registry.SetDefault(q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		if calls.Add(1) < 3 {
+			return q.Result{}, errors.New("try again")
+		}
+		return q.Result{}, nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	retries.Start(ctx, &wg)
+	q.StartWorkerPool(ctx, &wg, store, q.ChanQueue(ch), q.WorkerConfig{Workers: 1, Registry: registry, BackoffBase: time.Millisecond, Retries: retries})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	task := q.NewTaskWithID("r1", []byte(`{}`), 5)
+	store.Save(task)
+	ch <- task
+	got := waitForStatus(t, store, "r1", q.StatusDone, 2*time.Second)
+	if got.Attempt != 2 || len(got.Attempts) != 3 || got.NextAttemptAt != nil {
+		t.Fatalf("expected done on the third attempt, got attempt=%d attempts=%d next=%v", got.Attempt, len(got.Attempts), got.NextAttemptAt)
+	}
+	if retries.Len() != 0 || store.GetMetrics().Retrying != 0 {
+		t.Fatalf("no retry must stay pending: scheduler=%d metrics=%+v", retries.Len(), store.GetMetrics())
+	}
+}
+
+func TestRetry_RecoveredFromFileStore(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	fs.Save(q.NewTaskWithID("r1", []byte(`1`), 3))
+	scheduled, ok := fs.ScheduleRetry("r1", 1, time.Minute)
+	if !ok || scheduled.NextAttemptAt == nil || scheduled.RetryDelay != time.Minute {
+		t.Fatalf("schedule retry failed: %+v", scheduled)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	reopened, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	rec := reopened.Recovered()
+	if len(rec) != 1 || rec[0].Status != q.StatusRetrying || rec[0].Attempt != 1 || rec[0].NextAttemptAt == nil || !rec[0].NextAttemptAt.Equal(*scheduled.NextAttemptAt) {
+		t.Fatalf("retrying task not recovered: %+v", rec)
+	}
+
+	// canceled tasks are never put back into retrying
+	reopened.UpdateStatus("r1", q.StatusCanceled, 1)
+	if got, ok := reopened.ScheduleRetry("r1", 2, time.Minute); ok || got.Status != q.StatusCanceled {
+		t.Fatalf("canceled task must stay canceled, got %s", got.Status)
+	}
+}
@@ -0,0 +1,258 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func newSchedulingHandler(store q.Store, ch chan q.Task, sched *q.Scheduler) http.Handler {
+	var acc atomic.Bool
+	acc.Store(true)
+	return httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queue: q.ChanQueue(ch), Accepting: &acc, Scheduler: sched})
+}
+
+func TestScheduler_ReleasesInDueOrder(t *testing.T) {
+	store := q.NewStore()
+	ch := make(chan q.Task, 3)
+	sched := q.NewScheduler(store, q.ChanQueue(ch))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	sched.Start(ctx, &wg)
+
+	now := time.Now()
+	for _, spec := range []struct {
+		id    string
+		after time.Duration
+	}{{"late", 80 * time.Millisecond}, {"early", 20 * time.Millisecond}, {"mid", 50 * time.Millisecond}} {
+		task := q.NewTaskWithID(spec.id, []byte(`1`), 0)
+		at := now.Add(spec.after)
+		task.RunAt = &at
+		task.Status = q.StatusScheduled
+		sched.Schedule(store.Save(task))
+	}
+	if got := store.GetMetrics().Scheduled; got != 3 {
+		t.Fatalf("expected 3 scheduled, got %d", got)
+	}
+	select {
+	case tk := <-ch:
+		t.Fatalf("task %s released too early", tk.ID)
+	case <-time.After(10 * time.Millisecond):
+	}
+	for _, want := range []string{"early", "mid", "late"} {
+		select {
+		case tk := <-ch:
+			if tk.ID != want {
+				t.Fatalf("expected %s, got %s", want, tk.ID)
+			}
+			if got, _ := store.Get(tk.ID); got.Status != q.StatusQueued {
+				t.Fatalf("released task must be queued, got %s", got.Status)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("task %s not released", want)
+		}
+	}
+	if sched.Len() != 0 {
+		t.Fatalf("expected empty scheduler, got %d", sched.Len())
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestEnqueue_DelayedTaskScheduled(t *testing.T) {
+	store := q.NewStore()
+	ch := make(chan q.Task, 1)
+	sched := q.NewScheduler(store, q.ChanQueue(ch))
+	h := newSchedulingHandler(store, ch, sched)
+
+	req := httptest.NewRequest(http.MethodPost, "/enqueue", bytes.NewReader([]byte(`{"id":"d1","payload":"p","delay":"10m"}`)))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Status string     `json:"status"`
+		RunAt  *time.Time `json:"run_at"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	if resp.Status != string(q.StatusScheduled) || resp.RunAt == nil || time.Until(*resp.RunAt) < 9*time.Minute {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if got, _ := store.Get("d1"); got.Status != q.StatusScheduled || got.RunAt == nil {
+		t.Fatalf("unexpected stored task: %+v", got)
+	}
+	if sched.Len() != 1 || len(ch) != 0 {
+		t.Fatalf("task must wait in scheduler, not queue: scheduler=%d queue=%d", sched.Len(), len(ch))
+	}
+
+	// a due time in the past is queued right away
+	past := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	req = httptest.NewRequest(http.MethodPost, "/enqueue", bytes.NewReader([]byte(`{"id":"d2","payload":"p","run_at":"`+past+`"}`)))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted || len(ch) != 1 {
+		t.Fatalf("expected immediate enqueue for past run_at, got %d queue=%d", rr.Code, len(ch))
+	}
+}
+
+func TestEnqueue_InvalidSchedule_400(t *testing.T) {
+	store := q.NewStore()
+	ch := make(chan q.Task, 1)
+	withSched := newSchedulingHandler(store, ch, q.NewScheduler(store, q.ChanQueue(ch)))
+	withoutSched := newSchedulingHandler(store, ch, nil)
+	cases := []struct {
+		h    http.Handler
+		body string
+	}{
+		{withSched, `{"id":"x1","payload":"p","delay":"soon"}`},
+		{withSched, `{"id":"x2","payload":"p","delay":"-1s"}`},
+		{withSched, `{"id":"x3","payload":"p","delay":"1s","run_at":"2030-01-01T00:00:00Z"}`},
+		{withoutSched, `{"id":"x4","payload":"p","delay":"1m"}`},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/enqueue", bytes.NewReader([]byte(tc.body)))
+		rr := httptest.NewRecorder()
+		tc.h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", tc.body, rr.Code)
+		}
+	}
+}
+
+func TestScheduler_ShutdownKeepsScheduledTasks(t *testing.T) {
+	dir := t.TempDir()
+	fs, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	ch := make(chan q.Task, 1)
+	sched := q.NewScheduler(fs, q.ChanQueue(ch))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	sched.Start(ctx, &wg)
+
+	task := q.NewTaskWithID("later", []byte(`1`), 0)
+	at := time.Now().Add(time.Hour).UTC()
+	task.RunAt = &at
+	task.Status = q.StatusScheduled
+	sched.Schedule(fs.Save(task))
+
+	cancel()
+	wg.Wait()
+	if err := fs.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	reopened, err := q.OpenFileStore(dir, q.FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	rec := reopened.Recovered()
+	if len(rec) != 1 || rec[0].Status != q.StatusScheduled || rec[0].RunAt == nil || !rec[0].RunAt.Equal(at) {
+		t.Fatalf("scheduled task not recovered: %+v", rec)
+	}
+}
+
+// queueByName is a Pusher with one buffered channel per queue name.
+type queueByName map[string]chan q.Task
+
+func (p queueByName) TryPush(t q.Task) bool {
+	select {
+	case p[t.Queue] <- t:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestScheduler_FullQueueDoesNotStallOthers(t *testing.T) {
+	store := q.NewStore()
+	queues := queueByName{"full": make(chan q.Task, 1), "free": make(chan q.Task, 1)}
+	queues["full"] <- q.Task{ID: "occupant"}
+	sched := q.NewScheduler(store, queues)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	sched.Start(ctx, &wg)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	now := time.Now()
+	for _, spec := range []struct{ id, queue string }{{"blocked", "full"}, {"other", "free"}} {
+		task := q.NewTaskWithID(spec.id, []byte(`1`), 0)
+		task.Queue, task.Status, task.RunAt = spec.queue, q.StatusScheduled, &now
+		sched.Schedule(store.Save(task))
+	}
+
+	select {
+	case got := <-queues["free"]:
+		if got.ID != "other" {
+			t.Fatalf("unexpected task %s", got.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("a full queue must not hold back tasks of other queues")
+	}
+	if got, _ := store.Get("blocked"); got.Status != q.StatusQueued || sched.Len() != 1 {
+		t.Fatalf("the blocked task must wait in the scheduler as queued: %s, pending %d", got.Status, sched.Len())
+	}
+
+	// once capacity frees up the blocked task is released on its next try
+	<-queues["full"]
+	select {
+	case got := <-queues["full"]:
+		if got.ID != "blocked" {
+			t.Fatalf("unexpected task %s", got.ID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("blocked task was never released")
+	}
+}
+
+func TestScheduler_CanceledWhileWaitingForCapacityIsDropped(t *testing.T) {
+	store := q.NewStore()
+	queues := queueByName{"full": make(chan q.Task, 1)}
+	queues["full"] <- q.Task{ID: "occupant"}
+	sched := q.NewScheduler(store, queues)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	sched.Start(ctx, &wg)
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	now := time.Now()
+	task := q.NewTaskWithID("waiting", []byte(`1`), 0)
+	task.Queue, task.Status, task.RunAt = "full", q.StatusScheduled, &now
+	sched.Schedule(store.Save(task))
+	waitForStatus(t, store, "waiting", q.StatusQueued, time.Second)
+	if _, err := q.NewCanceler(nil).Cancel(store, "waiting"); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	<-queues["full"]
+	deadline := time.Now().Add(2 * time.Second)
+	for sched.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("canceled task must leave the scheduler")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(queues["full"]) != 0 {
+		t.Fatal("canceled task must not be pushed")
+	}
+}
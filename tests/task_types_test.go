@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func noopHandler() q.Handler {
+	return q.HandlerFunc(func(ctx context.Context, _ q.Task) (q.Result, error) { return q.Result{}, nil })
+}
+
+func newTypedHandler(store q.Store, ch chan q.Task, reg *q.Registry) http.Handler {
+	var acc atomic.Bool
+	acc.Store(true)
+	return httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queue: q.ChanQueue(ch), Accepting: &acc, Registry: reg})
+}
+
+func TestEnqueue_UnknownType_StructuredError(t *testing.T) {
+	reg := q.NewRegistry()
+	reg.Register("image_scan", noopHandler())
+	reg.Register("notification", noopHandler())
+	h := newTypedHandler(q.NewStore(), make(chan q.Task, 1), reg)
+
+	req := httptest.NewRequest(http.MethodPost, "/enqueue", bytes.NewReader([]byte(`{"id":"u1","type":"nope","payload":"p"}`)))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+	var body struct {
+		Error      string   `json:"error"`
+		Message    string   `json:"message"`
+		KnownTypes []string `json:"known_types"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("expected JSON error body: %v", err)
+	}
+	if body.Error != "unknown_type" || body.Message == "" {
+		t.Fatalf("unexpected error body: %+v", body)
+	}
+	if len(body.KnownTypes) != 2 || body.KnownTypes[0] != "image_scan" || body.KnownTypes[1] != "notification" {
+		t.Fatalf("unexpected known types: %v", body.KnownTypes)
+	}
+}
+
+func TestEnqueue_TypePolicyDefaultsAndOverride(t *testing.T) {
+	reg := q.NewRegistry()
+	reg.RegisterType("report", noopHandler(), q.TypePolicy{MaxRetries: 5, Timeout: 3 * time.Second})
+	store := q.NewStore()
+	h := newTypedHandler(store, make(chan q.Task, 2), reg)
+
+	for _, body := range []string{
+		`{"id":"r1","type":"report","payload":"p"}`,
+		`{"id":"r2","type":"report","payload":"p","max_retries":1}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/enqueue", bytes.NewReader([]byte(body)))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("expected 202 for %s, got %d", body, rr.Code)
+		}
+	}
+	r1, _ := store.Get("r1")
+	if r1.Type != "report" || r1.MaxRetries != 5 || r1.Timeout != 3*time.Second {
+		t.Fatalf("policy defaults not applied: %+v", r1)
+	}
+	r2, _ := store.Get("r2")
+	if r2.MaxRetries != 1 {
+		t.Fatalf("explicit max_retries must override policy, got %d", r2.MaxRetries)
+	}
+}
+
+func TestWorkers_RouteByTypeAndEnforceTimeout(t *testing.T) {
+	store := q.NewStore()
+	ch := make(chan q.Task, 4)
+	var scans, notes atomic.Int32
+	reg := q.NewRegistry()
+	reg.Register("image_scan", q.HandlerFunc(func(ctx context.Context, _ q.Task) (q.Result, error) {
+		scans.Add(1)
+		return q.Result{}, nil
+	}))
+	reg.Register("notification", q.HandlerFunc(func(ctx context.Context, _ q.Task) (q.Result, error) {
+		notes.Add(1)
+		return q.Result{}, nil
+	}))
+	reg.RegisterType("hang", q.HandlerFunc(func(ctx context.Context, _ q.Task) (q.Result, error) {
+		<-ctx.Done()
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			t.Errorf("expected deadline, got %v", ctx.Err())
+		}
+		return q.Result{}, ctx.Err()
+	}), q.TypePolicy{Timeout: 20 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	q.StartWorkerPool(ctx, &wg, store, q.ChanQueue(ch), q.WorkerConfig{Workers: 2, Registry: reg})
+
+	h := newTypedHandler(store, ch, reg)
+	for _, body := range []string{
+		`{"id":"s1","type":"image_scan","payload":"p"}`,
+		`{"id":"n1","type":"notification","payload":"p"}`,
+		`{"id":"h1","type":"hang","payload":"p"}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/enqueue", bytes.NewReader([]byte(body)))
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusAccepted {
+			t.Fatalf("expected 202 for %s, got %d", body, rr.Code)
+		}
+	}
+	waitForStatus(t, store, "s1", q.StatusDone, time.Second)
+	waitForStatus(t, store, "n1", q.StatusDone, time.Second)
+	waitForStatus(t, store, "h1", q.StatusFailed, time.Second)
+	cancel()
+	wg.Wait()
+	if scans.Load() != 1 || notes.Load() != 1 {
+		t.Fatalf("unexpected routing: scans=%d notifications=%d", scans.Load(), notes.Load())
+	}
+}
+
+func TestEnqueue_DefaultTypeForUntypedRequests(t *testing.T) {
+	reg := q.NewRegistry()
+	reg.RegisterType("simulate", noopHandler(), q.TypePolicy{MaxRetries: 2})
+	store := q.NewStore()
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{
+		Store: store, Queue: q.ChanQueue(make(chan q.Task, 1)), Accepting: &acc, Registry: reg, DefaultType: "simulate",
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/enqueue", bytes.NewReader([]byte(`{"id":"legacy","payload":"p"}`)))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for an untyped request, got %d %s", rr.Code, rr.Body.String())
+	}
+	if task, _ := store.Get("legacy"); task.Type != "simulate" || task.MaxRetries != 2 {
+		t.Fatalf("untyped request must get the default type and its policy: %+v", task)
+	}
+
+	// without a default type an untyped request names no registered type
+	h = newTypedHandler(q.NewStore(), make(chan q.Task, 1), reg)
+	req = httptest.NewRequest(http.MethodPost, "/enqueue", bytes.NewReader([]byte(`{"id":"legacy","payload":"p"}`)))
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without a default type, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+)
+
+func TestEnqueue_TimeoutField(t *testing.T) {
+	store := q.NewStore()
+	registry := q.NewRegistry()
+	registry.RegisterType("scan", noopHandler(), q.TypePolicy{Timeout: time.Minute})
+	var acc atomic.Bool
+	acc.Store(true)
+	h := httpserver.NewHandlerWithOptions(httpserver.Options{
+		Store:      store,
+		Queue:      q.NewPriorityQueue(8, 0),
+		Accepting:  &acc,
+		Registry:   registry,
+		MaxTimeout: 5 * time.Minute,
+	})
+
+	for _, body := range []string{
+		`{"id":"t1","type":"scan","payload":"1","timeout":"250ms"}`,
+		`{"id":"t2","type":"scan","payload":"1"}`,
+	} {
+		if rr := postEnqueue(h, body); rr.Code != http.StatusAccepted {
+			t.Fatalf("expected 202 for %s, got %d: %s", body, rr.Code, rr.Body.String())
+		}
+	}
+	if got, _ := store.Get("t1"); got.Timeout != 250*time.Millisecond {
+		t.Fatalf("requested timeout not stored: %v", got.Timeout)
+	}
+	if got, _ := store.Get("t2"); got.Timeout != time.Minute {
+		t.Fatalf("type default timeout not applied: %v", got.Timeout)
+	}
+	for _, body := range []string{
+		`{"id":"x1","type":"scan","payload":"1","timeout":"soon"}`,
+		`{"id":"x2","type":"scan","payload":"1","timeout":"-1s"}`,
+		`{"id":"x3","type":"scan","payload":"1","timeout":"1h"}`,
+	} {
+		rr := postEnqueue(h, body)
+		var resp struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(rr.Body.Bytes(), &resp)
+		if rr.Code != http.StatusBadRequest || resp.Error != "invalid_timeout" {
+			t.Fatalf("expected 400 invalid_timeout for %s, got %d %s", body, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestWorker_TimeoutFreesWorkerAndIsRetried(t *testing.T) {
+	store := q.NewStore()
+	queues := q.NewQueueSet([]q.QueueSpec{{Name: q.DefaultQueueName, Capacity: 4, Workers: 1, MaxRetries: -1, BackoffBase: time.Millisecond}}, 0)
+	hung := make(chan struct{})
+	var unhang sync.Once
+	defer unhang.Do(func() { close(hung) })
+	var hangs atomic.Int32
+	registry := q.NewRegistry()
+	registry.Register("hang", q.HandlerFunc(func(context.Context, q.Task) (q.Result, error) {
+		hangs.Add(1)
+		<-hung // ignores its context entirely
+		return q.Result{}, nil
+	}))
+	registry.Register("quick", noopHandler())
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	queues.Start(ctx, &wg, store, q.WorkerConfig{Registry: registry})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for _, task := range []q.Task{
+		{ID: "h1", Type: "hang", MaxRetries: 1, Timeout: 20 * time.Millisecond, Status: q.StatusQueued},
+		{ID: "q1", Type: "quick", Status: q.StatusQueued},
+	} {
+		store.Save(task)
+		queues.TryPush(task)
+	}
+	got := waitForStatus(t, store, "h1", q.StatusFailed, 2*time.Second)
+	// the single worker was not blocked by the hung handler
+	waitForStatus(t, store, "q1", q.StatusDone, time.Second)
+	if hangs.Load() != 2 || len(got.Attempts) != 2 {
+		t.Fatalf("expected a timed out attempt and one retry, got calls=%d attempts=%+v", hangs.Load(), got.Attempts)
+	}
+	for _, rec := range got.Attempts {
+		if rec.Outcome != q.OutcomeTimeout || !strings.Contains(rec.Error, q.ErrTimeout.Error()) {
+			t.Fatalf("unexpected attempt: %+v", rec)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	newQueuesHandler(store, queues).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	var m struct {
+		Timeouts  uint64
+		Abandoned uint64
+		Failed    uint64
+		Queues    map[string]q.QueueMetrics
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &m); err != nil {
+		t.Fatalf("invalid metrics body: %v", err)
+	}
+	if m.Timeouts != 2 || m.Queues[q.DefaultQueueName].Timeouts != 2 || m.Failed != 1 {
+		t.Fatalf("unexpected metrics: %s", rr.Body.String())
+	}
+	// both hung handlers are still running in the background
+	if m.Abandoned != 2 || m.Queues[q.DefaultQueueName].Abandoned != 2 {
+		t.Fatalf("expected 2 abandoned handlers: %s", rr.Body.String())
+	}
+	unhang.Do(func() { close(hung) })
+	deadline := time.Now().Add(time.Second)
+	for queues.Metrics()[q.DefaultQueueName].Abandoned != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("abandoned handlers must be released once they return")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWorker_TimeoutFromTypeAndGlobalCap(t *testing.T) {
+	store := q.NewStore()
+	registry := q.NewRegistry()
+	slow := q.HandlerFunc(func(ctx context.Context, _ q.Task) (q.Result, error) {
+		<-ctx.Done()
+		return q.Result{}, ctx.Err()
+	})
+	registry.RegisterType("typed", slow, q.TypePolicy{Timeout: 20 * time.Millisecond})
+	registry.RegisterType("long", slow, q.TypePolicy{Timeout: time.Hour})
+	registry.Register("untyped", slow)
+	ch := make(chan q.Task, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	q.StartWorkerPool(ctx, &wg, store, q.ChanQueue(ch), q.WorkerConfig{Workers: 3, Registry: registry, MaxTimeout: 50 * time.Millisecond})
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// none of the tasks carries a timeout of its own, as with cron or recovered tasks
+	for _, id := range []string{"typed", "long", "untyped"} {
+		task := q.Task{ID: id, Type: id, Status: q.StatusQueued}
+		store.Save(task)
+		ch <- task
+	}
+	for _, id := range []string{"typed", "long", "untyped"} {
+		got := waitForStatus(t, store, id, q.StatusFailed, time.Second)
+		if len(got.Attempts) != 1 || got.Attempts[0].Outcome != q.OutcomeTimeout {
+			t.Fatalf("%s: expected a timed out attempt, got %+v", id, got.Attempts)
+		}
+		took := got.Attempts[0].FinishedAt.Sub(got.Attempts[0].StartedAt)
+		if took > 500*time.Millisecond {
+			t.Fatalf("%s: deadline not enforced, attempt took %v", id, took)
+		}
+	}
+}
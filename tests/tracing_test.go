@@ -0,0 +1,322 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	httpserver "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/http"
+	q "github.com/optongroup/kaspersky-safeboard-go-container-security/internal/queue"
+	"github.com/optongroup/kaspersky-safeboard-go-container-security/internal/tracing"
+)
+
+const (
+	testTraceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testParentID    = "00f067aa0ba902b7"
+	testTraceparent = "00-" + testTraceID + "-" + testParentID + "-01"
+)
+
+// spanRecorder is an in-memory tracing.Exporter.
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(s tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, s)
+	return nil
+}
+
+// byName returns the recorded spans named name.
+func (r *spanRecorder) byName(name string) []tracing.SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []tracing.SpanData
+	for _, s := range r.spans {
+		if s.Name == name {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func TestTracing_Parse(t *testing.T) {
+	sc, err := tracing.Parse(testTraceparent, "vendor=a,other=b")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if sc.TraceID.String() != testTraceID || sc.SpanID.String() != testParentID || !sc.Sampled() || sc.State != "vendor=a,other=b" {
+		t.Fatalf("unexpected span context %+v", sc)
+	}
+	if sc.Traceparent() != testTraceparent {
+		t.Fatalf("round trip: got %q", sc.Traceparent())
+	}
+
+	// a future version is read by its version 00 prefix
+	sc, err = tracing.Parse("01-"+testTraceID+"-"+testParentID+"-00-extra", "")
+	if err != nil || sc.Sampled() || sc.TraceID.String() != testTraceID {
+		t.Fatalf("future version: %+v %v", sc, err)
+	}
+	sc, err = tracing.Parse(testTraceparent, strings.Repeat("a", tracing.MaxTraceState+1))
+	if err != nil || sc.State != "" {
+		t.Fatalf("oversized tracestate must be dropped, got %q %v", sc.State, err)
+	}
+
+	for _, h := range []string{
+		"",
+		"garbage",
+		"00-" + strings.ToUpper(testTraceID) + "-" + testParentID + "-01",
+		"00-00000000000000000000000000000000-" + testParentID + "-01",
+		"00-" + testTraceID + "-0000000000000000-01",
+		"ff-" + testTraceID + "-" + testParentID + "-01",
+		"00-" + testTraceID + "-" + testParentID + "-01-extra",
+		"00_" + testTraceID + "-" + testParentID + "-01",
+	} {
+		if _, err := tracing.Parse(h, ""); !errors.Is(err, tracing.ErrInvalidTraceparent) {
+			t.Fatalf("%q: expected ErrInvalidTraceparent, got %v", h, err)
+		}
+	}
+}
+
+// tracedServer runs one worker and the HTTP handler with tracer; handled receives the span
+// context seen by the "traced" handler.
+func tracedServer(t *testing.T, tracer *tracing.Tracer, handled chan<- tracing.SpanContext) (http.Handler, q.Store) {
+	t.Helper()
+	store := q.NewStore()
+	queues := q.NewQueueSet([]q.QueueSpec{{Name: q.DefaultQueueName, Capacity: 8, Workers: 1, MaxRetries: -1, BackoffBase: time.Millisecond}}, 0)
+	registry := q.NewRegistry()
+	registry.Register("traced", q.HandlerFunc(func(ctx context.Context, task q.Task) (q.Result, error) {
+		sc, _ := tracing.SpanContextFromContext(ctx)
+		handled <- sc
+		if task.Attempt == 0 {
+			return q.Result{}, errors.New("try again")
+		}
+		return q.Result{}, nil
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	queues.Start(ctx, &wg, store, q.WorkerConfig{Registry: registry, Tracer: tracer})
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+	var acc atomic.Bool
+	acc.Store(true)
+	return httpserver.NewHandlerWithOptions(httpserver.Options{Store: store, Queues: queues, Accepting: &acc, Registry: registry, Tracer: tracer}), store
+}
+
+func postTraced(h http.Handler, body, traceparent, tracestate string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/enqueue", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if traceparent != "" {
+		req.Header.Set("traceparent", traceparent)
+	}
+	if tracestate != "" {
+		req.Header.Set("tracestate", tracestate)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestTracing_SpansFromEnqueueToAttempts(t *testing.T) {
+	var rec spanRecorder
+	handled := make(chan tracing.SpanContext, 8)
+	h, store := tracedServer(t, tracing.NewTracer(&rec), handled)
+
+	rr := postTraced(h, `{"id":"tr1","type":"traced","payload":{},"max_retries":1}`, testTraceparent, "vendor=a")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d %s", rr.Code, rr.Body.String())
+	}
+	waitForStatus(t, store, "tr1", q.StatusDone, 2*time.Second)
+
+	enqueue := rec.byName("enqueue")
+	if len(enqueue) != 1 || enqueue[0].TraceID != testTraceID || enqueue[0].ParentSpanID != testParentID || enqueue[0].Status != tracing.StatusOK {
+		t.Fatalf("unexpected enqueue spans %+v", enqueue)
+	}
+	task, _ := store.Get("tr1")
+	if task.TraceParent != "00-"+testTraceID+"-"+enqueue[0].SpanID+"-01" || task.TraceState != "vendor=a" {
+		t.Fatalf("task must carry the enqueue span context, got %q %q", task.TraceParent, task.TraceState)
+	}
+
+	attempts := rec.byName("task.attempt")
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempt spans, got %+v", attempts)
+	}
+	for i, s := range attempts {
+		if s.TraceID != testTraceID || s.ParentSpanID != enqueue[0].SpanID || s.TraceState != "vendor=a" {
+			t.Fatalf("attempt %d: not a child of the enqueue span: %+v", i, s)
+		}
+		if s.Attributes["task.attempt"] != i || s.Attributes["task.id"] != "tr1" || s.Attributes["worker.id"] != "default-1" {
+			t.Fatalf("attempt %d: unexpected attributes %v", i, s.Attributes)
+		}
+		sc := <-handled
+		if sc.TraceID.String() != testTraceID || sc.SpanID.String() != s.SpanID {
+			t.Fatalf("attempt %d: handler context %+v does not match span %s", i, sc, s.SpanID)
+		}
+	}
+	if attempts[0].Status != tracing.StatusError || attempts[0].Error != "try again" || attempts[1].Status != tracing.StatusOK {
+		t.Fatalf("unexpected attempt outcomes %+v", attempts)
+	}
+	waits := rec.byName("queue.wait")
+	if len(waits) != 2 {
+		t.Fatalf("expected a queue.wait span per attempt, got %+v", waits)
+	}
+	for _, s := range waits {
+		if s.ParentSpanID != enqueue[0].SpanID || s.End.Before(s.Start) {
+			t.Fatalf("unexpected queue.wait span %+v", s)
+		}
+	}
+}
+
+func TestTracing_RootTraceAndUnsampled(t *testing.T) {
+	var rec spanRecorder
+	handled := make(chan tracing.SpanContext, 8)
+	h, store := tracedServer(t, tracing.NewTracer(&rec), handled)
+
+	// a malformed header is ignored: the enqueue span starts a new trace
+	if rr := postTraced(h, `{"id":"root","type":"traced","payload":{},"max_retries":1}`, "bogus", ""); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	waitForStatus(t, store, "root", q.StatusDone, 2*time.Second)
+	enqueue := rec.byName("enqueue")
+	if len(enqueue) != 1 || enqueue[0].ParentSpanID != "" || enqueue[0].TraceID == testTraceID {
+		t.Fatalf("expected a root enqueue span, got %+v", enqueue)
+	}
+	if attempts := rec.byName("task.attempt"); len(attempts) != 2 || attempts[0].TraceID != enqueue[0].TraceID {
+		t.Fatalf("attempts must join the new trace, got %+v", attempts)
+	}
+
+	// an unsampled caller is propagated to the handler but nothing is exported
+	unsampled := "00-" + testTraceID + "-" + testParentID + "-00"
+	if rr := postTraced(h, `{"id":"quiet","type":"traced","payload":{},"max_retries":1}`, unsampled, ""); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	waitForStatus(t, store, "quiet", q.StatusDone, 2*time.Second)
+	rec.mu.Lock()
+	exported := len(rec.spans)
+	rec.mu.Unlock()
+	if exported != 5 {
+		t.Fatalf("unsampled task must export no spans, got %d in total", exported)
+	}
+	<-handled
+	<-handled
+	if sc := <-handled; sc.TraceID.String() != testTraceID || sc.Sampled() {
+		t.Fatalf("unexpected handler context %+v", sc)
+	}
+}
+
+func TestTracing_PropagatesWithoutTracer(t *testing.T) {
+	handled := make(chan tracing.SpanContext, 8)
+	h, store := tracedServer(t, nil, handled)
+
+	if rr := postTraced(h, `{"id":"plain","type":"traced","payload":{},"max_retries":1}`, testTraceparent, "vendor=a"); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	task := waitForStatus(t, store, "plain", q.StatusDone, 2*time.Second)
+	if task.TraceParent != testTraceparent || task.TraceState != "vendor=a" {
+		t.Fatalf("headers must be stored verbatim, got %q %q", task.TraceParent, task.TraceState)
+	}
+	if sc := <-handled; sc.Traceparent() != testTraceparent {
+		t.Fatalf("handler must see the caller's context, got %q", sc.Traceparent())
+	}
+
+	if rr := postTraced(h, `{"id":"untraced","type":"traced","payload":{},"max_retries":1}`, "", ""); rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	task = waitForStatus(t, store, "untraced", q.StatusDone, 2*time.Second)
+	if task.TraceParent != "" {
+		t.Fatalf("untraced task got traceparent %q", task.TraceParent)
+	}
+	<-handled
+	if sc := <-handled; sc.IsValid() {
+		t.Fatalf("untraced handler got span context %+v", sc)
+	}
+}
+
+func TestTracing_FileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exp, err := tracing.NewFileExporter(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	tracer := tracing.NewTracer(exp)
+	parent, _ := tracing.Parse(testTraceparent, "")
+	start := time.Now()
+	root := tracer.Start(parent, "first", start)
+	root.SetAttributes("k", "v")
+	root.End(start.Add(time.Millisecond), nil)
+	tracer.Start(root.Context(), "second", start).End(start.Add(time.Millisecond), errors.New("boom"))
+	if err := exp.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open spans: %v", err)
+	}
+	defer f.Close()
+	var spans []tracing.SpanData
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var s tracing.SpanData
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			t.Fatalf("invalid line %q: %v", sc.Text(), err)
+		}
+		spans = append(spans, s)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(spans))
+	}
+	if spans[0].Name != "first" || spans[0].TraceID != testTraceID || spans[0].ParentSpanID != testParentID || spans[0].Attributes["k"] != "v" {
+		t.Fatalf("unexpected first span %+v", spans[0])
+	}
+	if spans[1].ParentSpanID != spans[0].SpanID || spans[1].Status != tracing.StatusError || spans[1].Error != "boom" {
+		t.Fatalf("unexpected second span %+v", spans[1])
+	}
+}
+
+func TestTracing_BatchItemsShareTheRequestTrace(t *testing.T) {
+	var rec spanRecorder
+	handled := make(chan tracing.SpanContext, 8)
+	h, store := tracedServer(t, tracing.NewTracer(&rec), handled)
+
+	for _, mode := range []string{"best_effort", "atomic"} {
+		req := httptest.NewRequest(http.MethodPost, "/enqueue/batch?mode="+mode, strings.NewReader(
+			`[{"id":"`+mode+`-1","type":"traced","payload":{},"max_retries":1},{"id":"`+mode+`-2","type":"traced","payload":{},"max_retries":1}]`))
+		req.Header.Set("traceparent", testTraceparent)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != http.StatusAccepted && rr.Code != http.StatusOK && rr.Code != http.StatusMultiStatus {
+			t.Fatalf("%s: unexpected status %d %s", mode, rr.Code, rr.Body.String())
+		}
+	}
+	for _, id := range []string{"best_effort-1", "best_effort-2", "atomic-1", "atomic-2"} {
+		task := waitForStatus(t, store, id, q.StatusDone, 2*time.Second)
+		sc, ok := task.SpanContext()
+		if !ok || sc.TraceID.String() != testTraceID {
+			t.Fatalf("task %s: expected trace %s, got %q", id, testTraceID, task.TraceParent)
+		}
+	}
+	enqueue := rec.byName("enqueue")
+	if len(enqueue) != 4 {
+		t.Fatalf("expected an enqueue span per item, got %+v", enqueue)
+	}
+	for _, s := range enqueue {
+		if s.ParentSpanID != testParentID || s.Status != tracing.StatusOK {
+			t.Fatalf("unexpected enqueue span %+v", s)
+		}
+	}
+}